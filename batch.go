@@ -0,0 +1,279 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+)
+
+// BatchGetter is a Getter that can load many keys at once,
+// eg. with one query to the database.
+// GetMany of Group uses it for the misses this node owns.
+// A key left out of the returned map is an error for that key,
+// KeyErrors can be returned to tell why.
+type BatchGetter interface {
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// KeyErrors holds why each of the failed keys of a batch failed
+type KeyErrors map[string]error
+
+func (ke KeyErrors) Error() string {
+	keys := make([]string, 0, len(ke))
+	for key := range ke {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%d keys failed", len(ke)))
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf("\n%s: %v", key, ke[key]))
+	}
+	return builder.String()
+}
+
+// GetMany gets many keys at once.
+// Hits are served from mainCache/hotCache. Misses are grouped by owner so
+// that each peer gets one BatchQuery, and misses owned by this node go to
+// the Getter in one call if it's a BatchGetter.
+// With WithReplicas, misses go to their primary first and those it fails
+// go on to the next owner in another batch, as Get fails over.
+// Values of the keys that succeed are always returned, the error is a
+// KeyErrors if only some keys failed.
+// Unlike Get, loads in a batch aren't deduplicated by singleflight
+// nor hedged.
+func (g *Group) GetMany(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	if err := g.checkOpen(); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]ByteView, len(keys))
+	errs := KeyErrors{}
+	// owners left to ask for each miss, a nil owner is this node
+	misses := make(map[string][]PeerGetter)
+	seen := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			errs[key] = errors.New("key is empty at group.GetMany()")
+			continue
+		}
+		g.stats.gets.Add(1)
+		if bv, ok := g.lookupCache(key); ok {
+			ret[key] = bv
+			continue
+		}
+		misses[key] = g.batchOwners(ctx, key)
+	}
+	if err := ctx.Err(); err != nil && len(misses) > 0 {
+		return ret, err
+	}
+
+	for len(misses) > 0 {
+		misses = g.askOwners(ctx, misses, ret, errs)
+	}
+
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+// batchOwners returns the owners a missed key is asked from in order,
+// picked the same way load picks them
+func (g *Group) batchOwners(ctx context.Context, key string) []PeerGetter {
+	owners := g.replicaOwners(key)
+	if owners == nil {
+		pGetter, ok := g.peers.PickPeer(key)
+		if !ok {
+			pGetter = nil
+		}
+		return []PeerGetter{pGetter}
+	}
+	if fromPeer(ctx) {
+		// see loadFromReplicas
+		for _, owner := range owners {
+			if owner == nil {
+				return []PeerGetter{nil}
+			}
+		}
+	}
+	return owners
+}
+
+// askOwners sends each miss to the first of its owners, one batch per owner.
+// It returns the misses that failed and have owners left to ask.
+func (g *Group) askOwners(ctx context.Context, misses map[string][]PeerGetter,
+	ret map[string]ByteView, errs KeyErrors) map[string][]PeerGetter {
+	byOwner := make(map[PeerGetter][]string)
+	for key, owners := range misses {
+		byOwner[owners[0]] = append(byOwner[owners[0]], key)
+	}
+
+	left := make(map[string][]PeerGetter)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for owner, keys := range byOwner {
+		wg.Add(1)
+		go func(owner PeerGetter, keys []string) {
+			defer wg.Done()
+			var values map[string]ByteView
+			var failed KeyErrors
+			if owner == nil {
+				values, failed = g.getManyLocally(ctx, keys)
+			} else {
+				values, failed = g.getManyFromPeer(ctx, owner, keys)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for key, value := range values {
+				ret[key] = value
+			}
+			for key, err := range failed {
+				if owners := misses[key]; len(owners) > 1 && ctx.Err() == nil {
+					left[key] = owners[1:]
+					continue
+				}
+				errs[key] = err
+			}
+		}(owner, keys)
+	}
+	wg.Wait()
+	return left
+}
+
+// getManyFromPeer sends one BatchQuery if the peer supports it,
+// otherwise it falls back to loading key by key
+func (g *Group) getManyFromPeer(ctx context.Context, pGetter PeerGetter, keys []string) (map[string]ByteView, KeyErrors) {
+	bGetter, ok := pGetter.(BatchPeerGetter)
+	if !ok {
+		return g.loadEach(ctx, pGetter, keys)
+	}
+
+	g.logger.Debug("getting batch from peer", "keys", len(keys), "peer", peerName(pGetter))
+	ctx, _ = withLoadHints(ctx)
+	values, err := bGetter.GetMany(ctx, g.name, keys)
+	ret, errs := splitBatch(keys, values, err)
+	for key, value := range ret {
+		if cacheable(ctx) && g.hotPolicy.ShouldPromote(key, value) {
+			g.hotCache.addWithTTL(key, value, g.ttl)
+		}
+	}
+	g.stats.peerLoads.Add(int64(len(ret)))
+	g.stats.peerErrors.Add(int64(len(errs)))
+	return ret, errs
+}
+
+// getManyLocally asks the Getter for all keys at once if it's a BatchGetter,
+// otherwise it falls back to loading key by key
+func (g *Group) getManyLocally(ctx context.Context, keys []string) (map[string]ByteView, KeyErrors) {
+	bGetter, ok := g.getter.(BatchGetter)
+	if !ok {
+		return g.loadEach(ctx, nil, keys)
+	}
+
+	values, err := bGetter.GetMany(ctx, keys)
+	ret, errs := splitBatch(keys, values, err)
+	for key, value := range ret {
+		if cacheable(ctx) {
+			g.populateCache(key, value)
+		}
+	}
+	g.stats.localLoads.Add(int64(len(ret)))
+	g.stats.localLoadErrs.Add(int64(len(errs)))
+	return ret, errs
+}
+
+// loadEach loads the keys one by one from pGetter, see loadFrom
+func (g *Group) loadEach(ctx context.Context, pGetter PeerGetter, keys []string) (map[string]ByteView, KeyErrors) {
+	ret := make(map[string]ByteView, len(keys))
+	errs := KeyErrors{}
+	for _, key := range keys {
+		keyCtx, _ := withLoadHints(ctx)
+		value, err := g.loadFrom(keyCtx, key, pGetter)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		ret[key] = value
+	}
+	return ret, errs
+}
+
+// splitBatch tells for each of keys whether the batch got its value.
+// err applies to all keys unless it's a KeyErrors.
+func splitBatch(keys []string, values map[string][]byte, err error) (map[string]ByteView, KeyErrors) {
+	ret := make(map[string]ByteView, len(values))
+	errs := KeyErrors{}
+	keyErrs, partial := err.(KeyErrors)
+	for _, key := range keys {
+		if err != nil && !partial {
+			errs[key] = err
+			continue
+		}
+		if keyErr, ok := keyErrs[key]; ok {
+			errs[key] = keyErr
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			errs[key] = errors.New("key is missing from the batch")
+			continue
+		}
+		ret[key] = ByteView{b: value}
+	}
+	return ret, errs
+}
+
+// toBatchResponse encodes the result of GetMany for the wire
+func toBatchResponse(keys []string, values map[string]ByteView, err error) *pb.BatchResponse {
+	keyErrs, _ := err.(KeyErrors)
+	resp := &pb.BatchResponse{Entries: make([]*pb.BatchResponse_Entry, 0, len(keys))}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		entry := &pb.BatchResponse_Entry{Key: key}
+		if value, ok := values[key]; ok {
+			entry.Value = value.Get()
+		} else if keyErr, ok := keyErrs[key]; ok {
+			entry.Error = keyErr.Error()
+		} else if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Error = "key is missing from the batch"
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+	return resp
+}
+
+// fromBatchResponse decodes a BatchResponse,
+// keys that failed remotely come back as a KeyErrors
+func fromBatchResponse(resp *pb.BatchResponse) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(resp.Entries))
+	errs := KeyErrors{}
+	for _, entry := range resp.Entries {
+		if entry.Error != "" {
+			errs[entry.Key] = errors.New(entry.Error)
+			continue
+		}
+		values[entry.Key] = entry.Value
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
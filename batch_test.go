@@ -0,0 +1,158 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// batchPeer owns the keys starting with "r" and answers them in batches
+type batchPeer struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (bp *batchPeer) Get(group string, key string) ([]byte, error) {
+	return nil, errors.New("batchPeer should only be asked in batches")
+}
+
+func (bp *batchPeer) GetMany(ctx context.Context, group string, keys []string) (map[string][]byte, error) {
+	bp.mu.Lock()
+	bp.batches = append(bp.batches, keys)
+	bp.mu.Unlock()
+
+	ret := make(map[string][]byte)
+	errs := KeyErrors{}
+	for _, key := range keys {
+		if strings.HasSuffix(key, "bad") {
+			errs[key] = errors.New("bad key")
+			continue
+		}
+		ret[key] = []byte("remote " + key)
+	}
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+func (bp *batchPeer) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "r") {
+		return bp, true
+	}
+	return nil, false
+}
+
+// batchGetter loads locally owned keys and records the batches it's asked
+type batchGetter struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (bg *batchGetter) Get(key string) ([]byte, error) {
+	return nil, errors.New("batchGetter should only be asked in batches")
+}
+
+func (bg *batchGetter) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	bg.mu.Lock()
+	bg.batches = append(bg.batches, keys)
+	bg.mu.Unlock()
+
+	ret := make(map[string][]byte)
+	for _, key := range keys {
+		// missing keys are left out
+		if !strings.HasSuffix(key, "missing") {
+			ret[key] = []byte("local " + key)
+		}
+	}
+	return ret, nil
+}
+
+func TestGroupGetMany(t *testing.T) {
+	peer := &batchPeer{}
+	getter := &batchGetter{}
+	g := newTestGroup(t, "batchGroup", 1000, getter, WithHotCachePolicy(AlwaysPromote))
+	g.peers = peer
+
+	keys := []string{"l1", "r1", "l2", "r2", "rbad", "lmissing", "l1"}
+	ret, err := g.GetMany(context.Background(), keys)
+
+	keyErrs, ok := err.(KeyErrors)
+	if !ok || len(keyErrs) != 2 || keyErrs["rbad"] == nil || keyErrs["lmissing"] == nil {
+		t.Fatalf("expecting rbad and lmissing to fail, got %v", err)
+	}
+	want := map[string]string{"l1": "local l1", "l2": "local l2", "r1": "remote r1", "r2": "remote r2"}
+	if len(ret) != len(want) {
+		t.Errorf("expecting %d values, got %v", len(want), ret)
+	}
+	for key, value := range want {
+		if ret[key].String() != value {
+			t.Errorf("key %s: expecting %s, got %s", key, value, ret[key].String())
+		}
+	}
+	if len(peer.batches) != 1 || len(peer.batches[0]) != 3 {
+		t.Errorf("expecting one batch of 3 keys to the peer, got %v", peer.batches)
+	}
+	if len(getter.batches) != 1 || len(getter.batches[0]) != 3 {
+		t.Errorf("expecting one batch of 3 keys to the getter, got %v", getter.batches)
+	}
+
+	// hits are served from mainCache and hotCache
+	ret, err = g.GetMany(context.Background(), []string{"l1", "r1"})
+	if err != nil || len(ret) != 2 {
+		t.Fatalf("unexpected ret/err %v/%v", ret, err)
+	}
+	if len(peer.batches) != 1 || len(getter.batches) != 1 {
+		t.Error("cached keys shouldn't be loaded again")
+	}
+
+	stats := g.Stats()
+	if stats.PeerLoads != 2 || stats.PeerErrors != 1 || stats.LocalLoads != 2 || stats.LocalLoadErrs != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestGroupGetManyFallback(t *testing.T) {
+	// neither the peer nor the getter can batch, so keys are loaded one by one
+	peer := &fakePeer{}
+	g := newTestGroup(t, "batchFallbackGroup", 1000, nil)
+	g.peers = peer
+
+	ret, err := g.GetMany(context.Background(), []string{"a", "b", "c"})
+	if err != nil || len(ret) != 3 || ret["b"].String() != "b" {
+		t.Fatalf("unexpected ret/err %v/%v", ret, err)
+	}
+	if peer.count != 3 {
+		t.Errorf("expecting 3 peer calls, got %d", peer.count)
+	}
+}
+
+func TestHTTPGetterGetMany(t *testing.T) {
+	g := newTestGroup(t, "httpBatchGroup", 1000, &batchGetter{})
+	p := NewHTTPPool(19633)
+	g.RegisterPeers(p)
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	hg := &HTTPGetter{baseURL: server.URL + defaultBasePath}
+	ret, err := hg.GetMany(context.Background(), "httpBatchGroup", []string{"a", "bmissing", "c"})
+	keyErrs, ok := err.(KeyErrors)
+	if !ok || len(keyErrs) != 1 || keyErrs["bmissing"] == nil {
+		t.Errorf("expecting bmissing to fail, got %v", err)
+	}
+	if len(ret) != 2 || string(ret["a"]) != "local a" || string(ret["c"]) != "local c" {
+		t.Errorf("unexpected values %v", ret)
+	}
+
+	_, err = hg.GetMany(context.Background(), "notExist", []string{"a"})
+	if err == nil {
+		t.Error("expecting an error for unknown group")
+	}
+	if _, partial := err.(KeyErrors); partial {
+		t.Error("unknown group should fail the whole batch")
+	}
+}
@@ -2,6 +2,7 @@ package geecache
 
 import (
 	"sync"
+	"time"
 
 	"github.com/Hawk-Zhou/better-groupcache/lru_k"
 )
@@ -13,12 +14,20 @@ type cache struct {
 	mu       sync.Mutex
 	lru      *lru_k.KCache
 	maxBytes int
+	nget     int64
+	nhit     int64
+	// closed caches hold nothing, see close
+	closed bool
 }
 
 // thread safe
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.nget++
+	if c.closed {
+		return
+	}
 	if c.lru == nil {
 		c.lru = lru_k.NewK(c.maxBytes, func(s string, v lru_k.Value) {})
 		return
@@ -32,15 +41,97 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	if !ok {
 		return ByteView{}, ok
 	}
+	c.nhit++
 	return ret.(ByteView), ok
 }
 
 // thread safe
 func (c *cache) add(key string, value ByteView) error {
+	return c.addWithTTL(key, value, 0)
+}
+
+// thread safe, ttl <= 0 means never expire
+func (c *cache) addWithTTL(key string, value ByteView, ttl time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
 	if c.lru == nil {
 		c.lru = lru_k.NewK(c.maxBytes, nil)
 	}
-	return c.lru.Add(key, value)
+	return c.lru.AddWithTTL(key, value, ttl)
+}
+
+// thread safe
+func (c *cache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	return c.lru.Remove(key)
+}
+
+// thread safe
+func (c *cache) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.RemoveExpired()
+}
+
+// close frees all entries, adds are ignored after it
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = nil
+	c.closed = true
+}
+
+// janitor reclaims the bytes of expired entries in the background.
+// Without it expired entries are only dropped when they are looked up
+// or pushed out by newer entries.
+type janitor struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func startJanitor(interval time.Duration, caches ...*cache) *janitor {
+	j := &janitor{stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, c := range caches {
+					c.removeExpired()
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Stop can be called more than once
+func (j *janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
+
+// thread safe
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := CacheStats{Gets: c.nget, Hits: c.nhit}
+	if c.lru != nil {
+		ret.Stats = c.lru.Stats()
+	}
+	return ret
 }
@@ -1,12 +1,14 @@
 package consistentHash
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"hash/fnv"
 	"log"
-	"math/rand"
-	"time"
+	"math"
+	"sort"
 
 	"github.com/google/btree"
 )
@@ -18,10 +20,11 @@ import (
 const (
 	defaultSaltLen  = 1 //bytes
 	defaultVtFactor = 50
+	defaultRetries  = 10
 )
 
 type vNode struct {
-	hash uint32
+	hash uint64
 	name string
 }
 
@@ -31,50 +34,188 @@ func (vn vNode) Less(than btree.Item) bool {
 
 type CHash struct {
 	// when hashing, salt is added to avoid possible collision
-	hasher     Hasher
+	hasher     Hasher64
+	hasherName string // "" if the hasher isn't a built-in one
 	NameToSalt map[string][]byte
-	vNodes     *btree.BTree
-	vtFactor   int // how many vNode a physical node is mapped to
-	saltLen    int
+	// a node of weight w is mapped to w*vtFactor vNodes
+	nameToWeight map[string]int
+	nameToLabels map[string]Labels
+	vNodes       *btree.BTree
+	vtFactor     int // how many vNode a physical node of weight 1 is mapped to
+	saltLen      int
+	retries      int // how many salts AddNode tries on collisions
+	saltFunc     SaltFunc
+	saltFuncName string // "" for getSalt
+	totalWeight  int
+
+	// loads is nil unless bounded loads are on, see SetBoundedLoad
+	loads   LoadReporter
+	epsilon float64
 }
 
+// Hasher hashes to 32 bits, eg. crc32.ChecksumIEEE. Its hashes are the
+// high words of positions on the ring, see Hasher64.
 type Hasher func(b []byte) uint32
 
-func init() {
-	seed := time.Now().UnixNano()
-	rand.Seed(seed)
-	log.Println("seed is set to", seed)
+// Hasher64 hashes to a position on the 64-bit ring, see NewCHash64
+type Hasher64 func(b []byte) uint64
+
+// widen places the hashes of h on the ring in the order of h, so a ring
+// of a Hasher places keys as it did when positions were of 32 bits
+func (h Hasher) widen() Hasher64 {
+	return func(b []byte) uint64 {
+		return uint64(h(b)) << 32
+	}
+}
+
+// Labels tell where a node is, so that replicas can be spread across
+// failure domains. Nodes without a zone (or rack) are taken as being in
+// a zone (or rack) of their own.
+type Labels struct {
+	Zone string
+	Rack string
+}
+
+// LoadReporter tells how loaded nodes are, eg. by their in-flight requests.
+// It's called on every lookup, so it should be cheap and safe for
+// concurrent use.
+type LoadReporter interface {
+	Load(name string) int64
+	TotalLoad() int64
 }
 
-func getSalt(size int) ([]byte, error) {
+// getSalt returns the i-th salt, which is i in big endian.
+// Salts used to be random, which made two processes adding the same
+// peers end up with different rings. Probing them in order makes the
+// ring a function of the membership.
+func getSalt(size int, i int) ([]byte, error) {
 	ret := make([]byte, size)
-	rn, err := rand.Read(ret)
-	if err != nil {
-		return nil, err
+	for b := size - 1; b >= 0; b-- {
+		ret[b] = byte(i)
+		i >>= 8
 	}
-	if rn != size {
-		return nil, errors.New("can't read enough bytes from rand")
+	if i != 0 {
+		return nil, errors.New("salt index doesn't fit in salt length")
 	}
-	return ret, err
+	return ret, nil
 }
 
-func NewCHash(hasher Hasher) *CHash {
+// NewCHash returns an empty ring that hashes with hasher, CRC32 if nil.
+// See NewCHashByName for the built-in hashers.
+func NewCHash(hasher Hasher, opts ...Option) *CHash {
 	if hasher == nil {
-		hasher = crc32.ChecksumIEEE
+		return NewCHash64(nil, opts...)
+	}
+	return newCHash(hasher.widen(), "", opts)
+}
+
+// NewCHash64 is NewCHash for a hasher of 64 bits, CRC32 if nil
+func NewCHash64(hasher Hasher64, opts ...Option) *CHash {
+	if hasher == nil {
+		return newCHash(Hasher(crc32.ChecksumIEEE).widen(), CRC32, opts)
+	}
+	return newCHash(hasher, "", opts)
+}
+
+func newCHash(hasher Hasher64, hasherName string, opts []Option) *CHash {
+	ch := &CHash{
+		hasher:       hasher,
+		hasherName:   hasherName,
+		vtFactor:     defaultVtFactor,
+		NameToSalt:   make(map[string][]byte),
+		nameToWeight: make(map[string]int),
+		nameToLabels: make(map[string]Labels),
+		vNodes:       btree.New(2),
+		saltLen:      defaultSaltLen,
+		retries:      defaultRetries,
+		saltFunc:     getSalt,
+	}
+	for _, opt := range opts {
+		opt(ch)
+	}
+	return ch
+}
+
+// NewCHashByName returns an empty ring that hashes with a built-in hasher,
+// see HasherByName. The ring records the hasher, see HasherName.
+func NewCHashByName(name string, opts ...Option) (*CHash, error) {
+	hasher, canonical, err := lookupHasher(name)
+	if err != nil {
+		return nil, err
+	}
+	return newCHash(hasher, canonical, opts), nil
+}
+
+// HasherName tells which built-in hasher built the ring, with its seed
+// spelled out, eg. "xxhash64:0". It's "" if the hasher was given to NewCHash or NewCHash64.
+func (ch *CHash) HasherName() string {
+	return ch.hasherName
+}
+
+// Clone returns a copy of the ring that can be changed
+// without affecting ch. The btree is copied lazily, on write.
+// CHash isn't safe for concurrent use, but ch can still be read
+// while the clone is being changed.
+func (ch *CHash) Clone() *CHash {
+	nameToSalt := make(map[string][]byte, len(ch.NameToSalt))
+	for name, salt := range ch.NameToSalt {
+		nameToSalt[name] = salt
+	}
+	nameToWeight := make(map[string]int, len(ch.nameToWeight))
+	for name, weight := range ch.nameToWeight {
+		nameToWeight[name] = weight
+	}
+	nameToLabels := make(map[string]Labels, len(ch.nameToLabels))
+	for name, labels := range ch.nameToLabels {
+		nameToLabels[name] = labels
 	}
 	return &CHash{
-		hasher:     hasher,
-		vtFactor:   defaultVtFactor,
-		NameToSalt: make(map[string][]byte),
-		vNodes:     btree.New(2),
-		saltLen:    defaultSaltLen,
+		hasher:       ch.hasher,
+		hasherName:   ch.hasherName,
+		NameToSalt:   nameToSalt,
+		nameToWeight: nameToWeight,
+		nameToLabels: nameToLabels,
+		vNodes:       ch.vNodes.Clone(),
+		vtFactor:     ch.vtFactor,
+		saltLen:      ch.saltLen,
+		retries:      ch.retries,
+		saltFunc:     ch.saltFunc,
+		saltFuncName: ch.saltFuncName,
+		totalWeight:  ch.totalWeight,
+		loads:        ch.loads,
+		epsilon:      ch.epsilon,
 	}
 }
 
-// groupHash hashes a name to a set of vNodes with salt
-func (ch *CHash) groupHash(name string, salt []byte) []uint32 {
-	ret := make([]uint32, 0, ch.vtFactor)
-	for v := 0; v < ch.vtFactor; v++ {
+// SetBoundedLoad turns on consistent hashing with bounded loads
+// (Mirrokni et al., 2016). A node takes a query only if its load is below
+//
+//	ceil((1+epsilon) * (total load+1) * weight / total weight)
+//
+// otherwise the query walks clockwise to the next node that is,
+// so hot keys spill over to the following nodes instead of piling up
+// on their owner. Smaller epsilon balances better but sends more
+// queries away from their owners. A nil loads turns it off.
+func (ch *CHash) SetBoundedLoad(loads LoadReporter, epsilon float64) {
+	if epsilon < 0 {
+		epsilon = 0
+	}
+	ch.loads, ch.epsilon = loads, epsilon
+}
+
+// underCapacity tells whether a node can take one more query
+func (ch *CHash) underCapacity(name string, total int64) bool {
+	share := float64(ch.nameToWeight[name]) / float64(ch.totalWeight)
+	capacity := math.Ceil((1 + ch.epsilon) * float64(total+1) * share)
+	return float64(ch.loads.Load(name)) < capacity
+}
+
+// groupHash hashes a name to a set of vNodes with salt,
+// there are vtFactor of them for each unit of weight
+func (ch *CHash) groupHash(name string, salt []byte, weight int) []uint64 {
+	n := ch.vtFactor * weight
+	ret := make([]uint64, 0, n)
+	for v := 0; v < n; v++ {
 		suffixedName := name + fmt.Sprint(v)
 		b := make([]byte, 0, len(suffixedName)+ch.saltLen)
 		b = append(b, []byte(suffixedName)...)
@@ -84,7 +225,7 @@ func (ch *CHash) groupHash(name string, salt []byte) []uint32 {
 	return ret
 }
 
-func (ch *CHash) insertVNode(hash uint32, salt []byte, name string) error {
+func (ch *CHash) insertVNode(hash uint64, salt []byte, name string) error {
 	replaced := ch.vNodes.ReplaceOrInsert(vNode{hash: hash, name: name})
 	if replaced != nil {
 		return errors.New("shouldn't be replacing existing vNode")
@@ -92,7 +233,7 @@ func (ch *CHash) insertVNode(hash uint32, salt []byte, name string) error {
 	return nil
 }
 
-func (ch *CHash) deleteVNode(hash uint32) error {
+func (ch *CHash) deleteVNode(hash uint64) error {
 	deleted := ch.vNodes.Delete(vNode{hash: hash})
 	if deleted == nil {
 		return errors.New("shouldn't be deleting non-existent vNode")
@@ -100,7 +241,7 @@ func (ch *CHash) deleteVNode(hash uint32) error {
 	return nil
 }
 
-func (ch *CHash) getNearestNode(queryHash uint32) (name string) {
+func (ch *CHash) getNearestNode(queryHash uint64) (name string) {
 	if ch.vNodes.Len() == 0 {
 		panic("No vNode exists!")
 	}
@@ -125,7 +266,7 @@ func (ch *CHash) RemoveNode(name string) error {
 	if !ok {
 		return errors.New("node name doesn't exist")
 	}
-	vNodes := ch.groupHash(name, salt)
+	vNodes := ch.groupHash(name, salt, ch.nameToWeight[name])
 	var err error = nil
 	for _, h := range vNodes {
 		err = ch.deleteVNode(h)
@@ -133,13 +274,23 @@ func (ch *CHash) RemoveNode(name string) error {
 			log.Panicf("fatal error at RemoveNode: %v", err.Error())
 		}
 	}
+	ch.totalWeight -= ch.nameToWeight[name]
 	delete(ch.NameToSalt, name)
+	delete(ch.nameToWeight, name)
+	delete(ch.nameToLabels, name)
 	return nil
 }
 
-func (ch *CHash) ifDuplicatedHashes(hashes []uint32) bool {
+// ifDuplicatedHashes tells whether the hashes collide with the vNodes
+// on the ring or with each other
+func (ch *CHash) ifDuplicatedHashes(hashes []uint64) bool {
+	seen := make(map[uint64]bool, len(hashes))
 	for _, u := range hashes {
-		got := ch.vNodes.Get(vNode{hash: uint32(u)})
+		if seen[u] {
+			return true
+		}
+		seen[u] = true
+		got := ch.vNodes.Get(vNode{hash: u})
 		if got != nil {
 			return true
 		}
@@ -147,44 +298,257 @@ func (ch *CHash) ifDuplicatedHashes(hashes []uint32) bool {
 	return false
 }
 
+// AddNode puts the node on the ring with the first salt, in order,
+// whose vNodes don't collide with existing ones nor with each other.
+// Collisions are rare, so the ring usually doesn't depend on the order
+// nodes are added. When one does happen, the node added later takes the
+// next salt, so add nodes in a fixed (eg. sorted) order if every process
+// must agree on the ring even then.
 func (ch *CHash) AddNode(name string) error {
+	return ch.AddNodeWeighted(name, 1)
+}
+
+// AddNodeWeighted is AddNode for a node that takes weight times
+// the share of the ring a node of weight 1 takes,
+// eg. a node of 64 GB could be given 8 times the weight of one of 8 GB.
+func (ch *CHash) AddNodeWeighted(name string, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("weight of %s must be at least 1, got %d", name, weight)
+	}
 	if _, ok := ch.NameToSalt[name]; ok {
 		return errors.New("the node already exists")
 	}
 
 changeSalt:
-	for i := 0; i < 10; i++ {
-		salt, err := getSalt(ch.saltLen)
+	for i := 0; i < ch.retries; i++ {
+		salt, err := ch.saltFunc(ch.saltLen, i)
 		if err != nil {
 			return fmt.Errorf("can't add node: %w", err)
 		}
-		generatedVNodes := ch.groupHash(name, salt)
+		generatedVNodes := ch.groupHash(name, salt, weight)
 		if ch.ifDuplicatedHashes(generatedVNodes) {
 			continue changeSalt
 		}
 		// success
-
-		for _, hash := range generatedVNodes {
-			err := ch.insertVNode(hash, salt, name)
-			if err != nil {
-				// this is bad, now it's inconsistent
-				log.Panicf("unexpected error at AddNote: %+v", err)
-			}
-		}
-
-		ch.NameToSalt[name] = salt
-		return nil
+		return ch.insertNode(name, salt, weight, generatedVNodes)
 	}
 	// fail
 	// don't change the prompt, it's tested and compared
 	return errors.New("too many vNode number collisions after retries")
 }
 
-// FindNode matches a query to a node
+// insertNode puts the vNodes of a node on the ring. It errs and leaves
+// the ring as it was if they collide with existing ones or each other.
+func (ch *CHash) insertNode(name string, salt []byte, weight int, vNodes []uint64) error {
+	if ch.ifDuplicatedHashes(vNodes) {
+		return fmt.Errorf("vNodes of %s collide with others", name)
+	}
+	for _, hash := range vNodes {
+		if err := ch.insertVNode(hash, salt, name); err != nil {
+			return fmt.Errorf("unexpected error at AddNode: %w", err)
+		}
+	}
+
+	ch.NameToSalt[name] = salt
+	ch.nameToWeight[name] = weight
+	ch.totalWeight += weight
+	return nil
+}
+
+// FindNode matches a query to a node.
+// With bounded loads on, it's the first node clockwise
+// that is under its capacity.
 func (ch *CHash) FindNode(query string) (name string) {
-	return ch.getNearestNode(ch.hasher([]byte(query)))
+	queryHash := ch.hasher([]byte(query))
+	name = ch.getNearestNode(queryHash)
+	if ch.loads == nil {
+		return name
+	}
+	total := ch.loads.TotalLoad()
+	if bounded, ok := ch.walk(queryHash, func(name string) bool {
+		return ch.underCapacity(name, total)
+	}); ok {
+		return bounded
+	}
+	return name
+}
+
+// FindNodeFunc walks the ring clockwise from the query and returns
+// the first node accept says yes to, eg. the first healthy one.
+// Each node is asked once. It returns false if no node is accepted.
+// With bounded loads on, accepted nodes that are over capacity are
+// skipped, unless every accepted node is.
+func (ch *CHash) FindNodeFunc(query string, accept func(name string) bool) (name string, ok bool) {
+	queryHash := ch.hasher([]byte(query))
+	if ch.loads != nil {
+		total := ch.loads.TotalLoad()
+		if name, ok = ch.walk(queryHash, func(name string) bool {
+			return accept(name) && ch.underCapacity(name, total)
+		}); ok {
+			return name, ok
+		}
+	}
+	return ch.walk(queryHash, accept)
+}
+
+// FindN returns up to n distinct nodes met walking the ring clockwise
+// from the query, the owner first. Bounded loads don't apply.
+func (ch *CHash) FindN(query string, n int) []string {
+	ret := make([]string, 0)
+	if n <= 0 {
+		return ret
+	}
+	ch.walk(ch.hasher([]byte(query)), func(name string) bool {
+		ret = append(ret, name)
+		return len(ret) >= n
+	})
+	return ret
+}
+
+func (ch *CHash) walk(queryHash uint64, accept func(name string) bool) (name string, ok bool) {
+	asked := make(map[string]bool)
+	visit := func(item btree.Item) bool {
+		thisNode := item.(vNode)
+		if asked[thisNode.name] {
+			return true
+		}
+		asked[thisNode.name] = true
+		if accept(thisNode.name) {
+			name, ok = thisNode.name, true
+			return false
+		}
+		// stop once every node is asked
+		return len(asked) < len(ch.NameToSalt)
+	}
+	ch.vNodes.AscendGreaterOrEqual(vNode{hash: queryHash}, visit)
+	if !ok && len(asked) < len(ch.NameToSalt) {
+		// wrap around
+		ch.vNodes.AscendLessThan(vNode{hash: queryHash}, visit)
+	}
+	return name, ok
+}
+
+// SetLabels tells where a node is, see FindNSpread
+func (ch *CHash) SetLabels(name string, labels Labels) error {
+	if _, ok := ch.NameToSalt[name]; !ok {
+		return errors.New("node name doesn't exist")
+	}
+	if labels == (Labels{}) {
+		delete(ch.nameToLabels, name)
+		return nil
+	}
+	ch.nameToLabels[name] = labels
+	return nil
+}
+
+// Labels returns where a node is, empty if unknown
+func (ch *CHash) Labels(name string) Labels {
+	return ch.nameToLabels[name]
+}
+
+// FindNSpread is FindN that spreads the nodes across zones, then racks.
+// Walking clockwise, it takes nodes of zones it hasn't taken yet first,
+// then nodes of new racks, then whatever is left, so losing a zone
+// loses as few of the nodes as possible. The owner is always first.
+func (ch *CHash) FindNSpread(query string, n int) []string {
+	queryHash := ch.hasher([]byte(query))
+	return Spread(n, ch.Labels, func(visit func(name string) bool) {
+		ch.walk(queryHash, visit)
+	})
+}
+
+// Spread picks up to n of the nodes that walk visits in order of
+// preference, zones first, then racks, see FindNSpread.
+// walk stops once visit returns true.
+func Spread(n int, labels func(name string) Labels, walk func(visit func(name string) bool)) []string {
+	ret := make([]string, 0)
+	if n <= 0 {
+		return ret
+	}
+	zones := make(map[string]bool)
+	racks := make(map[Labels]bool)
+	take := func(name string, l Labels) {
+		ret = append(ret, name)
+		if l.Zone != "" {
+			zones[l.Zone] = true
+		}
+		if l.Rack != "" {
+			racks[l] = true
+		}
+	}
+
+	skipped := make([]string, 0)
+	walk(func(name string) bool {
+		if l := labels(name); l.Zone == "" || !zones[l.Zone] {
+			take(name, l)
+		} else {
+			skipped = append(skipped, name)
+		}
+		return len(ret) >= n
+	})
+
+	// skipped are all in zones taken already
+	left := make([]string, 0, len(skipped))
+	for _, name := range skipped {
+		if len(ret) >= n {
+			return ret
+		}
+		if l := labels(name); l.Rack == "" || !racks[l] {
+			take(name, l)
+		} else {
+			left = append(left, name)
+		}
+	}
+	for _, name := range left {
+		if len(ret) >= n {
+			break
+		}
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// Weight returns the weight of a node, 0 if it doesn't exist
+func (ch *CHash) Weight(name string) int {
+	return ch.nameToWeight[name]
+}
+
+// Fingerprint identifies the ring by its Params, nodes, their salts, weights and labels.
+// Rings built from the same membership have the same fingerprint,
+// so peers can compare it to tell whether they agree on who owns a key.
+func (ch *CHash) Fingerprint() uint64 {
+	names := make([]string, 0, len(ch.NameToSalt))
+	for name := range ch.NameToSalt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	b := make([]byte, 8)
+	params := ch.Params().String()
+	binary.BigEndian.PutUint64(b, uint64(len(params)))
+	h.Write(b)
+	h.Write([]byte(params))
+	for _, name := range names {
+		// length prefixed so that names can't run into salts
+		binary.BigEndian.PutUint64(b, uint64(len(name)))
+		h.Write(b)
+		h.Write([]byte(name))
+		h.Write(ch.NameToSalt[name])
+		binary.BigEndian.PutUint64(b, uint64(ch.nameToWeight[name]))
+		h.Write(b)
+		// labels decide the replicas
+		labels := ch.nameToLabels[name]
+		for _, label := range []string{labels.Zone, labels.Rack} {
+			binary.BigEndian.PutUint64(b, uint64(len(label)))
+			h.Write(b)
+			h.Write([]byte(label))
+		}
+	}
+	return h.Sum64()
 }
 
+// Len is the number of nodes, regardless of their weights
 func (ch *CHash) Len() int {
-	return ch.vNodes.Len() / ch.vtFactor
+	return len(ch.NameToSalt)
 }
@@ -3,20 +3,37 @@ package consistentHash
 import (
 	"container/list"
 	"fmt"
+	"hash/crc32"
+	"math"
 	"math/rand"
 	"reflect"
 	"testing"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"google.golang.org/protobuf/proto"
 )
 
 var expected_strings = list.New()
 var determined_hash = list.New()
 
 func TestSalt(t *testing.T) {
-	b, err := getSalt(1)
-	if err != nil {
-		t.Error("unexpected error")
+	data := []struct {
+		size    int
+		i       int
+		want    []byte
+		wantErr bool
+	}{
+		{1, 0, []byte{0}, false},
+		{1, 255, []byte{255}, false},
+		{1, 256, nil, true},
+		{2, 258, []byte{1, 2}, false},
+	}
+	for _, d := range data {
+		b, err := getSalt(d.size, d.i)
+		if (err != nil) != d.wantErr || !reflect.DeepEqual(b, d.want) {
+			t.Errorf("getSalt(%d, %d) = %v, %v", d.size, d.i, b, err)
+		}
 	}
-	t.Logf("got %+v\n", b)
 }
 
 func riggedHash(salted []byte) uint32 {
@@ -38,16 +55,17 @@ func TestGroupHash(t *testing.T) {
 	ch := NewCHash(riggedHash)
 	name := "foo"
 	salt := make([]byte, defaultSaltLen)
-	expect_hashes := make([]uint32, 0, defaultVtFactor)
+	expect_hashes := make([]uint64, 0, defaultVtFactor)
 	for i := 0; i < defaultVtFactor; i++ {
 		// foo + str(i) for i in range(defaultVtFactor)
 		// expecting (foo1, foo2,..., fooN)
 		// giving hash (1,2,...,N)
 		expected_strings.PushBack(name + fmt.Sprint(i))
 		determined_hash.PushBack(uint32(i))
-		expect_hashes = append(expect_hashes, uint32(i))
+		// a 32-bit hash is the high word of the position
+		expect_hashes = append(expect_hashes, uint64(i)<<32)
 	}
-	got_hashes := ch.groupHash(name, salt)
+	got_hashes := ch.groupHash(name, salt, 1)
 	if !reflect.DeepEqual(got_hashes, expect_hashes) {
 		t.Error("got unexpected hash value")
 		println(expect_hashes)
@@ -59,7 +77,7 @@ func TestVNodeOps(t *testing.T) {
 	// this tests normal logic, another test deals with errors
 	ch := NewCHash(nil)
 	data := []struct {
-		hash uint32
+		hash uint64
 	}{
 		{114514},
 		{114},
@@ -93,22 +111,22 @@ func TestVNodeOps(t *testing.T) {
 	// test ifDuplicatedHashes
 	for i := 0; i < 1919; i++ {
 		if i != 114 && i != 514 {
-			if ch.ifDuplicatedHashes([]uint32{uint32(i)}) != false {
+			if ch.ifDuplicatedHashes([]uint64{uint64(i)}) != false {
 				t.Error("ifDuplicatedHashes gives false positive")
 			}
 		} else {
-			if ch.ifDuplicatedHashes([]uint32{uint32(i)}) != true {
+			if ch.ifDuplicatedHashes([]uint64{uint64(i)}) != true {
 				t.Error("ifDuplicatedHashes gives false negative")
 			}
 		}
 	}
 	for i := 114500; i < 114600; i++ {
 		if i != 114514 {
-			if ch.ifDuplicatedHashes([]uint32{uint32(i)}) != false {
+			if ch.ifDuplicatedHashes([]uint64{uint64(i)}) != false {
 				t.Error("ifDuplicatedHashes gives false positive")
 			}
 		} else {
-			if ch.ifDuplicatedHashes([]uint32{uint32(i)}) != true {
+			if ch.ifDuplicatedHashes([]uint64{uint64(i)}) != true {
 				t.Error("ifDuplicatedHashes gives false negative")
 			}
 		}
@@ -220,7 +238,644 @@ func TestAbnormalNodeOps(t *testing.T) {
 	for j := 0; j < ch.vtFactor; j++ {
 		determined_hash.PushBack(uint32(j))
 	}
-	ch.deleteVNode(3) // remains 0 1 2
+	ch.deleteVNode(3 << 32) // remains 0 1 2
 	ch.RemoveNode("this hashed to 0 1 2 3")
 
 }
+
+func TestDeterministicRing(t *testing.T) {
+	names := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("http://10.0.0.%d:8000/geecache/", i))
+	}
+	shuffled := append([]string{}, names...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	ch1, ch2 := NewCHash(nil), NewCHash(nil)
+	for i := range names {
+		if err := ch1.AddNode(names[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch2.AddNode(shuffled[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(ch1.NameToSalt, ch2.NameToSalt) {
+		t.Error("salts differ")
+	}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint("key", i)
+		if n1, n2 := ch1.FindNode(key), ch2.FindNode(key); n1 != n2 {
+			t.Fatalf("rings disagree on %s: %s vs %s", key, n1, n2)
+		}
+	}
+}
+
+func TestFindNodeFunc(t *testing.T) {
+	ch := NewCHash(nil)
+	for i := 0; i < 5; i++ {
+		ch.AddNode(fmt.Sprint("node", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		owner := ch.FindNode(key)
+		if got, ok := ch.FindNodeFunc(key, func(string) bool { return true }); !ok || got != owner {
+			t.Fatalf("accepting all should find the owner %s, got %s", owner, got)
+		}
+
+		// the fallback is the node that owns the key once the owner is gone
+		ch.RemoveNode(owner)
+		next := ch.FindNode(key)
+		ch.AddNode(owner)
+		got, ok := ch.FindNodeFunc(key, func(name string) bool { return name != owner })
+		if !ok || got != next {
+			t.Fatalf("skipping %s should find %s, got %s", owner, next, got)
+		}
+	}
+
+	asked := 0
+	if _, ok := ch.FindNodeFunc("key", func(string) bool { asked++; return false }); ok {
+		t.Error("no node should be found")
+	}
+	if asked != 5 {
+		t.Errorf("each node should be asked once, got %d", asked)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	ch1, ch2 := NewCHash(nil), NewCHash(nil)
+	if ch1.Fingerprint() != ch2.Fingerprint() {
+		t.Error("empty rings should agree")
+	}
+	ch1.AddNode("a")
+	ch1.AddNode("b")
+	ch2.AddNode("b")
+	if ch1.Fingerprint() == ch2.Fingerprint() {
+		t.Error("rings of different nodes should differ")
+	}
+	ch2.AddNode("a")
+	if ch1.Fingerprint() != ch2.Fingerprint() {
+		t.Error("rings of the same nodes should agree regardless of order")
+	}
+	ch1.RemoveNode("b")
+	ch1.AddNode("b" + "\x00")
+	if ch1.Fingerprint() == ch2.Fingerprint() {
+		t.Error("rings of different names should differ")
+	}
+}
+
+func TestClone(t *testing.T) {
+	ch := NewCHash(nil)
+	ch.AddNode("a")
+	ch.AddNode("b")
+	before := ch.Fingerprint()
+
+	clone := ch.Clone()
+	if clone.Fingerprint() != before {
+		t.Error("clone should be the same ring")
+	}
+	clone.RemoveNode("a")
+	clone.AddNode("c")
+	if ch.Fingerprint() != before || ch.Len() != 2 {
+		t.Error("changing the clone shouldn't change the original")
+	}
+	for i := 0; i < 1000; i++ {
+		if owner := ch.FindNode(fmt.Sprint(i)); owner == "c" {
+			t.Fatal("the original shouldn't see nodes added to the clone")
+		}
+	}
+}
+
+func TestWeightedNodes(t *testing.T) {
+	ch := NewCHash(nil)
+	if err := ch.AddNodeWeighted("zero", 0); err == nil {
+		t.Error("weight 0 should be rejected")
+	}
+	for i := 0; i < 4; i++ {
+		ch.AddNode(fmt.Sprint("small", i))
+	}
+	if err := ch.AddNodeWeighted("big", 4); err != nil {
+		t.Fatal(err)
+	}
+	if ch.Len() != 5 || ch.vNodes.Len() != 8*ch.vtFactor {
+		t.Errorf("got %d nodes and %d vNodes", ch.Len(), ch.vNodes.Len())
+	}
+	if ch.Weight("big") != 4 || ch.Weight("small0") != 1 || ch.Weight("none") != 0 {
+		t.Error("got wrong weights")
+	}
+
+	// big should handle about half of the queries
+	querySize := 100000
+	big := 0
+	for i := 0; i < querySize; i++ {
+		if ch.FindNode(fmt.Sprint("key", i)) == "big" {
+			big++
+		}
+	}
+	if per := float64(big) / float64(querySize); per < 0.35 || per > 0.65 {
+		t.Errorf("big handles %.2f of the queries, expecting about 0.5", per)
+	}
+
+	before := ch.Fingerprint()
+	if err := ch.RemoveNode("big"); err != nil {
+		t.Fatal(err)
+	}
+	if ch.Len() != 4 || ch.vNodes.Len() != 4*ch.vtFactor || ch.Weight("big") != 0 {
+		t.Error("all vNodes of big should be removed")
+	}
+	ch.AddNodeWeighted("big", 2)
+	if ch.Fingerprint() == before {
+		t.Error("rings of different weights should differ")
+	}
+}
+
+type fakeLoads map[string]int64
+
+func (fl fakeLoads) Load(name string) int64 {
+	return fl[name]
+}
+
+func (fl fakeLoads) TotalLoad() int64 {
+	total := int64(0)
+	for _, load := range fl {
+		total += load
+	}
+	return total
+}
+
+func TestSelfCollidingNode(t *testing.T) {
+	// vNodes of a node collide with each other under salt 0 only
+	rigged := func(b []byte) uint64 {
+		if b[len(b)-1] == 0 {
+			return 42
+		}
+		return xxhash64(b, 0)
+	}
+	ch := NewCHash64(rigged)
+	if err := ch.AddNodeWeighted("big", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if ch.vNodes.Len() != 1000*ch.vtFactor || ch.NameToSalt["big"][0] != 1 {
+		t.Errorf("big should take the next salt, got %v and %d vNodes", ch.NameToSalt["big"], ch.vNodes.Len())
+	}
+
+	ch = NewCHash64(rigged, WithRetries(1))
+	if err := ch.AddNodeWeighted("big", 2); err == nil {
+		t.Error("a node colliding with itself should be refused")
+	}
+	if ch.Len() != 0 || ch.vNodes.Len() != 0 {
+		t.Error("a refused node should leave the ring as it was")
+	}
+
+	// 32-bit positions of so many vNodes collide now and then
+	for _, name := range []string{Murmur3, XXHash64} {
+		for i := 0; i < 10; i++ {
+			ch, _ := NewCHashByName(name)
+			if err := ch.AddNodeWeighted(fmt.Sprint("peer", i), 1000); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	ch := NewCHash(nil)
+	for i := 0; i < 4; i++ {
+		ch.AddNode(fmt.Sprint("node", i))
+	}
+	owner := ch.FindNode("hot")
+	loads := fakeLoads{}
+	ch.SetBoundedLoad(loads, 0.25)
+
+	// queries for a hot key spill over once its owner is full,
+	// no node ever goes above its capacity
+	for i := 0; i < 100; i++ {
+		name := ch.FindNode("hot")
+		capacity := math.Ceil(1.25 * float64(loads.TotalLoad()+1) / 4)
+		if float64(loads[name]) >= capacity {
+			t.Fatalf("%s is at %d, over the capacity of %v", name, loads[name], capacity)
+		}
+		loads[name]++
+	}
+	if len(loads) != 4 || loads[owner] > 32 {
+		t.Errorf("the hot key should be spread over all nodes, got %v", loads)
+	}
+
+	// when every node it accepts is full, FindNodeFunc still finds one
+	got, ok := ch.FindNodeFunc("hot", func(name string) bool { return name == owner })
+	if !ok || got != owner {
+		t.Errorf("should fall back to %s, got %s", owner, got)
+	}
+
+	ch.SetBoundedLoad(nil, 0)
+	if ch.FindNode("hot") != owner {
+		t.Error("turning bounded loads off should go back to the owner")
+	}
+}
+
+func TestFindN(t *testing.T) {
+	ch := NewCHash(nil)
+	if got := ch.FindN("key", 3); len(got) != 0 {
+		t.Errorf("empty ring should find nothing, got %v", got)
+	}
+	for i := 0; i < 5; i++ {
+		ch.AddNode(fmt.Sprint("node", i))
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		got := ch.FindN(key, 3)
+		if len(got) != 3 || got[0] != ch.FindNode(key) {
+			t.Fatalf("expecting 3 nodes with the owner first, got %v", got)
+		}
+		if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+			t.Fatalf("nodes should be distinct, got %v", got)
+		}
+		// the second is who owns the key once the owner is gone
+		clone := ch.Clone()
+		clone.RemoveNode(got[0])
+		if clone.FindNode(key) != got[1] {
+			t.Fatalf("%s should take over %s from %s", got[1], key, got[0])
+		}
+	}
+	if got := ch.FindN("key", 10); len(got) != 5 {
+		t.Errorf("there are only 5 nodes to find, got %v", got)
+	}
+}
+
+// zoned is a synthetic topology of 3 zones of 2 racks of 2 nodes
+func zoned() *CHash {
+	ch := NewCHash(nil)
+	for _, zone := range []string{"a", "b", "c"} {
+		for rack := 0; rack < 2; rack++ {
+			for i := 0; i < 2; i++ {
+				name := fmt.Sprintf("%s-%d-%d", zone, rack, i)
+				ch.AddNode(name)
+				ch.SetLabels(name, Labels{Zone: zone, Rack: fmt.Sprint(rack)})
+			}
+		}
+	}
+	return ch
+}
+
+func TestFindNSpread(t *testing.T) {
+	ch := zoned()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		got := ch.FindNSpread(key, 3)
+		if len(got) != 3 || got[0] != ch.FindNode(key) {
+			t.Fatalf("expecting 3 nodes with the owner first, got %v", got)
+		}
+		zones := make(map[string]bool)
+		for _, name := range got {
+			zones[ch.Labels(name).Zone] = true
+		}
+		if len(zones) != 3 {
+			t.Fatalf("nodes should be in 3 zones, got %v", got)
+		}
+
+		// with more than 3, racks are spread as well
+		racks := make(map[Labels]bool)
+		for _, name := range ch.FindNSpread(key, 6) {
+			racks[ch.Labels(name)] = true
+		}
+		if len(racks) != 6 {
+			t.Fatalf("6 nodes should be in 6 racks, got %v", racks)
+		}
+		if got := ch.FindNSpread(key, 20); len(got) != 12 {
+			t.Fatalf("there are only 12 nodes to find, got %v", got)
+		}
+	}
+
+	// without labels it's FindN
+	plain := NewCHash(nil)
+	for i := 0; i < 5; i++ {
+		plain.AddNode(fmt.Sprint("node", i))
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		if fmt.Sprint(plain.FindNSpread(key, 3)) != fmt.Sprint(plain.FindN(key, 3)) {
+			t.Fatalf("unlabeled nodes should be found as FindN does")
+		}
+	}
+
+	if err := ch.SetLabels("nowhere", Labels{Zone: "a"}); err == nil {
+		t.Error("labeling a node that doesn't exist should fail")
+	}
+	before := ch.Fingerprint()
+	clone := ch.Clone()
+	clone.SetLabels("a-0-0", Labels{Zone: "b", Rack: "0"})
+	if clone.Fingerprint() == before || ch.Labels("a-0-0").Zone != "a" {
+		t.Error("labels should be part of the fingerprint and not shared by clones")
+	}
+	clone.RemoveNode("a-0-0")
+	if clone.Labels("a-0-0") != (Labels{}) {
+		t.Error("labels should be removed with the node")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := NewCHash(nil)
+	for i := 0; i < 5; i++ {
+		before.AddNode(fmt.Sprint("node", i))
+	}
+	if moves := Diff(before, before.Clone()); len(moves) != 0 {
+		t.Errorf("the same ring shouldn't move anything, got %v", moves)
+	}
+
+	after := before.Clone()
+	after.AddNode("node5")
+	moves := Diff(before, after)
+	for i, m := range moves {
+		if m.To != "node5" {
+			t.Fatalf("adding a node should only move keys to it, got %+v", m)
+		}
+		for _, hash := range []uint64{m.Start, m.End} {
+			if before.ownerOf(hash) != m.From || after.ownerOf(hash) != m.To {
+				t.Fatalf("%d should move from %s to %s", hash, m.From, m.To)
+			}
+		}
+		if i > 0 && moves[i-1].End >= m.Start {
+			t.Fatalf("moves should be in order and not overlap, got %+v %+v", moves[i-1], m)
+		}
+	}
+	// what moves is exactly what the new node owns
+	share := MovedShare(moves)
+	if math.Abs(share-after.Distribution()["node5"]) > 1e-9 {
+		t.Errorf("moved %f, node5 owns %f", share, after.Distribution()["node5"])
+	}
+
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+	}
+	if got := MovedPercent(before, after, keys); math.Abs(got-100*share) > 3 {
+		t.Errorf("sample says %.2f%% moved, the ranges say %.2f%%", got, 100*share)
+	}
+
+	// removing it moves the same ranges back
+	back := Diff(after, before)
+	if len(back) != len(moves) || MovedShare(back) != share {
+		t.Errorf("removing should undo adding, got %d moves for %d", len(back), len(moves))
+	}
+	fromEmpty := Diff(NewCHash(nil), before)
+	if math.Abs(MovedShare(fromEmpty)-1) > 1e-9 || fromEmpty[0].From != "" || fromEmpty[0].Start != 0 {
+		t.Errorf("everything should move from the empty ring, got %v", fromEmpty[0])
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	ch := NewCHash(nil)
+	if len(ch.Distribution()) != 0 {
+		t.Error("empty ring should own nothing")
+	}
+	ch.AddNode("node0")
+	if got := ch.Distribution()["node0"]; got != 1 {
+		t.Errorf("a single node should own everything, got %f", got)
+	}
+	for i := 1; i < 5; i++ {
+		ch.AddNodeWeighted(fmt.Sprint("node", i), i)
+	}
+	total := 0.0
+	for name, share := range ch.Distribution() {
+		// node i is of weight i, node0 of weight 1, 11 in total
+		weight := math.Max(1, float64(ch.Weight(name)))
+		if ideal := weight / 11; math.Abs(share-ideal) > ideal/2 {
+			t.Errorf("%s should own about %f, got %f", name, ideal, share)
+		}
+		total += share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("shares should add up to 1, got %f", total)
+	}
+}
+
+func TestHashers(t *testing.T) {
+	// reference values of the algorithms
+	for _, c := range []struct {
+		name string
+		in   string
+		want uint64
+	}{
+		{"xxh32", "", 0x02cc5d05},
+		{"xxh32", "abc", 0x32d153ff},
+		{"xxh32", "Nobody inspects the spammish repetition", 0xe2293b2f},
+		{"xxh64", "", 0xef46db3751d8e999},
+		{"xxh64", "abc", 0x44bc2cf5ad770999},
+		{"xxh64", "Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+		{"murmur3", "", 0},
+		{"murmur3", "hello", 0x248bfa47},
+		{"murmur3", "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	} {
+		var got uint64
+		switch c.name {
+		case "xxh32":
+			got = uint64(xxhash32([]byte(c.in), 0))
+		case "xxh64":
+			got = xxhash64([]byte(c.in), 0)
+		case "murmur3":
+			got = uint64(murmur3([]byte(c.in), 0))
+		}
+		if got != c.want {
+			t.Errorf("%s(%q) = %#x, want %#x", c.name, c.in, got, c.want)
+		}
+	}
+
+	for _, name := range []string{CRC32, FNV1a, Murmur3, XXHash, XXHash64, FNV1a64Mix} {
+		ch, err := NewCHashByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			ch.AddNode(fmt.Sprint("peer", i))
+		}
+		// short similar names should still spread evenly
+		for node, share := range ch.Distribution() {
+			if share < 0.05 || share > 0.2 {
+				t.Errorf("%s gives %s %.3f of the ring", name, node, share)
+			}
+		}
+	}
+
+	seeded, _ := NewCHashByName("xxhash64:42")
+	unseeded, _ := NewCHashByName(XXHash64)
+	if seeded.HasherName() != "xxhash64:42" || unseeded.HasherName() != "xxhash64:0" {
+		t.Errorf("got %s and %s", seeded.HasherName(), unseeded.HasherName())
+	}
+	if seeded.hasher([]byte("key")) == unseeded.hasher([]byte("key")) {
+		t.Error("seeds should change the hash")
+	}
+	// 64-bit hashers place at full 64-bit positions, 32-bit ones at the high word
+	if got := unseeded.hasher([]byte("key")); got != xxhash64([]byte("key"), 0) {
+		t.Errorf("xxhash64 shouldn't be folded, got %#x", got)
+	}
+	crc, _ := NewCHashByName(CRC32)
+	if got := crc.hasher([]byte("key")); got != uint64(crc32.ChecksumIEEE([]byte("key")))<<32 {
+		t.Errorf("crc32 should be the high word, got %#x", got)
+	}
+	if seeded.Fingerprint() == unseeded.Fingerprint() || seeded.Clone().HasherName() != "xxhash64:42" {
+		t.Error("the hasher should be part of the fingerprint and of clones")
+	}
+	if NewCHash(nil).HasherName() != CRC32 || NewCHash(fnv1a).HasherName() != "" {
+		t.Error("only built-in hashers have a name")
+	}
+	for _, bad := range []string{"md5", "crc32:1", "xxhash64:x"} {
+		if _, err := HasherByName(bad); err == nil {
+			t.Errorf("%s should be refused", bad)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	calls := 0
+	counting := func(size int, i int) ([]byte, error) {
+		calls++
+		return getSalt(size, i)
+	}
+	// vNodes of every node collide whatever the salt
+	ch := NewCHash(func(b []byte) uint32 { return uint32(b[1]) },
+		WithVNodes(5), WithSaltLen(2), WithRetries(3), WithSaltFunc("counting", counting))
+	if err := ch.AddNode("x"); err != nil {
+		t.Fatal(err)
+	}
+	if ch.vNodes.Len() != 5 || len(ch.NameToSalt["x"]) != 2 {
+		t.Errorf("expecting 5 vNodes salted with 2 bytes, got %d, %v", ch.vNodes.Len(), ch.NameToSalt["x"])
+	}
+	if err := ch.Clone().AddNode("y"); err == nil || calls != 1+3 {
+		t.Errorf("adding y should give up after 3 salts, got %v after %d", err, calls)
+	}
+	want := Params{VNodes: 5, SaltLen: 2, SaltFunc: "counting"}
+	if ch.Params() != want || ch.Clone().Params() != want {
+		t.Errorf("expecting %v, got %v", want, ch.Params())
+	}
+
+	build := func(opts ...Option) *CHash {
+		ch := NewCHash(nil, opts...)
+		for i := 0; i < 5; i++ {
+			ch.AddNode(fmt.Sprint("node", i))
+		}
+		return ch
+	}
+	def := build()
+	if def.Params() != (Params{Hasher: CRC32, VNodes: 50, SaltLen: 1}) {
+		t.Errorf("unexpected defaults %v", def.Params())
+	}
+	for _, other := range []*CHash{build(WithVNodes(100)), build(WithSaltLen(2)), build(WithSaltFunc("same", getSalt))} {
+		if def.Params().Compatible(other.Params()) || def.Fingerprint() == other.Fingerprint() {
+			t.Errorf("%v and %v should be incompatible", def.Params(), other.Params())
+		}
+	}
+	if !def.Params().Compatible(build(WithRetries(100)).Params()) {
+		t.Error("retries don't change where nodes go")
+	}
+	if !def.Params().Compatible(NewCHash(crc32.ChecksumIEEE).Params()) {
+		t.Error("unknown hashers should be taken as compatible")
+	}
+
+	for _, p := range []Params{want, def.Params(), NewCHash(crc32.ChecksumIEEE).Params(), {Hasher: "xxhash64:42", VNodes: 7, SaltLen: 3}} {
+		if got, err := ParseParams(p.String()); err != nil || got != p {
+			t.Errorf("%v should parse back, got %v, %v", p, got, err)
+		}
+	}
+	for _, bad := range []string{"", "crc32", "crc32/vnodes=x/salt=1", "crc32/vnodes=50/salt=1/color=red", "crc32/vnodes=0/salt=1"} {
+		if _, err := ParseParams(bad); err == nil {
+			t.Errorf("%q should be refused", bad)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ch, _ := NewCHashByName("xxhash64:3", WithVNodes(20))
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprint("node", i)
+		ch.AddNodeWeighted(name, i+1)
+		ch.SetLabels(name, Labels{Zone: fmt.Sprint("zone", i%2)})
+	}
+	data, err := ch.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCHash(nil)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Fingerprint() != ch.Fingerprint() || restored.Params() != ch.Params() {
+		t.Fatalf("restored %v, expecting %v", restored.Params(), ch.Params())
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		if restored.FindNode(key) != ch.FindNode(key) {
+			t.Fatalf("%s should be owned by %s on both", key, ch.FindNode(key))
+		}
+	}
+	if restored.Weight("node4") != 5 || restored.Labels("node3").Zone != "zone1" {
+		t.Error("weights and labels should be restored")
+	}
+	// it's a ring of its own
+	if err := restored.AddNode("node5"); err != nil || ch.Len() != 5 {
+		t.Errorf("the restored ring should be usable, got %v", err)
+	}
+
+	// the hasher of a ring that isn't built-in can't be looked up
+	custom := NewCHash(fnv1a)
+	custom.AddNode("node0")
+	data, _ = custom.MarshalBinary()
+	before := restored.Fingerprint()
+	if err := restored.UnmarshalBinary(data); err == nil || restored.Fingerprint() != before {
+		t.Errorf("restoring a ring of another hasher should fail and change nothing, got %v", err)
+	}
+	if err := NewCHash(fnv1a).UnmarshalBinary(data); err != nil {
+		t.Errorf("a ring of the same hasher should restore it, got %v", err)
+	}
+	if err := NewCHash(nil, WithSaltFunc("other", getSalt)).UnmarshalBinary(data); err == nil {
+		t.Error("rings of different salt generators shouldn't restore")
+	}
+	if err := NewCHash(nil).UnmarshalBinary([]byte("garbage")); err == nil {
+		t.Error("garbage shouldn't restore")
+	}
+}
+
+func TestBadSnapshot(t *testing.T) {
+	node := func(name string, salt byte, weight uint32) *pb.Ring_Node {
+		return &pb.Ring_Node{Name: name, Salt: []byte{salt}, Weight: weight}
+	}
+	many := make([]*pb.Ring_Node, 0, maxSnapshotNodes+1)
+	for i := 0; i <= maxSnapshotNodes; i++ {
+		many = append(many, node(fmt.Sprint("node", i), 0, 1))
+	}
+	data := []struct {
+		name string
+		ring *pb.Ring
+	}{
+		{"too many vNodes", &pb.Ring{Vnodes: math.MaxUint32, SaltLen: 1, Nodes: []*pb.Ring_Node{node("a", 0, 1)}}},
+		{"too heavy", &pb.Ring{Vnodes: 1, SaltLen: 1, Nodes: []*pb.Ring_Node{node("a", 0, math.MaxUint32)}}},
+		{"too many nodes", &pb.Ring{Vnodes: 1, SaltLen: 1, Nodes: many}},
+		{"too big in total", &pb.Ring{Vnodes: maxSnapshotVNodes, SaltLen: 1,
+			Nodes: []*pb.Ring_Node{node("a", 0, maxSnapshotWeight), node("b", 0, 1)}}},
+		{"long salts", &pb.Ring{Vnodes: 1, SaltLen: math.MaxUint32, Nodes: []*pb.Ring_Node{node("a", 0, 1)}}},
+		{"wrong salt", &pb.Ring{Vnodes: 1, SaltLen: 2, Nodes: []*pb.Ring_Node{node("a", 0, 1)}}},
+		{"twice", &pb.Ring{Vnodes: 1, SaltLen: 1, Nodes: []*pb.Ring_Node{node("a", 0, 1), node("a", 1, 1)}}},
+		// vNodes of a collide with each other under salt 0
+		{"colliding", &pb.Ring{Vnodes: 2, SaltLen: 1, Nodes: []*pb.Ring_Node{node("a", 0, 1)}}},
+	}
+	for _, d := range data {
+		b, err := proto.Marshal(d.ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch := NewCHash64(func(b []byte) uint64 {
+			if b[len(b)-1] == 0 {
+				return 42
+			}
+			return xxhash64(b, 0)
+		})
+		ch.AddNode("kept")
+		if err := ch.UnmarshalBinary(b); err == nil || ch.Len() != 1 {
+			t.Errorf("%s: the snapshot should be refused, got %v", d.name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package consistentHash
+
+import (
+	"math"
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// Move is a range of positions, Start to End inclusive, whose owner changes
+// from From to To. From or To is "" if the ring it's on is empty.
+type Move struct {
+	Start, End uint64
+	From, To   string
+}
+
+// Share is the share of the ring the range holds
+func (m Move) Share() float64 {
+	return (float64(m.End-m.Start) + 1) / (1 << 64)
+}
+
+// Diff compares two rings and returns the ranges of the ring that
+// change owner, in order. Both rings should use the same hasher, and
+// bounded loads are ignored, the owners are those FindNode tells without.
+// Adjacent ranges of the same From and To are merged.
+func Diff(before, after *CHash) []Move {
+	// the owner of a hash only changes at a vNode of either ring
+	bounds := append(before.hashes(), after.hashes()...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	moves := make([]Move, 0)
+	add := func(start, end uint64) {
+		from, to := before.ownerOf(end), after.ownerOf(end)
+		if from == to {
+			return
+		}
+		if n := len(moves); n > 0 && moves[n-1].End+1 == start &&
+			moves[n-1].From == from && moves[n-1].To == to {
+			moves[n-1].End = end
+			return
+		}
+		moves = append(moves, Move{Start: start, End: end, From: from, To: to})
+	}
+
+	start := uint64(0)
+	for i, bound := range bounds {
+		if i > 0 && bound == bounds[i-1] {
+			continue
+		}
+		add(start, bound)
+		if bound == math.MaxUint64 {
+			return moves
+		}
+		start = bound + 1
+	}
+	// past the last vNode, hashes wrap around to the first
+	add(start, math.MaxUint64)
+	return moves
+}
+
+// MovedShare is the share of the ring that moves,
+// which is about the share of keys that move
+func MovedShare(moves []Move) float64 {
+	moved := 0.0
+	for _, m := range moves {
+		moved += m.Share()
+	}
+	return moved
+}
+
+// MovedPercent estimates the percentage of keys that change owner
+// from before to after by looking up a sample of keys on both
+func MovedPercent(before, after *CHash, keys []string) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	moved := 0
+	for _, key := range keys {
+		if before.ownerOf(before.hasher([]byte(key))) != after.ownerOf(after.hasher([]byte(key))) {
+			moved++
+		}
+	}
+	return 100 * float64(moved) / float64(len(keys))
+}
+
+// Distribution reports the share of the ring each node owns,
+// the shares add up to 1. A node owns the positions from the vNode before
+// each of its vNodes, exclusive, to the vNode, inclusive.
+func (ch *CHash) Distribution() map[string]float64 {
+	ret := make(map[string]float64, len(ch.NameToSalt))
+	if ch.vNodes.Len() == 0 {
+		return ret
+	}
+	if ch.vNodes.Len() == 1 {
+		ret[ch.vNodes.Min().(vNode).name] = 1
+		return ret
+	}
+	// the first vNode also owns the positions past the last one,
+	// the subtraction wraps around for it
+	prev := ch.vNodes.Max().(vNode).hash
+	ch.vNodes.Ascend(func(item btree.Item) bool {
+		vn := item.(vNode)
+		ret[vn.name] += float64(vn.hash-prev) / (1 << 64)
+		prev = vn.hash
+		return true
+	})
+	return ret
+}
+
+// hashes returns the positions of all vNodes
+func (ch *CHash) hashes() []uint64 {
+	ret := make([]uint64, 0, ch.vNodes.Len())
+	ch.vNodes.Ascend(func(item btree.Item) bool {
+		ret = append(ret, item.(vNode).hash)
+		return true
+	})
+	return ret
+}
+
+// ownerOf returns the owner of a position, "" if the ring is empty
+func (ch *CHash) ownerOf(hash uint64) string {
+	if ch.vNodes.Len() == 0 {
+		return ""
+	}
+	return ch.getNearestNode(hash)
+}
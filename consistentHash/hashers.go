@@ -0,0 +1,243 @@
+package consistentHash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Names of the built-in hashers, see HasherByName.
+// The 64-bit ones take a seed after a colon, eg. "xxhash64:42",
+// and place nodes at 64-bit positions on the ring. The others place
+// them at 32-bit ones, see Hasher.
+const (
+	CRC32    = "crc32"
+	FNV1a    = "fnv1a"
+	Murmur3  = "murmur3"
+	XXHash   = "xxhash"
+	XXHash64 = "xxhash64"
+	// FNV1a64Mix is FNV-1a of 64 bits, whose offset basis is XORed with
+	// the seed, finished with Mix64. Placements of package placement
+	// other than Ring hash keys with it.
+	FNV1a64Mix = "fnv1a64-mix64"
+)
+
+// HasherByName returns a built-in hasher, see the names above.
+// The hashers of 32 bits are widened to positions as NewCHash does.
+func HasherByName(name string) (Hasher64, error) {
+	hasher, _, err := lookupHasher(name)
+	return hasher, err
+}
+
+// lookupHasher also returns the canonical name of the hasher,
+// which spells the seed out, eg. "xxhash64:0" for "xxhash64"
+func lookupHasher(name string) (Hasher64, string, error) {
+	base, seedStr, seeded := strings.Cut(name, ":")
+	seed := uint64(0)
+	if seeded {
+		var err error
+		if seed, err = strconv.ParseUint(seedStr, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("bad seed of hasher %s: %w", name, err)
+		}
+	}
+
+	switch base {
+	case XXHash64:
+		return func(b []byte) uint64 { return xxhash64(b, seed) }, fmt.Sprintf("%s:%d", base, seed), nil
+	case FNV1a64Mix:
+		return func(b []byte) uint64 { return fnv1a64Mix(b, seed) }, fmt.Sprintf("%s:%d", base, seed), nil
+	}
+	if seeded {
+		return nil, "", fmt.Errorf("hasher %s doesn't take a seed", base)
+	}
+	switch base {
+	case CRC32:
+		return Hasher(crc32.ChecksumIEEE).widen(), base, nil
+	case FNV1a:
+		return Hasher(fnv1a).widen(), base, nil
+	case Murmur3:
+		return Hasher(func(b []byte) uint32 { return murmur3(b, 0) }).widen(), base, nil
+	case XXHash:
+		return Hasher(func(b []byte) uint32 { return xxhash32(b, 0) }).widen(), base, nil
+	}
+	return nil, "", fmt.Errorf("unknown hasher %s", name)
+}
+
+func fnv1a(b []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(b)
+	return h.Sum32()
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv1a64Mix goes through Mix64 as FNV-1a alone mixes the last bytes
+// too little into the high bits, so peerN would bunch up on the ring
+func fnv1a64Mix(b []byte, seed uint64) uint64 {
+	h := uint64(fnvOffset64) ^ seed
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return Mix64(h)
+}
+
+// Mix64 is the finalizer of MurmurHash3, it spreads every bit of h
+// over all bits of the result
+func Mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// murmur3 is MurmurHash3_x86_32
+func murmur3(b []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	n := len(b)
+	for ; len(b) >= 4; b = b[4:] {
+		k := binary.LittleEndian.Uint32(b)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	k := uint32(0)
+	for i := len(b) - 1; i >= 0; i-- {
+		k = k<<8 | uint32(b[i])
+	}
+	if len(b) > 0 {
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+const (
+	xxPrime32_1 = 2654435761
+	xxPrime32_2 = 2246822519
+	xxPrime32_3 = 3266489917
+	xxPrime32_4 = 668265263
+	xxPrime32_5 = 374761393
+)
+
+// xxhash32 is XXH32
+func xxhash32(b []byte, seed uint32) uint32 {
+	round := func(acc, lane uint32) uint32 {
+		return bits.RotateLeft32(acc+lane*xxPrime32_2, 13) * xxPrime32_1
+	}
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxPrime32_1 + xxPrime32_2
+		v2 := seed + xxPrime32_2
+		v3 := seed
+		v4 := seed - xxPrime32_1
+		for ; len(b) >= 16; b = b[16:] {
+			v1 = round(v1, binary.LittleEndian.Uint32(b))
+			v2 = round(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(b[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime32_5
+	}
+	h += uint32(n)
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxPrime32_3
+		h = bits.RotateLeft32(h, 17) * xxPrime32_4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxPrime32_5
+		h = bits.RotateLeft32(h, 11) * xxPrime32_1
+	}
+	h ^= h >> 15
+	h *= xxPrime32_2
+	h ^= h >> 13
+	h *= xxPrime32_3
+	h ^= h >> 16
+	return h
+}
+
+const (
+	xxPrime64_1 = 11400714785074694791
+	xxPrime64_2 = 14029467366897019727
+	xxPrime64_3 = 1609587929392839161
+	xxPrime64_4 = 9650029242287828579
+	xxPrime64_5 = 2870177450012600261
+)
+
+// xxhash64 is XXH64
+func xxhash64(b []byte, seed uint64) uint64 {
+	round := func(acc, lane uint64) uint64 {
+		return bits.RotateLeft64(acc+lane*xxPrime64_2, 31) * xxPrime64_1
+	}
+	merge := func(acc, v uint64) uint64 {
+		acc ^= round(0, v)
+		return acc*xxPrime64_1 + xxPrime64_4
+	}
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxPrime64_1 + xxPrime64_2
+		v2 := seed + xxPrime64_2
+		v3 := seed
+		v4 := seed - xxPrime64_1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = round(v1, binary.LittleEndian.Uint64(b))
+			v2 = round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = merge(h, v1)
+		h = merge(h, v2)
+		h = merge(h, v3)
+		h = merge(h, v4)
+	} else {
+		h = seed + xxPrime64_5
+	}
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime64_1 + xxPrime64_4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime64_1
+		h = bits.RotateLeft64(h, 23)*xxPrime64_2 + xxPrime64_3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime64_5
+		h = bits.RotateLeft64(h, 11) * xxPrime64_1
+	}
+	h ^= h >> 33
+	h *= xxPrime64_2
+	h ^= h >> 29
+	h *= xxPrime64_3
+	h ^= h >> 32
+	return h
+}
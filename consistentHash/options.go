@@ -0,0 +1,128 @@
+package consistentHash
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Option configures a CHash when it's created by NewCHash
+type Option func(*CHash)
+
+// SaltFunc returns the i-th salt of size bytes tried when adding a node.
+// It must be deterministic, so that rings of the same membership agree.
+type SaltFunc func(size int, i int) ([]byte, error)
+
+// WithVNodes maps a node of weight 1 to n vNodes, 50 by default.
+// More vNodes balance the ring better but make it bigger.
+func WithVNodes(n int) Option {
+	return func(ch *CHash) {
+		if n > 0 {
+			ch.vtFactor = n
+		}
+	}
+}
+
+// WithSaltLen sets the length of salts in bytes, 1 by default.
+// A salt of n bytes can be retried up to 2^(8n) times.
+func WithSaltLen(n int) Option {
+	return func(ch *CHash) {
+		if n > 0 {
+			ch.saltLen = n
+		}
+	}
+}
+
+// WithRetries sets how many salts AddNode tries before it gives up on
+// vNodes colliding with existing ones, 10 by default
+func WithRetries(n int) Option {
+	return func(ch *CHash) {
+		if n > 0 {
+			ch.retries = n
+		}
+	}
+}
+
+// WithSaltFunc replaces the salts tried in order, 0, 1, 2... in big endian
+// by default. The name tells rings of different generators apart, see
+// Params.
+func WithSaltFunc(name string, fn SaltFunc) Option {
+	return func(ch *CHash) {
+		if fn != nil {
+			ch.saltFunc, ch.saltFuncName = fn, name
+		}
+	}
+}
+
+// Params are what a ring is built with besides its nodes.
+// Rings of different Params put the same nodes on different vNodes,
+// so they can't agree on who owns a key.
+type Params struct {
+	Hasher   string // "" if the hasher isn't a built-in one
+	VNodes   int
+	SaltLen  int
+	SaltFunc string // "" for the default
+}
+
+// Params returns what the ring is built with.
+// The retry budget isn't part of it, rings only differ in whether a node
+// fits, not where it goes.
+func (ch *CHash) Params() Params {
+	return Params{
+		Hasher:   ch.hasherName,
+		VNodes:   ch.vtFactor,
+		SaltLen:  ch.saltLen,
+		SaltFunc: ch.saltFuncName,
+	}
+}
+
+// Compatible tells whether rings of p and other place nodes the same way.
+// Rings of unknown hashers are taken as compatible.
+func (p Params) Compatible(other Params) bool {
+	if p.Hasher != "" && other.Hasher != "" && p.Hasher != other.Hasher {
+		return false
+	}
+	return p.VNodes == other.VNodes && p.SaltLen == other.SaltLen && p.SaltFunc == other.SaltFunc
+}
+
+// String is eg. "crc32/vnodes=50/salt=1"
+func (p Params) String() string {
+	s := fmt.Sprintf("%s/vnodes=%d/salt=%d", p.Hasher, p.VNodes, p.SaltLen)
+	if p.SaltFunc != "" {
+		s += "/saltfunc=" + p.SaltFunc
+	}
+	return s
+}
+
+// ParseParams reads Params back from their String
+func ParseParams(s string) (Params, error) {
+	fields := strings.Split(s, "/")
+	if len(fields) < 3 {
+		return Params{}, fmt.Errorf("bad ring params %q", s)
+	}
+	p := Params{Hasher: fields[0]}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Params{}, fmt.Errorf("bad ring params %q: %q isn't key=value", s, field)
+		}
+		var err error
+		switch key {
+		case "vnodes":
+			p.VNodes, err = strconv.Atoi(value)
+		case "salt":
+			p.SaltLen, err = strconv.Atoi(value)
+		case "saltfunc":
+			p.SaltFunc = value
+		default:
+			err = fmt.Errorf("unknown key %s", key)
+		}
+		if err != nil {
+			return Params{}, fmt.Errorf("bad ring params %q: %w", s, err)
+		}
+	}
+	if p.VNodes <= 0 || p.SaltLen <= 0 {
+		return Params{}, fmt.Errorf("bad ring params %q: no vnodes or salt", s)
+	}
+	return p, nil
+}
@@ -0,0 +1,149 @@
+package consistentHash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"github.com/google/btree"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits of the rings UnmarshalBinary restores, which may come from peers
+const (
+	maxSnapshotNodes  = 1 << 12
+	maxSnapshotVNodes = 1 << 12 // per unit of weight
+	maxSnapshotWeight = 1 << 12
+	maxSnapshotSalt   = 8
+	// vNodes of all nodes
+	maxSnapshotTotal = 1 << 21
+)
+
+// MarshalBinary encodes the ring as a geecachepb.Ring: its Params and
+// every node with its salt, weight and labels. Bounded loads aren't
+// part of it.
+func (ch *CHash) MarshalBinary() ([]byte, error) {
+	names := make([]string, 0, len(ch.NameToSalt))
+	for name := range ch.NameToSalt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ringPb := &pb.Ring{
+		Hasher:   ch.hasherName,
+		Vnodes:   uint32(ch.vtFactor),
+		SaltLen:  uint32(ch.saltLen),
+		SaltFunc: ch.saltFuncName,
+		Nodes:    make([]*pb.Ring_Node, 0, len(names)),
+	}
+	for _, name := range names {
+		labels := ch.nameToLabels[name]
+		ringPb.Nodes = append(ringPb.Nodes, &pb.Ring_Node{
+			Name:   name,
+			Salt:   ch.NameToSalt[name],
+			Weight: uint32(ch.nameToWeight[name]),
+			Zone:   labels.Zone,
+			Rack:   labels.Rack,
+		})
+	}
+	return proto.Marshal(ringPb)
+}
+
+// UnmarshalBinary replaces the ring by one encoded by MarshalBinary.
+// Nodes get back the salts they had, so the ring is exactly the encoded
+// one and has the same Fingerprint, whatever order nodes were added in.
+//   - the hasher is looked up by name, a ring of a hasher that isn't
+//     built-in keeps the hasher of ch, which should be the same
+//   - ch must have the salt generator the encoded ring names
+//   - the retry budget and bounded loads of ch are kept
+//   - rings too big to be sane are refused, eg. of billions of vNodes
+//   - ch is left as it was if it fails
+func (ch *CHash) UnmarshalBinary(data []byte) error {
+	ringPb := &pb.Ring{}
+	if err := proto.Unmarshal(data, ringPb); err != nil {
+		return fmt.Errorf("can't unmarshal ring: %w", err)
+	}
+
+	hasher, hasherName := ch.hasher, ch.hasherName
+	switch {
+	case ringPb.Hasher != "":
+		var err error
+		if hasher, hasherName, err = lookupHasher(ringPb.Hasher); err != nil {
+			return fmt.Errorf("can't restore ring: %w", err)
+		}
+	case ch.hasherName != "":
+		return fmt.Errorf("can't restore ring: it isn't hashed by %s, but by a hasher of its own", ch.hasherName)
+	}
+	if ringPb.SaltFunc != ch.saltFuncName {
+		return fmt.Errorf("can't restore ring: its salt generator is %q, ours is %q", ringPb.SaltFunc, ch.saltFuncName)
+	}
+	if ringPb.Vnodes == 0 || ringPb.SaltLen == 0 {
+		return errors.New("can't restore ring: it has no vNodes or salts")
+	}
+	if err := checkSize(ringPb); err != nil {
+		return fmt.Errorf("can't restore ring: %w", err)
+	}
+
+	restored := &CHash{
+		hasher:       hasher,
+		hasherName:   hasherName,
+		NameToSalt:   make(map[string][]byte, len(ringPb.Nodes)),
+		nameToWeight: make(map[string]int, len(ringPb.Nodes)),
+		nameToLabels: make(map[string]Labels),
+		vNodes:       btree.New(2),
+		vtFactor:     int(ringPb.Vnodes),
+		saltLen:      int(ringPb.SaltLen),
+		retries:      ch.retries,
+		saltFunc:     ch.saltFunc,
+		saltFuncName: ch.saltFuncName,
+		loads:        ch.loads,
+		epsilon:      ch.epsilon,
+	}
+	for _, node := range ringPb.Nodes {
+		if err := restored.addSalted(node.Name, node.Salt, int(node.Weight)); err != nil {
+			return fmt.Errorf("can't restore ring: %w", err)
+		}
+		restored.SetLabels(node.Name, Labels{Zone: node.Zone, Rack: node.Rack})
+	}
+	*ch = *restored
+	return nil
+}
+
+// checkSize refuses rings over the limits above before any vNode is made
+func checkSize(ringPb *pb.Ring) error {
+	if len(ringPb.Nodes) > maxSnapshotNodes {
+		return fmt.Errorf("%d nodes, more than %d", len(ringPb.Nodes), maxSnapshotNodes)
+	}
+	if ringPb.Vnodes > maxSnapshotVNodes {
+		return fmt.Errorf("%d vNodes per weight, more than %d", ringPb.Vnodes, maxSnapshotVNodes)
+	}
+	if ringPb.SaltLen > maxSnapshotSalt {
+		return fmt.Errorf("salts of %d bytes, more than %d", ringPb.SaltLen, maxSnapshotSalt)
+	}
+	total := uint64(0)
+	for _, node := range ringPb.Nodes {
+		if node.Weight > maxSnapshotWeight {
+			return fmt.Errorf("weight of %s is %d, more than %d", node.Name, node.Weight, maxSnapshotWeight)
+		}
+		total += uint64(node.Weight) * uint64(ringPb.Vnodes)
+	}
+	if total > maxSnapshotTotal {
+		return fmt.Errorf("%d vNodes, more than %d", total, maxSnapshotTotal)
+	}
+	return nil
+}
+
+// addSalted adds a node with the salt it's known to have
+func (ch *CHash) addSalted(name string, salt []byte, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("weight of %s must be at least 1, got %d", name, weight)
+	}
+	if _, ok := ch.NameToSalt[name]; ok {
+		return fmt.Errorf("the node %s is there twice", name)
+	}
+	if len(salt) != ch.saltLen {
+		return fmt.Errorf("salt of %s is of %d bytes, not %d", name, len(salt), ch.saltLen)
+	}
+	return ch.insertNode(name, salt, weight, ch.groupHash(name, salt, weight))
+}
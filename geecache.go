@@ -1,11 +1,14 @@
 package geecache
 
 import (
+	"context"
 	"errors"
-	"log"
-	"math/rand"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/Hawk-Zhou/better-groupcache/logger"
 	"github.com/Hawk-Zhou/better-groupcache/singleflight"
 )
 
@@ -33,6 +36,24 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// ContextGetter is a Getter that respects deadlines and cancellation.
+// A Getter passed to NewGroup is used this way if it also implements ContextGetter.
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// ContextGetterFunc implements both Getter and ContextGetter
+// so it can be passed to NewGroup directly
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
 type Group struct {
 	name      string
 	mainCache cache // authoritative
@@ -40,16 +61,49 @@ type Group struct {
 	getter    Getter
 	peers     PeerPicker
 	sfGroup   *singleflight.Group // singleflight group
+	hotPolicy HotCachePolicy      // what goes into hotCache
+	stats     groupStats
+	logger    logger.Logger // has the group name attached
+	ttl       time.Duration // of both caches, 0 means never expire
+	// janitor is only started when janitorInterval > 0
+	janitorInterval time.Duration
+	janitor         *janitor
+	// replicas is how many owners a key is read from, see WithReplicas
+	replicas   int
+	hedgeDelay time.Duration
+	closeOnce  sync.Once
+	closed     AtomicInt // 1 once Close is called
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// errGroupClosed is wrapped by errors of gets on a closed group
+var errGroupClosed = errors.New("group is closed")
+
+// NewGroup creates a group in DefaultCache, see Cache.NewGroup
+func NewGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) (*Group, error) {
+	return DefaultCache.NewGroup(name, maxBytes, getter, opts...)
+}
+
+// MustNewGroup creates a group in DefaultCache, see Cache.MustNewGroup
+func MustNewGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
+	return DefaultCache.MustNewGroup(name, maxBytes, getter, opts...)
+}
+
+// ReplaceGroup replaces a group of DefaultCache, see Cache.ReplaceGroup
+func ReplaceGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
+	return DefaultCache.ReplaceGroup(name, maxBytes, getter, opts...)
+}
+
+// DeleteGroup deletes a group of DefaultCache, see Cache.DeleteGroup
+func DeleteGroup(name string) bool {
+	return DefaultCache.DeleteGroup(name)
+}
 
-func NewGroup(name string, maxBytes int, getter Getter) *Group {
-	mu.Lock()
-	defer mu.Unlock()
+// GetGroup looks a group of DefaultCache up, it doesn't create if not exist
+func GetGroup(name string) (retGroup *Group, ok bool) {
+	return DefaultCache.GetGroup(name)
+}
+
+func newGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
 	g := &Group{
 		name: name,
 		// there's no new function for cache
@@ -58,58 +112,93 @@ func NewGroup(name string, maxBytes int, getter Getter) *Group {
 		getter:    getter,
 		hotCache:  cache{maxBytes: maxBytes},
 		sfGroup:   &singleflight.Group{},
+		hotPolicy: RandomPromotion(10),
+		logger:    logger.Nop,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.logger = logger.With(g.logger, "group", name)
+	g.sfGroup.Logger = g.logger
+	if g.janitorInterval > 0 {
+		g.janitor = startJanitor(g.janitorInterval, &g.mainCache, &g.hotCache)
 	}
-	groups[name] = g
 	return g
 }
 
-// GetGroup doesn't create if not exist
-func GetGroup(name string) (retGroup *Group, ok bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	retGroup, ok = groups[name]
-	return retGroup, ok
+// Get is GetContext without a deadline
+func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
 }
 
-func (g *Group) Get(key string) (ByteView, error) {
+// GetContext looks up the key in cache and loads it on miss.
+// ctx is carried to the Getter or to the peer that owns the key.
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, errors.New("key is empty at group.Get()")
 	}
+	if err := g.checkOpen(); err != nil {
+		return ByteView{}, err
+	}
+	g.stats.gets.Add(1)
 
-	bv, ok := g.mainCache.get(key)
+	bv, ok := g.lookupCache(key)
 	if !ok {
-		log.Println("[Group.Get] cache miss")
-		ret, err := g.load(key)
+		g.logger.Debug("cache miss", "key", key)
+		ret, err := g.load(ctx, key)
 		if err != nil {
-			log.Println("[Group.Get] can't get key after miss:", err.Error())
+			g.logger.Warn("can't get key after miss", "key", key, "err", err)
 			return ret, err
 		}
 		return ret, err
 	}
 
-	log.Println("[Group.Get] cache hit")
+	// boxing the args allocates even if nothing is logged
+	if g.logger != logger.Nop {
+		g.logger.Debug("cache hit", "key", key)
+	}
 	return bv, nil
 }
 
+// lookupCache looks at mainCache first, then hotCache
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if bv, ok := g.mainCache.get(key); ok {
+		g.stats.mainCacheHits.Add(1)
+		return bv, ok
+	}
+	bv, ok := g.hotCache.get(key)
+	if ok {
+		g.stats.hotCacheHits.Add(1)
+	}
+	return bv, ok
+}
+
 // load is called when the key can't be found in local cache
 // It will ask its peers for that if not authoritative
 // Otherwise it will call getter
-func (g *Group) load(key string) (value ByteView, err error) {
-
-	sfRet, err := g.sfGroup.Do(key, func() (interface{}, error) {
-		if pGetter, ok := g.peers.PickPeer(key); ok {
-			// a peer is authoritative
-			log.Println("[Group.load] Getting from peers")
-			ret, err := g.getFromPeers(pGetter, key)
-			if err != nil {
-				log.Printf("[Group.load] Failed to get from peers: %v", err)
-				return ret, err
-			}
-			return ret, err
-		}
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
 
-		return g.getLocally(key)
+	ctx, _ = withLoadHints(ctx)
+
+	// set by the call that actually loads, the others are deduplicated
+	leader := false
+	sfRet, err := g.sfGroup.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		leader = true
+		if owners := g.replicaOwners(key); owners != nil {
+			return g.loadFromReplicas(ctx, key, owners)
+		}
+		pGetter, ok := g.peers.PickPeer(key)
+		if !ok {
+			pGetter = nil
+		}
+		return g.loadFrom(ctx, key, pGetter)
 	})
+	if !leader {
+		g.stats.loadsDeduped.Add(1)
+	}
 
 	ret := ByteView{}
 	if err == nil {
@@ -118,18 +207,146 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return ret, err
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	retBytes, err := g.getter.Get(key)
+// loadFrom loads the key from a peer, or with the Getter if pGetter is nil
+func (g *Group) loadFrom(ctx context.Context, key string, pGetter PeerGetter) (ByteView, error) {
+	if pGetter != nil {
+		// a peer is authoritative
+		g.logger.Debug("getting from peer", "key", key, "peer", peerName(pGetter))
+		ret, err := g.getFromPeers(ctx, pGetter, key)
+		if err != nil {
+			g.stats.peerErrors.Add(1)
+			g.logger.Warn("failed to get from peer", "key", key, "peer", peerName(pGetter), "err", err)
+			return ret, err
+		}
+		g.stats.peerLoads.Add(1)
+		return ret, err
+	}
+
+	ret, err := g.getLocally(ctx, key)
+	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		return ret, err
+	}
+	g.stats.localLoads.Add(1)
+	return ret, err
+}
+
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	var (
+		retBytes []byte
+		err      error
+	)
+	if cGetter, ok := g.getter.(ContextGetter); ok {
+		retBytes, err = cGetter.GetContext(ctx, key)
+	} else {
+		retBytes, err = g.getter.Get(key)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
 	ret := ByteView{b: retBytes}
+	if !cacheable(ctx) {
+		return ret, nil
+	}
 	err = g.populateCache(key, ret)
 	return ret, err
 }
 
 func (g *Group) populateCache(key string, value ByteView) error {
-	return g.mainCache.add(key, value)
+	return g.mainCache.addWithTTL(key, value, g.ttl)
+}
+
+// Remove deletes the key from the group across the cluster.
+// The owner of the key drops it from mainCache first,
+// then all other peers are told to drop it from hotCache,
+// or from both caches if they are replicas of the key.
+// Loads in flight while removing may still put the old value back.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("key is empty at group.Remove()")
+	}
+	if err := g.checkOpen(); err != nil {
+		return err
+	}
+
+	// replicas keep the key in mainCache like the owner does
+	replicas := g.replicaOwners(key)
+	isReplica := func(peer PeerGetter) bool {
+		for _, replica := range replicas {
+			if replica == peer {
+				return true
+			}
+		}
+		return false
+	}
+
+	owner, remote := g.peers.PickPeer(key)
+	if remote {
+		remover, ok := owner.(PeerRemover)
+		if !ok {
+			return fmt.Errorf("peer %s doesn't support remove", peerName(owner))
+		}
+		if err := remover.Remove(ctx, g.name, key, false); err != nil {
+			return fmt.Errorf("can't remove from owner %s: %w", peerName(owner), err)
+		}
+	}
+	g.removeLocally(key, remote && !isReplica(nil))
+
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	failed := make([]string, 0)
+	for _, peer := range lister.AllPeers() {
+		if remote && peer == owner {
+			continue
+		}
+		remover, ok := peer.(PeerRemover)
+		if !ok {
+			continue
+		}
+		if err := remover.Remove(ctx, g.name, key, !isReplica(peer)); err != nil {
+			g.logger.Warn("can't invalidate hot cache", "key", key, "peer", peerName(peer), "err", err)
+			failed = append(failed, peerName(peer)+":"+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("removed from owner but failed to invalidate %d peers: %s",
+			len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// removeLocally drops the key from hotCache, and from mainCache unless hotOnly
+func (g *Group) removeLocally(key string, hotOnly bool) {
+	g.hotCache.remove(key)
+	if !hotOnly {
+		g.mainCache.remove(key)
+	}
+}
+
+// Close frees the caches of g and stops its janitor.
+// Gets and removes on g fail after it, loads in flight finish but their
+// values aren't cached. Close doesn't unregister g, see DeleteGroup.
+// It can be called more than once.
+func (g *Group) Close() {
+	g.closeOnce.Do(func() {
+		g.closed.Add(1)
+		if g.janitor != nil {
+			g.janitor.Stop()
+		}
+		g.mainCache.close()
+		g.hotCache.close()
+		g.logger.Info("group closed")
+	})
+}
+
+// checkOpen errs if g is closed
+func (g *Group) checkOpen() error {
+	if g.closed.Get() != 0 {
+		return fmt.Errorf("%s: %w", g.name, errGroupClosed)
+	}
+	return nil
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
@@ -144,6 +361,8 @@ func (g *Group) AddPeers(peers ...string) {
 	switch x := g.peers.(type) {
 	case (*HTTPPool):
 		x.AddPeers(peers...)
+	case (*GRPCPool):
+		x.AddPeers(peers...)
 	default:
 		panic("unknown type encountered when adding peers")
 	}
@@ -151,10 +370,18 @@ func (g *Group) AddPeers(peers ...string) {
 
 // getFromPeers should be called if known caller is not authoritative
 // shouldn't validate whether authoritative here
-func (g *Group) getFromPeers(pGetter PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeers(ctx context.Context, pGetter PeerGetter, key string) (ByteView, error) {
 	// if not ok means g itself is authoritative
 
-	b, err := pGetter.Get(g.name, key)
+	var (
+		b   []byte
+		err error
+	)
+	if cGetter, ok := pGetter.(ContextPeerGetter); ok {
+		b, err = cGetter.GetContext(ctx, g.name, key)
+	} else {
+		b, err = pGetter.Get(g.name, key)
+	}
 
 	if err != nil {
 		return ByteView{}, err
@@ -162,9 +389,49 @@ func (g *Group) getFromPeers(pGetter PeerGetter, key string) (ByteView, error) {
 
 	ret := ByteView{b: b}
 
-	if rand.Intn(10) == 0 {
-		g.hotCache.add(key, ret)
+	if cacheable(ctx) && g.hotPolicy.ShouldPromote(key, ret) {
+		g.hotCache.addWithTTL(key, ret, g.ttl)
 	}
 
 	return ret, nil
 }
+
+type ctxKey int
+
+const hintsKey ctxKey = 0
+
+// loadHints travel with the context of a load.
+// Whoever learns that the value shouldn't be cached sets noCache,
+// eg. an HTTPGetter whose peer disagrees on the ring.
+type loadHints struct {
+	noCache AtomicInt
+}
+
+// withLoadHints attaches new hints to ctx for a load.
+// They start as noCache if the hints of ctx, if any, are.
+func withLoadHints(ctx context.Context) (context.Context, *loadHints) {
+	hints := &loadHints{}
+	if parent, ok := ctx.Value(hintsKey).(*loadHints); ok {
+		hints.noCache.Add(parent.noCache.Get())
+	}
+	return context.WithValue(ctx, hintsKey, hints), hints
+}
+
+// withNoCache makes loads under ctx not cache what they load
+func withNoCache(ctx context.Context) context.Context {
+	ctx, hints := withLoadHints(ctx)
+	hints.noCache.Add(1)
+	return ctx
+}
+
+// markNoCache tells the load of ctx not to cache what it loads
+func markNoCache(ctx context.Context) {
+	if hints, ok := ctx.Value(hintsKey).(*loadHints); ok {
+		hints.noCache.Add(1)
+	}
+}
+
+func cacheable(ctx context.Context) bool {
+	hints, ok := ctx.Value(hintsKey).(*loadHints)
+	return !ok || hints.noCache.Get() == 0
+}
@@ -1,6 +1,8 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
@@ -22,6 +24,18 @@ func TestGetterCallback(t *testing.T) {
 	}
 }
 
+// newTestGroup creates a group in DefaultCache and deletes it when t ends,
+// so that tests can be run again
+func newTestGroup(t *testing.T, name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
+	t.Helper()
+	g, err := NewGroup(name, maxBytes, getter, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DeleteGroup(name) })
+	return g
+}
+
 func TestGroupWR(t *testing.T) {
 	dbStub := map[string][]byte{
 		"hello": []byte("world"),
@@ -40,7 +54,7 @@ func TestGroupWR(t *testing.T) {
 
 	getter := getGenerator()
 
-	g := NewGroup("G1", len("hello"+"world"), getter)
+	g := newTestGroup(t, "G1", len("hello"+"world"), getter)
 	g.RegisterPeers(NewHTTPPool(19623))
 
 	testdata := []struct {
@@ -73,7 +87,7 @@ func TestGroupWR(t *testing.T) {
 }
 
 func TestGetFromRemotePool(t *testing.T) {
-	localGroup := NewGroup("getRemoteGroup", 10, nil)
+	localGroup := newTestGroup(t, "getRemoteGroup", 10, nil)
 	localPool := NewHTTPPool(4970)
 	localGroup.RegisterPeers(localPool)
 
@@ -124,7 +138,7 @@ func TestIntegrationSingleFlight(t *testing.T) {
 
 	getter := getGenerator()
 
-	g := NewGroup("G1", len("hello"+"world"), getter)
+	g := newTestGroup(t, "G1", len("hello"+"world"), getter)
 	g.RegisterPeers(NewHTTPPool(19623))
 
 	testdata := []struct {
@@ -146,7 +160,7 @@ func TestIntegrationSingleFlight(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			log.Printf("test %v start", d.name)
-			ret, err := g.load(d.key)
+			ret, err := g.load(context.Background(), d.key)
 			if err != nil {
 				t.Error("unexpected error")
 			}
@@ -159,3 +173,163 @@ func TestIntegrationSingleFlight(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestGroupGetContext(t *testing.T) {
+	cancelled := make(chan struct{})
+	g := newTestGroup(t, "ctxGroup", 10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}))
+	g.RegisterPeers(NewHTTPPool(19624))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := g.GetContext(ctx, "slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("getter didn't see the cancellation")
+	}
+
+	// a cancelled ctx shouldn't reach the getter at all
+	_, err = g.GetContext(ctx, "slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+}
+
+// fakePeer answers every query with the key itself and counts calls
+type fakePeer struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (fp *fakePeer) Get(group string, key string) ([]byte, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.count++
+	return []byte(key), nil
+}
+
+func (fp *fakePeer) PickPeer(key string) (PeerGetter, bool) {
+	return fp, true
+}
+
+func TestHotCache(t *testing.T) {
+	data := []struct {
+		name      string
+		opts      []GroupOption
+		wantCount int
+	}{
+		{"always promote", []GroupOption{WithHotCachePolicy(AlwaysPromote)}, 1},
+		{"never promote", []GroupOption{WithHotCachePolicy(NeverPromote)}, 3},
+		{"hot cache disabled", []GroupOption{WithHotCachePolicy(AlwaysPromote), WithHotCacheBytes(0)}, 3},
+	}
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			peer := &fakePeer{}
+			g := newTestGroup(t, "hotGroup", 100, nil, d.opts...)
+			g.peers = peer
+			for i := 0; i < 3; i++ {
+				ret, err := g.Get("hot")
+				if err != nil || ret.String() != "hot" {
+					t.Fatalf("unexpected ret/err %v/%v", ret, err)
+				}
+			}
+			if peer.count != d.wantCount {
+				t.Errorf("expecting %d peer calls, got %d", d.wantCount, peer.count)
+			}
+			if _, ok := g.mainCache.get("hot"); ok {
+				t.Error("values from peers shouldn't go into mainCache")
+			}
+		})
+	}
+}
+
+// recordLogger keeps the first args of every line
+type recordLogger struct {
+	mu    sync.Mutex
+	lines [][]any
+}
+
+func (rl *recordLogger) add(msg string, args []any) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.lines = append(rl.lines, append([]any{msg}, args...))
+}
+
+func (rl *recordLogger) Debug(msg string, args ...any) { rl.add(msg, args) }
+func (rl *recordLogger) Info(msg string, args ...any)  { rl.add(msg, args) }
+func (rl *recordLogger) Warn(msg string, args ...any)  { rl.add(msg, args) }
+func (rl *recordLogger) Error(msg string, args ...any) { rl.add(msg, args) }
+
+func TestGroupLogger(t *testing.T) {
+	rl := &recordLogger{}
+	g := newTestGroup(t, "loggedGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithLogger(rl))
+	g.RegisterPeers(NewHTTPPool(19628))
+	g.Get("k")
+
+	if len(rl.lines) == 0 {
+		t.Fatal("nothing logged")
+	}
+	for _, line := range rl.lines {
+		if len(line) < 3 || line[1] != "group" || line[2] != "loggedGroup" {
+			t.Errorf("group isn't attached: %v", line)
+		}
+	}
+}
+
+func TestHitWithoutLoggerDoesNotAllocate(t *testing.T) {
+	g := NewCache().MustNewGroup("quietGroup", 100, nil)
+	g.populateCache("k", ByteView{b: []byte("v")})
+	if n := testing.AllocsPerRun(100, func() { g.Get("k") }); n != 0 {
+		t.Errorf("a cache hit shouldn't allocate with the default logger, got %v allocs", n)
+	}
+}
+
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	g := newTestGroup(t, "ttlGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithTTL(50*time.Millisecond))
+	g.RegisterPeers(NewHTTPPool(19629))
+
+	g.Get("k")
+	g.Get("k")
+	if loads != 1 {
+		t.Errorf("expecting 1 load before expiry, got %d", loads)
+	}
+	time.Sleep(80 * time.Millisecond)
+	g.Get("k")
+	if loads != 2 {
+		t.Errorf("expecting a reload after expiry, got %d loads", loads)
+	}
+}
+
+func TestGroupJanitor(t *testing.T) {
+	g := newTestGroup(t, "janitorGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithTTL(20*time.Millisecond), WithJanitor(time.Millisecond))
+	defer g.Close()
+	g.RegisterPeers(NewHTTPPool(19630))
+
+	g.Get("k")
+	if s := g.CacheStats(MainCache); s.Items != 1 {
+		t.Fatalf("expecting 1 item, got %+v", s)
+	}
+	deadline := time.Now().Add(time.Second)
+	for s := g.CacheStats(MainCache); s.Items != 0 || s.Bytes != 0 || s.Expired != 1; s = g.CacheStats(MainCache) {
+		if time.Now().After(deadline) {
+			t.Fatalf("janitor didn't reclaim the expired entry: %+v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
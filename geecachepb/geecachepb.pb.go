@@ -25,6 +25,10 @@ type Request_RequestType int32
 const (
 	Request_ISQUERY  Request_RequestType = 0
 	Request_ISMANAGE Request_RequestType = 1
+	Request_ISREMOVE Request_RequestType = 2
+	Request_ISBATCH  Request_RequestType = 3
+	// ISPING has no body, peers answer it to tell they are alive
+	Request_ISPING Request_RequestType = 4
 )
 
 // Enum value maps for Request_RequestType.
@@ -32,10 +36,16 @@ var (
 	Request_RequestType_name = map[int32]string{
 		0: "ISQUERY",
 		1: "ISMANAGE",
+		2: "ISREMOVE",
+		3: "ISBATCH",
+		4: "ISPING",
 	}
 	Request_RequestType_value = map[string]int32{
 		"ISQUERY":  0,
 		"ISMANAGE": 1,
+		"ISREMOVE": 2,
+		"ISBATCH":  3,
+		"ISPING":   4,
 	}
 )
 
@@ -71,6 +81,10 @@ type Request_Manage_OpType int32
 const (
 	Request_Manage_PURGE Request_Manage_OpType = 0
 	Request_Manage_ADD   Request_Manage_OpType = 1
+	// SET replaces all peers at once
+	Request_Manage_SET Request_Manage_OpType = 2
+	// RING asks for the ring of the peer, answered by a Ring
+	Request_Manage_RING Request_Manage_OpType = 3
 )
 
 // Enum value maps for Request_Manage_OpType.
@@ -78,10 +92,14 @@ var (
 	Request_Manage_OpType_name = map[int32]string{
 		0: "PURGE",
 		1: "ADD",
+		2: "SET",
+		3: "RING",
 	}
 	Request_Manage_OpType_value = map[string]int32{
 		"PURGE": 0,
 		"ADD":   1,
+		"SET":   2,
+		"RING":  3,
 	}
 )
 
@@ -112,6 +130,116 @@ func (Request_Manage_OpType) EnumDescriptor() ([]byte, []int) {
 	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{0, 1, 0}
 }
 
+type Gossip_Type int32
+
+const (
+	Gossip_PING Gossip_Type = 0
+	Gossip_ACK  Gossip_Type = 1
+	// PING_REQ asks the receiver to ping target on behalf of the sender
+	Gossip_PING_REQ Gossip_Type = 2
+	// SYNC asks for the whole membership, answered by an ACK carrying it
+	Gossip_SYNC Gossip_Type = 3
+	// UPDATE only carries updates and isn't answered
+	Gossip_UPDATE Gossip_Type = 4
+)
+
+// Enum value maps for Gossip_Type.
+var (
+	Gossip_Type_name = map[int32]string{
+		0: "PING",
+		1: "ACK",
+		2: "PING_REQ",
+		3: "SYNC",
+		4: "UPDATE",
+	}
+	Gossip_Type_value = map[string]int32{
+		"PING":     0,
+		"ACK":      1,
+		"PING_REQ": 2,
+		"SYNC":     3,
+		"UPDATE":   4,
+	}
+)
+
+func (x Gossip_Type) Enum() *Gossip_Type {
+	p := new(Gossip_Type)
+	*p = x
+	return p
+}
+
+func (x Gossip_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Gossip_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_geecachepb_geecachepb_proto_enumTypes[2].Descriptor()
+}
+
+func (Gossip_Type) Type() protoreflect.EnumType {
+	return &file_geecachepb_geecachepb_proto_enumTypes[2]
+}
+
+func (x Gossip_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Gossip_Type.Descriptor instead.
+func (Gossip_Type) EnumDescriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{4, 0}
+}
+
+type Gossip_Member_State int32
+
+const (
+	Gossip_Member_ALIVE   Gossip_Member_State = 0
+	Gossip_Member_SUSPECT Gossip_Member_State = 1
+	Gossip_Member_DEAD    Gossip_Member_State = 2
+	Gossip_Member_LEFT    Gossip_Member_State = 3
+)
+
+// Enum value maps for Gossip_Member_State.
+var (
+	Gossip_Member_State_name = map[int32]string{
+		0: "ALIVE",
+		1: "SUSPECT",
+		2: "DEAD",
+		3: "LEFT",
+	}
+	Gossip_Member_State_value = map[string]int32{
+		"ALIVE":   0,
+		"SUSPECT": 1,
+		"DEAD":    2,
+		"LEFT":    3,
+	}
+)
+
+func (x Gossip_Member_State) Enum() *Gossip_Member_State {
+	p := new(Gossip_Member_State)
+	*p = x
+	return p
+}
+
+func (x Gossip_Member_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Gossip_Member_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_geecachepb_geecachepb_proto_enumTypes[3].Descriptor()
+}
+
+func (Gossip_Member_State) Type() protoreflect.EnumType {
+	return &file_geecachepb_geecachepb_proto_enumTypes[3]
+}
+
+func (x Gossip_Member_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Gossip_Member_State.Descriptor instead.
+func (Gossip_Member_State) EnumDescriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{4, 0, 0}
+}
+
 type Request struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -121,7 +249,18 @@ type Request struct {
 	// Types that are assignable to Body:
 	//	*Request_Query_
 	//	*Request_Manage_
+	//	*Request_Remove_
+	//	*Request_Batch
 	Body isRequest_Body `protobuf_oneof:"body"`
+	// ring_hash is the fingerprint of the sender's ring, 0 if unknown.
+	// Peers whose rings differ may disagree on who owns a key.
+	RingHash uint64 `protobuf:"varint,6,opt,name=ring_hash,json=ringHash,proto3" json:"ring_hash,omitempty"`
+	// hasher names the hash function of the sender's ring, "" if unknown.
+	// Peers whose hashers differ refuse to talk.
+	Hasher string `protobuf:"bytes,7,opt,name=hasher,proto3" json:"hasher,omitempty"`
+	// ring_params tells how the sender's ring is built besides its nodes,
+	// "" if unknown. Peers whose rings are built differently refuse to talk.
+	RingParams string `protobuf:"bytes,8,opt,name=ring_params,json=ringParams,proto3" json:"ring_params,omitempty"`
 }
 
 func (x *Request) Reset() {
@@ -184,6 +323,41 @@ func (x *Request) GetManage() *Request_Manage {
 	return nil
 }
 
+func (x *Request) GetRemove() *Request_Remove {
+	if x, ok := x.GetBody().(*Request_Remove_); ok {
+		return x.Remove
+	}
+	return nil
+}
+
+func (x *Request) GetBatch() *Request_BatchQuery {
+	if x, ok := x.GetBody().(*Request_Batch); ok {
+		return x.Batch
+	}
+	return nil
+}
+
+func (x *Request) GetRingHash() uint64 {
+	if x != nil {
+		return x.RingHash
+	}
+	return 0
+}
+
+func (x *Request) GetHasher() string {
+	if x != nil {
+		return x.Hasher
+	}
+	return ""
+}
+
+func (x *Request) GetRingParams() string {
+	if x != nil {
+		return x.RingParams
+	}
+	return ""
+}
+
 type isRequest_Body interface {
 	isRequest_Body()
 }
@@ -196,10 +370,22 @@ type Request_Manage_ struct {
 	Manage *Request_Manage `protobuf:"bytes,3,opt,name=manage,proto3,oneof"`
 }
 
+type Request_Remove_ struct {
+	Remove *Request_Remove `protobuf:"bytes,4,opt,name=remove,proto3,oneof"`
+}
+
+type Request_Batch struct {
+	Batch *Request_BatchQuery `protobuf:"bytes,5,opt,name=batch,proto3,oneof"`
+}
+
 func (*Request_Query_) isRequest_Body() {}
 
 func (*Request_Manage_) isRequest_Body() {}
 
+func (*Request_Remove_) isRequest_Body() {}
+
+func (*Request_Batch) isRequest_Body() {}
+
 type Response struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -247,6 +433,221 @@ func (x *Response) GetValue() []byte {
 	return nil
 }
 
+// Ring is a snapshot of a consistent hash ring, enough to rebuild it
+// exactly without probing salts again
+type Ring struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// hasher is the built-in hasher of the ring, "" if it isn't one
+	Hasher  string `protobuf:"bytes,1,opt,name=hasher,proto3" json:"hasher,omitempty"`
+	Vnodes  uint32 `protobuf:"varint,2,opt,name=vnodes,proto3" json:"vnodes,omitempty"`
+	SaltLen uint32 `protobuf:"varint,3,opt,name=salt_len,json=saltLen,proto3" json:"salt_len,omitempty"`
+	// salt_func names the salt generator, "" for the default
+	SaltFunc string `protobuf:"bytes,4,opt,name=salt_func,json=saltFunc,proto3" json:"salt_func,omitempty"`
+	// nodes are sorted by name
+	Nodes []*Ring_Node `protobuf:"bytes,5,rep,name=nodes,proto3" json:"nodes,omitempty"`
+}
+
+func (x *Ring) Reset() {
+	*x = Ring{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ring) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ring) ProtoMessage() {}
+
+func (x *Ring) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ring.ProtoReflect.Descriptor instead.
+func (*Ring) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Ring) GetHasher() string {
+	if x != nil {
+		return x.Hasher
+	}
+	return ""
+}
+
+func (x *Ring) GetVnodes() uint32 {
+	if x != nil {
+		return x.Vnodes
+	}
+	return 0
+}
+
+func (x *Ring) GetSaltLen() uint32 {
+	if x != nil {
+		return x.SaltLen
+	}
+	return 0
+}
+
+func (x *Ring) GetSaltFunc() string {
+	if x != nil {
+		return x.SaltFunc
+	}
+	return ""
+}
+
+func (x *Ring) GetNodes() []*Ring_Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+// BatchResponse answers a BatchQuery, one entry per key.
+// An entry carries either a value or an error.
+type BatchResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Entries []*BatchResponse_Entry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+}
+
+func (x *BatchResponse) Reset() {
+	*x = BatchResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResponse) ProtoMessage() {}
+
+func (x *BatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResponse.ProtoReflect.Descriptor instead.
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchResponse) GetEntries() []*BatchResponse_Entry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+// Gossip is a message of the membership protocol in package gossip.
+// Every message also carries recent membership updates.
+type Gossip struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type Gossip_Type `protobuf:"varint,1,opt,name=type,proto3,enum=geecachepb.Gossip_Type" json:"type,omitempty"`
+	Seq  uint64      `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	// from is the gossip address of the sender
+	From    string           `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`
+	Target  string           `protobuf:"bytes,4,opt,name=target,proto3" json:"target,omitempty"`
+	Members []*Gossip_Member `protobuf:"bytes,5,rep,name=members,proto3" json:"members,omitempty"`
+}
+
+func (x *Gossip) Reset() {
+	*x = Gossip{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Gossip) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Gossip) ProtoMessage() {}
+
+func (x *Gossip) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Gossip.ProtoReflect.Descriptor instead.
+func (*Gossip) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Gossip) GetType() Gossip_Type {
+	if x != nil {
+		return x.Type
+	}
+	return Gossip_PING
+}
+
+func (x *Gossip) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Gossip) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Gossip) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Gossip) GetMembers() []*Gossip_Member {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
 type Request_Query struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -259,7 +660,7 @@ type Request_Query struct {
 func (x *Request_Query) Reset() {
 	*x = Request_Query{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_geecachepb_geecachepb_proto_msgTypes[2]
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -272,7 +673,7 @@ func (x *Request_Query) String() string {
 func (*Request_Query) ProtoMessage() {}
 
 func (x *Request_Query) ProtoReflect() protoreflect.Message {
-	mi := &file_geecachepb_geecachepb_proto_msgTypes[2]
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -309,12 +710,15 @@ type Request_Manage struct {
 
 	Op   Request_Manage_OpType `protobuf:"varint,1,opt,name=op,proto3,enum=geecachepb.Request_Manage_OpType" json:"op,omitempty"`
 	Node []string              `protobuf:"bytes,2,rep,name=node,proto3" json:"node,omitempty"`
+	// weight[i] is the weight of node[i] on the ring.
+	// Nodes are of weight 1 if it's empty.
+	Weight []uint32 `protobuf:"varint,3,rep,packed,name=weight,proto3" json:"weight,omitempty"`
 }
 
 func (x *Request_Manage) Reset() {
 	*x = Request_Manage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_geecachepb_geecachepb_proto_msgTypes[3]
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -327,7 +731,7 @@ func (x *Request_Manage) String() string {
 func (*Request_Manage) ProtoMessage() {}
 
 func (x *Request_Manage) ProtoReflect() protoreflect.Message {
-	mi := &file_geecachepb_geecachepb_proto_msgTypes[3]
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -357,79 +761,528 @@ func (x *Request_Manage) GetNode() []string {
 	return nil
 }
 
-var File_geecachepb_geecachepb_proto protoreflect.FileDescriptor
-
-var file_geecachepb_geecachepb_proto_rawDesc = []byte{
-	0x0a, 0x1b, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2f, 0x67, 0x65, 0x65,
-	0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x67,
-	0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x22, 0xf9, 0x02, 0x0a, 0x07, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x33, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62,
-	0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x31, 0x0a, 0x05, 0x71, 0x75,
-	0x65, 0x72, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x65, 0x65, 0x63,
-	0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x51,
-	0x75, 0x65, 0x72, 0x79, 0x48, 0x00, 0x52, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x12, 0x34, 0x0a,
-	0x06, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
-	0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x2e, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x48, 0x00, 0x52, 0x06, 0x6d, 0x61, 0x6e,
-	0x61, 0x67, 0x65, 0x1a, 0x2f, 0x0a, 0x05, 0x51, 0x75, 0x65, 0x72, 0x79, 0x12, 0x14, 0x0a, 0x05,
-	0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f,
-	0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x03, 0x6b, 0x65, 0x79, 0x1a, 0x6d, 0x0a, 0x06, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x12, 0x31,
-	0x0a, 0x02, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x21, 0x2e, 0x67, 0x65, 0x65,
-	0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e,
-	0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x2e, 0x4f, 0x70, 0x54, 0x79, 0x70, 0x65, 0x52, 0x02, 0x6f,
-	0x70, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
-	0x04, 0x6e, 0x6f, 0x64, 0x65, 0x22, 0x1c, 0x0a, 0x06, 0x4f, 0x70, 0x54, 0x79, 0x70, 0x65, 0x12,
-	0x09, 0x0a, 0x05, 0x50, 0x55, 0x52, 0x47, 0x45, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x44,
-	0x44, 0x10, 0x01, 0x22, 0x28, 0x0a, 0x0b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79,
-	0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x53, 0x51, 0x55, 0x45, 0x52, 0x59, 0x10, 0x00, 0x12,
-	0x0c, 0x0a, 0x08, 0x49, 0x53, 0x4d, 0x41, 0x4e, 0x41, 0x47, 0x45, 0x10, 0x01, 0x42, 0x06, 0x0a,
-	0x04, 0x62, 0x6f, 0x64, 0x79, 0x22, 0x20, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
-	0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x32, 0x3e, 0x0a, 0x0a, 0x47, 0x72, 0x6f, 0x75, 0x70,
-	0x43, 0x61, 0x63, 0x68, 0x65, 0x12, 0x30, 0x0a, 0x03, 0x47, 0x65, 0x74, 0x12, 0x13, 0x2e, 0x67,
-	0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x14, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x0e, 0x5a, 0x0c, 0x2f, 0x67, 0x65, 0x65, 0x63,
-	0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+func (x *Request_Manage) GetWeight() []uint32 {
+	if x != nil {
+		return x.Weight
+	}
+	return nil
 }
 
-var (
-	file_geecachepb_geecachepb_proto_rawDescOnce sync.Once
-	file_geecachepb_geecachepb_proto_rawDescData = file_geecachepb_geecachepb_proto_rawDesc
-)
+// Remove deletes a key from the caches of a peer.
+// The owner of the key is asked to drop it from both caches,
+// while other peers are only asked to drop it from hotCache.
+type Request_Remove struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func file_geecachepb_geecachepb_proto_rawDescGZIP() []byte {
-	file_geecachepb_geecachepb_proto_rawDescOnce.Do(func() {
-		file_geecachepb_geecachepb_proto_rawDescData = protoimpl.X.CompressGZIP(file_geecachepb_geecachepb_proto_rawDescData)
-	})
-	return file_geecachepb_geecachepb_proto_rawDescData
+	Group   string `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key     string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	HotOnly bool   `protobuf:"varint,3,opt,name=hot_only,json=hotOnly,proto3" json:"hot_only,omitempty"`
 }
 
-var file_geecachepb_geecachepb_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_geecachepb_geecachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
-var file_geecachepb_geecachepb_proto_goTypes = []interface{}{
-	(Request_RequestType)(0),   // 0: geecachepb.Request.RequestType
-	(Request_Manage_OpType)(0), // 1: geecachepb.Request.Manage.OpType
-	(*Request)(nil),            // 2: geecachepb.Request
-	(*Response)(nil),           // 3: geecachepb.Response
-	(*Request_Query)(nil),      // 4: geecachepb.Request.Query
-	(*Request_Manage)(nil),     // 5: geecachepb.Request.Manage
-}
-var file_geecachepb_geecachepb_proto_depIdxs = []int32{
-	0, // 0: geecachepb.Request.type:type_name -> geecachepb.Request.RequestType
-	4, // 1: geecachepb.Request.query:type_name -> geecachepb.Request.Query
-	5, // 2: geecachepb.Request.manage:type_name -> geecachepb.Request.Manage
-	1, // 3: geecachepb.Request.Manage.op:type_name -> geecachepb.Request.Manage.OpType
-	2, // 4: geecachepb.GroupCache.Get:input_type -> geecachepb.Request
-	3, // 5: geecachepb.GroupCache.Get:output_type -> geecachepb.Response
-	5, // [5:6] is the sub-list for method output_type
-	4, // [4:5] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+func (x *Request_Remove) Reset() {
+	*x = Request_Remove{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Request_Remove) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Request_Remove) ProtoMessage() {}
+
+func (x *Request_Remove) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Request_Remove.ProtoReflect.Descriptor instead.
+func (*Request_Remove) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{0, 2}
+}
+
+func (x *Request_Remove) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Request_Remove) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Request_Remove) GetHotOnly() bool {
+	if x != nil {
+		return x.HotOnly
+	}
+	return false
+}
+
+// BatchQuery asks for many keys of a group in one round trip
+type Request_BatchQuery struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Group string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys  []string `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+}
+
+func (x *Request_BatchQuery) Reset() {
+	*x = Request_BatchQuery{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Request_BatchQuery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Request_BatchQuery) ProtoMessage() {}
+
+func (x *Request_BatchQuery) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Request_BatchQuery.ProtoReflect.Descriptor instead.
+func (*Request_BatchQuery) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{0, 3}
+}
+
+func (x *Request_BatchQuery) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Request_BatchQuery) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type Ring_Node struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name   string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Salt   []byte `protobuf:"bytes,2,opt,name=salt,proto3" json:"salt,omitempty"`
+	Weight uint32 `protobuf:"varint,3,opt,name=weight,proto3" json:"weight,omitempty"`
+	Zone   string `protobuf:"bytes,4,opt,name=zone,proto3" json:"zone,omitempty"`
+	Rack   string `protobuf:"bytes,5,opt,name=rack,proto3" json:"rack,omitempty"`
+}
+
+func (x *Ring_Node) Reset() {
+	*x = Ring_Node{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ring_Node) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ring_Node) ProtoMessage() {}
+
+func (x *Ring_Node) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ring_Node.ProtoReflect.Descriptor instead.
+func (*Ring_Node) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{2, 0}
+}
+
+func (x *Ring_Node) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Ring_Node) GetSalt() []byte {
+	if x != nil {
+		return x.Salt
+	}
+	return nil
+}
+
+func (x *Ring_Node) GetWeight() uint32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *Ring_Node) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *Ring_Node) GetRack() string {
+	if x != nil {
+		return x.Rack
+	}
+	return ""
+}
+
+type BatchResponse_Entry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Error string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *BatchResponse_Entry) Reset() {
+	*x = BatchResponse_Entry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchResponse_Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResponse_Entry) ProtoMessage() {}
+
+func (x *BatchResponse_Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResponse_Entry.ProtoReflect.Descriptor instead.
+func (*BatchResponse_Entry) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{3, 0}
+}
+
+func (x *BatchResponse_Entry) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *BatchResponse_Entry) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *BatchResponse_Entry) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Gossip_Member struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name        string              `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Addr        string              `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	Incarnation uint64              `protobuf:"varint,3,opt,name=incarnation,proto3" json:"incarnation,omitempty"`
+	State       Gossip_Member_State `protobuf:"varint,4,opt,name=state,proto3,enum=geecachepb.Gossip_Member_State" json:"state,omitempty"`
+}
+
+func (x *Gossip_Member) Reset() {
+	*x = Gossip_Member{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_geecachepb_geecachepb_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Gossip_Member) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Gossip_Member) ProtoMessage() {}
+
+func (x *Gossip_Member) ProtoReflect() protoreflect.Message {
+	mi := &file_geecachepb_geecachepb_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Gossip_Member.ProtoReflect.Descriptor instead.
+func (*Gossip_Member) Descriptor() ([]byte, []int) {
+	return file_geecachepb_geecachepb_proto_rawDescGZIP(), []int{4, 0}
+}
+
+func (x *Gossip_Member) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Gossip_Member) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *Gossip_Member) GetIncarnation() uint64 {
+	if x != nil {
+		return x.Incarnation
+	}
+	return 0
+}
+
+func (x *Gossip_Member) GetState() Gossip_Member_State {
+	if x != nil {
+		return x.State
+	}
+	return Gossip_Member_ALIVE
+}
+
+var File_geecachepb_geecachepb_proto protoreflect.FileDescriptor
+
+var file_geecachepb_geecachepb_proto_rawDesc = []byte{
+	0x0a, 0x1b, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2f, 0x67, 0x65, 0x65,
+	0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x67,
+	0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x22, 0x95, 0x06, 0x0a, 0x07, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x33, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62,
+	0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x31, 0x0a, 0x05, 0x71, 0x75,
+	0x65, 0x72, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x65, 0x65, 0x63,
+	0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x51,
+	0x75, 0x65, 0x72, 0x79, 0x48, 0x00, 0x52, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x12, 0x34, 0x0a,
+	0x06, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x2e, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x48, 0x00, 0x52, 0x06, 0x6d, 0x61, 0x6e,
+	0x61, 0x67, 0x65, 0x12, 0x34, 0x0a, 0x06, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62,
+	0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x48,
+	0x00, 0x52, 0x06, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x12, 0x36, 0x0a, 0x05, 0x62, 0x61, 0x74,
+	0x63, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61,
+	0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x42, 0x61,
+	0x74, 0x63, 0x68, 0x51, 0x75, 0x65, 0x72, 0x79, 0x48, 0x00, 0x52, 0x05, 0x62, 0x61, 0x74, 0x63,
+	0x68, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x69, 0x6e, 0x67, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x72, 0x69, 0x6e, 0x67, 0x48, 0x61, 0x73, 0x68, 0x12, 0x16,
+	0x0a, 0x06, 0x68, 0x61, 0x73, 0x68, 0x65, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x68, 0x61, 0x73, 0x68, 0x65, 0x72, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x69, 0x6e, 0x67, 0x5f, 0x70,
+	0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x69, 0x6e,
+	0x67, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x1a, 0x2f, 0x0a, 0x05, 0x51, 0x75, 0x65, 0x72, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x1a, 0x98, 0x01, 0x0a, 0x06, 0x4d, 0x61, 0x6e,
+	0x61, 0x67, 0x65, 0x12, 0x31, 0x0a, 0x02, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x21, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x2e, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x2e, 0x4f, 0x70, 0x54, 0x79,
+	0x70, 0x65, 0x52, 0x02, 0x6f, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x06, 0x77, 0x65, 0x69, 0x67,
+	0x68, 0x74, 0x22, 0x2f, 0x0a, 0x06, 0x4f, 0x70, 0x54, 0x79, 0x70, 0x65, 0x12, 0x09, 0x0a, 0x05,
+	0x50, 0x55, 0x52, 0x47, 0x45, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x44, 0x44, 0x10, 0x01,
+	0x12, 0x07, 0x0a, 0x03, 0x53, 0x45, 0x54, 0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x52, 0x49, 0x4e,
+	0x47, 0x10, 0x03, 0x1a, 0x4b, 0x0a, 0x06, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x12, 0x14, 0x0a,
+	0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72,
+	0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x19, 0x0a, 0x08, 0x68, 0x6f, 0x74, 0x5f, 0x6f, 0x6e, 0x6c,
+	0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x68, 0x6f, 0x74, 0x4f, 0x6e, 0x6c, 0x79,
+	0x1a, 0x36, 0x0a, 0x0a, 0x42, 0x61, 0x74, 0x63, 0x68, 0x51, 0x75, 0x65, 0x72, 0x79, 0x12, 0x14,
+	0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67,
+	0x72, 0x6f, 0x75, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x22, 0x4f, 0x0a, 0x0b, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x53, 0x51, 0x55, 0x45,
+	0x52, 0x59, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x49, 0x53, 0x4d, 0x41, 0x4e, 0x41, 0x47, 0x45,
+	0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x49, 0x53, 0x52, 0x45, 0x4d, 0x4f, 0x56, 0x45, 0x10, 0x02,
+	0x12, 0x0b, 0x0a, 0x07, 0x49, 0x53, 0x42, 0x41, 0x54, 0x43, 0x48, 0x10, 0x03, 0x12, 0x0a, 0x0a,
+	0x06, 0x49, 0x53, 0x50, 0x49, 0x4e, 0x47, 0x10, 0x04, 0x42, 0x06, 0x0a, 0x04, 0x62, 0x6f, 0x64,
+	0x79, 0x22, 0x20, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x22, 0x8b, 0x02, 0x0a, 0x04, 0x52, 0x69, 0x6e, 0x67, 0x12, 0x16, 0x0a, 0x06,
+	0x68, 0x61, 0x73, 0x68, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x68, 0x61,
+	0x73, 0x68, 0x65, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x76, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x76, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x19, 0x0a, 0x08,
+	0x73, 0x61, 0x6c, 0x74, 0x5f, 0x6c, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07,
+	0x73, 0x61, 0x6c, 0x74, 0x4c, 0x65, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x61, 0x6c, 0x74, 0x5f,
+	0x66, 0x75, 0x6e, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x61, 0x6c, 0x74,
+	0x46, 0x75, 0x6e, 0x63, 0x12, 0x2b, 0x0a, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x05, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62,
+	0x2e, 0x52, 0x69, 0x6e, 0x67, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x05, 0x6e, 0x6f, 0x64, 0x65,
+	0x73, 0x1a, 0x6e, 0x0a, 0x04, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x73, 0x61, 0x6c, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x73, 0x61, 0x6c,
+	0x74, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x06, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e,
+	0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x72, 0x61, 0x63, 0x6b, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x61, 0x63,
+	0x6b, 0x22, 0x91, 0x01, 0x0a, 0x0d, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x39, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70,
+	0x62, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x1a, 0x45,
+	0x0a, 0x05, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12,
+	0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0xa8, 0x03, 0x0a, 0x06, 0x47, 0x6f, 0x73, 0x73, 0x69, 0x70,
+	0x12, 0x2b, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17,
+	0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x47, 0x6f, 0x73, 0x73,
+	0x69, 0x70, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x73, 0x65, 0x71, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12,
+	0x12, 0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x66,
+	0x72, 0x6f, 0x6d, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x33, 0x0a, 0x07, 0x6d,
+	0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67,
+	0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x47, 0x6f, 0x73, 0x73, 0x69, 0x70,
+	0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x07, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x1a, 0xbe, 0x01, 0x0a, 0x06, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61,
+	0x64, 0x64, 0x72, 0x12, 0x20, 0x0a, 0x0b, 0x69, 0x6e, 0x63, 0x61, 0x72, 0x6e, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x69, 0x6e, 0x63, 0x61, 0x72, 0x6e,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x35, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70,
+	0x62, 0x2e, 0x47, 0x6f, 0x73, 0x73, 0x69, 0x70, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x22, 0x33, 0x0a, 0x05,
+	0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x09, 0x0a, 0x05, 0x41, 0x4c, 0x49, 0x56, 0x45, 0x10, 0x00,
+	0x12, 0x0b, 0x0a, 0x07, 0x53, 0x55, 0x53, 0x50, 0x45, 0x43, 0x54, 0x10, 0x01, 0x12, 0x08, 0x0a,
+	0x04, 0x44, 0x45, 0x41, 0x44, 0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x4c, 0x45, 0x46, 0x54, 0x10,
+	0x03, 0x22, 0x3d, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x49, 0x4e,
+	0x47, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x43, 0x4b, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08,
+	0x50, 0x49, 0x4e, 0x47, 0x5f, 0x52, 0x45, 0x51, 0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x59,
+	0x4e, 0x43, 0x10, 0x03, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x10, 0x04,
+	0x32, 0xae, 0x01, 0x0a, 0x0a, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x43, 0x61, 0x63, 0x68, 0x65, 0x12,
+	0x30, 0x0a, 0x03, 0x47, 0x65, 0x74, 0x12, 0x13, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x67, 0x65,
+	0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x39, 0x0a, 0x07, 0x47, 0x65, 0x74, 0x4d, 0x61, 0x6e, 0x79, 0x12, 0x13, 0x2e, 0x67,
+	0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x19, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x42,
+	0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x33, 0x0a, 0x06,
+	0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x12, 0x13, 0x2e, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x67, 0x65,
+	0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x42, 0x0e, 0x5a, 0x0c, 0x2f, 0x67, 0x65, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62,
+	0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_geecachepb_geecachepb_proto_rawDescOnce sync.Once
+	file_geecachepb_geecachepb_proto_rawDescData = file_geecachepb_geecachepb_proto_rawDesc
+)
+
+func file_geecachepb_geecachepb_proto_rawDescGZIP() []byte {
+	file_geecachepb_geecachepb_proto_rawDescOnce.Do(func() {
+		file_geecachepb_geecachepb_proto_rawDescData = protoimpl.X.CompressGZIP(file_geecachepb_geecachepb_proto_rawDescData)
+	})
+	return file_geecachepb_geecachepb_proto_rawDescData
+}
+
+var file_geecachepb_geecachepb_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_geecachepb_geecachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_geecachepb_geecachepb_proto_goTypes = []interface{}{
+	(Request_RequestType)(0),    // 0: geecachepb.Request.RequestType
+	(Request_Manage_OpType)(0),  // 1: geecachepb.Request.Manage.OpType
+	(Gossip_Type)(0),            // 2: geecachepb.Gossip.Type
+	(Gossip_Member_State)(0),    // 3: geecachepb.Gossip.Member.State
+	(*Request)(nil),             // 4: geecachepb.Request
+	(*Response)(nil),            // 5: geecachepb.Response
+	(*Ring)(nil),                // 6: geecachepb.Ring
+	(*BatchResponse)(nil),       // 7: geecachepb.BatchResponse
+	(*Gossip)(nil),              // 8: geecachepb.Gossip
+	(*Request_Query)(nil),       // 9: geecachepb.Request.Query
+	(*Request_Manage)(nil),      // 10: geecachepb.Request.Manage
+	(*Request_Remove)(nil),      // 11: geecachepb.Request.Remove
+	(*Request_BatchQuery)(nil),  // 12: geecachepb.Request.BatchQuery
+	(*Ring_Node)(nil),           // 13: geecachepb.Ring.Node
+	(*BatchResponse_Entry)(nil), // 14: geecachepb.BatchResponse.Entry
+	(*Gossip_Member)(nil),       // 15: geecachepb.Gossip.Member
+}
+var file_geecachepb_geecachepb_proto_depIdxs = []int32{
+	0,  // 0: geecachepb.Request.type:type_name -> geecachepb.Request.RequestType
+	9,  // 1: geecachepb.Request.query:type_name -> geecachepb.Request.Query
+	10, // 2: geecachepb.Request.manage:type_name -> geecachepb.Request.Manage
+	11, // 3: geecachepb.Request.remove:type_name -> geecachepb.Request.Remove
+	12, // 4: geecachepb.Request.batch:type_name -> geecachepb.Request.BatchQuery
+	13, // 5: geecachepb.Ring.nodes:type_name -> geecachepb.Ring.Node
+	14, // 6: geecachepb.BatchResponse.entries:type_name -> geecachepb.BatchResponse.Entry
+	2,  // 7: geecachepb.Gossip.type:type_name -> geecachepb.Gossip.Type
+	15, // 8: geecachepb.Gossip.members:type_name -> geecachepb.Gossip.Member
+	1,  // 9: geecachepb.Request.Manage.op:type_name -> geecachepb.Request.Manage.OpType
+	3,  // 10: geecachepb.Gossip.Member.state:type_name -> geecachepb.Gossip.Member.State
+	4,  // 11: geecachepb.GroupCache.Get:input_type -> geecachepb.Request
+	4,  // 12: geecachepb.GroupCache.GetMany:input_type -> geecachepb.Request
+	4,  // 13: geecachepb.GroupCache.Remove:input_type -> geecachepb.Request
+	5,  // 14: geecachepb.GroupCache.Get:output_type -> geecachepb.Response
+	7,  // 15: geecachepb.GroupCache.GetMany:output_type -> geecachepb.BatchResponse
+	5,  // 16: geecachepb.GroupCache.Remove:output_type -> geecachepb.Response
+	14, // [14:17] is the sub-list for method output_type
+	11, // [11:14] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_geecachepb_geecachepb_proto_init() }
@@ -463,7 +1316,7 @@ func file_geecachepb_geecachepb_proto_init() {
 			}
 		}
 		file_geecachepb_geecachepb_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Request_Query); i {
+			switch v := v.(*Ring); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -475,6 +1328,42 @@ func file_geecachepb_geecachepb_proto_init() {
 			}
 		}
 		file_geecachepb_geecachepb_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Gossip); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Request_Query); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Request_Manage); i {
 			case 0:
 				return &v.state
@@ -486,18 +1375,80 @@ func file_geecachepb_geecachepb_proto_init() {
 				return nil
 			}
 		}
+		file_geecachepb_geecachepb_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Request_Remove); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Request_BatchQuery); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ring_Node); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchResponse_Entry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_geecachepb_geecachepb_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Gossip_Member); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	file_geecachepb_geecachepb_proto_msgTypes[0].OneofWrappers = []interface{}{
 		(*Request_Query_)(nil),
 		(*Request_Manage_)(nil),
+		(*Request_Remove_)(nil),
+		(*Request_Batch)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_geecachepb_geecachepb_proto_rawDesc,
-			NumEnums:      2,
-			NumMessages:   4,
+			NumEnums:      4,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,679 @@
+// Package gossip keeps track of the members of a cluster
+// with a SWIM-style protocol, so that peers don't have to be managed by hand.
+// https://www.cs.cornell.edu/projects/Quicksilver/public_pdfs/SWIM.pdf
+//
+// Every probe interval a node pings one member, round-robin.
+// If the member doesn't answer in time, a few other members are asked
+// to ping it. If none of them gets an answer either, the member is
+// suspected, and declared dead unless it refutes within the suspect timeout.
+// Membership updates are piggybacked on these messages.
+//
+// Hook a node to an HTTPPool with WithPeerSet, eg.
+//
+//	transport, err := gossip.NewUDPTransport("0.0.0.0:7946")
+//	node, err := gossip.New("http://10.0.0.1:8000/geecache/", transport,
+//		gossip.WithAdvertiseAddr("10.0.0.1:7946"), gossip.WithPeerSet(pool))
+//	node.Join("10.0.0.2:7946")
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// an update is piggybacked retransmitMult * log2(members) times
+	retransmitMult = 3
+	// how many updates a message carries at most
+	maxPiggyback = 16
+)
+
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+	StateLeft
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Member is a node as seen by other nodes
+type Member struct {
+	// Name is what PeerSet is fed, eg. "http://10.0.0.1:8000/geecache/"
+	Name string
+	// Addr is where the member gossips, eg. "10.0.0.1:7946"
+	Addr string
+	// Incarnation is bumped by the member to refute suspicion,
+	// updates of older incarnations are ignored
+	Incarnation uint64
+	State       State
+}
+
+// live members are still part of the cluster, suspects included
+func (m Member) live() bool {
+	return m.State == StateAlive || m.State == StateSuspect
+}
+
+type EventType int
+
+const (
+	EventJoin EventType = iota
+	EventLeave
+)
+
+func (t EventType) String() string {
+	if t == EventJoin {
+		return "join"
+	}
+	return "leave"
+}
+
+// Event tells that a member joined, or left whether it failed or not
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+// PeerSet is fed the names of members as they join and leave.
+// HTTPPool implements it.
+type PeerSet interface {
+	AddPeers(peers ...string) error
+	RemovePeers(peers ...string) error
+}
+
+type member struct {
+	Member
+	suspectedAt time.Time
+}
+
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// Node is a member of the cluster, it must be closed with Close
+type Node struct {
+	name      string
+	transport Transport
+	// advertiseAddr is where other members send messages to this one
+	advertiseAddr string
+
+	probeInterval  time.Duration
+	probeTimeout   time.Duration
+	suspectTimeout time.Duration
+	indirectChecks int
+	onEvent        func(Event)
+	peerSet        PeerSet
+	logger         logger.Logger
+
+	mu         sync.Mutex
+	members    map[string]*member
+	broadcasts map[string]*broadcast
+	acks       map[uint64]func()
+	seq        uint64
+	probeOrder []string
+	leaving    bool
+	pending    []Event
+
+	notify chan struct{}
+	stop   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// New starts a node named name that gossips over t.
+// It's alone until it joins others with Join, or others join it.
+// It errs if the address it advertises can't be reached from other
+// hosts, eg. "0.0.0.0:7946", see WithAdvertiseAddr.
+func New(name string, t Transport, opts ...Option) (*Node, error) {
+	n := &Node{
+		name:           name,
+		transport:      t,
+		probeInterval:  defaultProbeInterval,
+		probeTimeout:   defaultProbeTimeout,
+		suspectTimeout: defaultSuspectTimeout,
+		indirectChecks: defaultIndirectChecks,
+		logger:         logger.Nop,
+		members:        make(map[string]*member),
+		broadcasts:     make(map[string]*broadcast),
+		acks:           make(map[uint64]func()),
+		notify:         make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.advertiseAddr == "" {
+		n.advertiseAddr = t.Addr()
+	}
+	if err := checkAdvertiseAddr(n.advertiseAddr); err != nil {
+		return nil, err
+	}
+	n.logger = logger.With(n.logger, "node", name)
+	n.members[name] = &member{Member: Member{Name: name, Addr: n.advertiseAddr, State: StateAlive}}
+
+	n.wg.Add(3)
+	go n.receiveLoop()
+	go n.probeLoop()
+	go n.dispatchLoop()
+	return n, nil
+}
+
+// checkAdvertiseAddr refuses addresses of no host in particular,
+// which other members can't send messages to
+func checkAdvertiseAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("can't advertise %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("can't advertise %s, other hosts can't reach it, see WithAdvertiseAddr", addr)
+	}
+	return nil
+}
+
+// Join asks the seeds, gossip addresses of any members, for the membership.
+// It returns once one of them answers, the rest of the cluster
+// learns about this node through gossip.
+func (n *Node) Join(seeds ...string) error {
+	seq := n.nextSeq()
+	synced := make(chan struct{}, 1)
+	n.onAck(seq, signal(synced))
+	defer n.clearAck(seq)
+
+	sent := 0
+	for _, seed := range seeds {
+		if seed == n.advertiseAddr {
+			continue
+		}
+		if err := n.send(seed, n.fullState(pb.Gossip_SYNC, seq)); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return errors.New("no seed to join")
+	}
+	if !n.wait(synced, n.probeInterval) {
+		return errors.New("no seed answered")
+	}
+	return nil
+}
+
+// Leave tells the members that this node is leaving on purpose,
+// so that they don't have to detect it. Close it afterwards.
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	n.leaving = true
+	self := n.members[n.name]
+	self.Incarnation++
+	self.State = StateLeft
+	update := []*pb.Gossip_Member{toPb(self.Member)}
+	addrs := make([]string, 0, len(n.members))
+	for _, m := range n.members {
+		if m.Name != n.name && m.live() {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	n.mu.Unlock()
+
+	msg := &pb.Gossip{Type: pb.Gossip_UPDATE, From: n.advertiseAddr, Members: update}
+	for _, addr := range addrs {
+		n.send(addr, msg)
+	}
+	return nil
+}
+
+// Close stops the node and closes its transport.
+// It can be called more than once.
+func (n *Node) Close() error {
+	var err error
+	n.once.Do(func() {
+		close(n.stop)
+		err = n.transport.Close()
+		n.wg.Wait()
+	})
+	return err
+}
+
+// Members returns the live members, this node included, sorted by name
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ret := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		if m.live() {
+			ret = append(ret, m.Member)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (n *Node) receiveLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case b, ok := <-n.transport.Packets():
+			if !ok {
+				return
+			}
+			n.handle(b)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *Node) handle(b []byte) {
+	msg := &pb.Gossip{}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		n.logger.Warn("can't unmarshal gossip", "err", err)
+		return
+	}
+	n.merge(msg.Members)
+
+	switch msg.Type {
+	case pb.Gossip_PING:
+		n.send(msg.From, n.message(pb.Gossip_ACK, msg.Seq, ""))
+	case pb.Gossip_ACK:
+		n.ack(msg.Seq)
+	case pb.Gossip_PING_REQ:
+		// ping the target on our own seq and forward its ack
+		from, seq := msg.From, msg.Seq
+		ownSeq := n.nextSeq()
+		n.onAck(ownSeq, func() {
+			n.send(from, n.message(pb.Gossip_ACK, seq, ""))
+		})
+		time.AfterFunc(n.probeTimeout, func() { n.clearAck(ownSeq) })
+		n.send(msg.Target, n.message(pb.Gossip_PING, ownSeq, ""))
+	case pb.Gossip_SYNC:
+		n.send(msg.From, n.fullState(pb.Gossip_ACK, msg.Seq))
+	}
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.reapSuspects()
+			if target, ok := n.nextTarget(); ok {
+				n.probe(target)
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// probe suspects target if neither it nor others on its behalf
+// answer a ping within the probe interval
+func (n *Node) probe(target Member) {
+	seq := n.nextSeq()
+	acked := make(chan struct{}, 1)
+	n.onAck(seq, signal(acked))
+	defer n.clearAck(seq)
+
+	n.send(target.Addr, n.message(pb.Gossip_PING, seq, ""))
+	if n.wait(acked, n.probeTimeout) {
+		return
+	}
+
+	for _, m := range n.randomMembers(n.indirectChecks, target.Name) {
+		n.send(m.Addr, n.message(pb.Gossip_PING_REQ, seq, target.Addr))
+	}
+	if n.wait(acked, n.probeInterval-n.probeTimeout) {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	m, ok := n.members[target.Name]
+	if !ok || m.State != StateAlive || m.Incarnation != target.Incarnation {
+		// it changed while being probed
+		return
+	}
+	n.logger.Info("suspecting member", "member", m.Name)
+	m.State = StateSuspect
+	m.suspectedAt = time.Now()
+	n.queue(m.Member)
+}
+
+// reapSuspects declares suspects that didn't refute in time dead
+func (n *Node) reapSuspects() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, m := range n.members {
+		if m.State == StateSuspect && time.Since(m.suspectedAt) >= n.suspectTimeout {
+			n.logger.Info("member is dead", "member", m.Name)
+			m.State = StateDead
+			n.queue(m.Member)
+			n.emit(EventLeave, m.Member)
+		}
+	}
+}
+
+// nextTarget goes through the live members in a random order, round-robin
+func (n *Node) nextTarget() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.probeOrder) == 0 {
+		for name, m := range n.members {
+			if name != n.name && m.live() {
+				n.probeOrder = append(n.probeOrder, name)
+			}
+		}
+		rand.Shuffle(len(n.probeOrder), func(i, j int) {
+			n.probeOrder[i], n.probeOrder[j] = n.probeOrder[j], n.probeOrder[i]
+		})
+	}
+	for len(n.probeOrder) > 0 {
+		name := n.probeOrder[0]
+		n.probeOrder = n.probeOrder[1:]
+		if m, ok := n.members[name]; ok && m.live() {
+			return m.Member, true
+		}
+	}
+	return Member{}, false
+}
+
+// randomMembers picks up to k live members but itself and except
+func (n *Node) randomMembers(k int, except string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ret := make([]Member, 0, len(n.members))
+	for name, m := range n.members {
+		if name != n.name && name != except && m.live() {
+			ret = append(ret, m.Member)
+		}
+	}
+	rand.Shuffle(len(ret), func(i, j int) { ret[i], ret[j] = ret[j], ret[i] })
+	if len(ret) > k {
+		ret = ret[:k]
+	}
+	return ret
+}
+
+// merge applies updates from another node
+func (n *Node) merge(updates []*pb.Gossip_Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, u := range updates {
+		n.apply(fromPb(u))
+	}
+}
+
+// apply follows the rules of SWIM, newer incarnations win,
+// and suspect overrides alive of the same incarnation.
+// It must be called with mu held.
+func (n *Node) apply(u Member) {
+	if u.Name == n.name {
+		self := n.members[n.name]
+		if !n.leaving && u.State != StateAlive && u.Incarnation >= self.Incarnation {
+			// refute by outliving the incarnation others know
+			self.Incarnation = u.Incarnation + 1
+			n.logger.Info("refuting", "state", u.State, "incarnation", self.Incarnation)
+			n.queue(self.Member)
+		}
+		return
+	}
+
+	m, ok := n.members[u.Name]
+	if !ok {
+		// dead ones are recorded too, so that stale updates are ignored
+		n.members[u.Name] = &member{Member: u, suspectedAt: time.Now()}
+		n.queue(u)
+		if u.live() {
+			n.emit(EventJoin, u)
+		}
+		return
+	}
+
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= m.Incarnation {
+			return
+		}
+		wasLive := m.live()
+		m.Member = u
+		n.queue(u)
+		if !wasLive {
+			n.emit(EventJoin, u)
+		}
+	case StateSuspect:
+		if !m.live() || u.Incarnation < m.Incarnation ||
+			u.Incarnation == m.Incarnation && m.State == StateSuspect {
+			return
+		}
+		m.Member = u
+		m.suspectedAt = time.Now()
+		n.queue(u)
+	case StateDead, StateLeft:
+		if !m.live() || u.Incarnation < m.Incarnation {
+			return
+		}
+		m.Member = u
+		n.queue(u)
+		n.emit(EventLeave, u)
+	}
+}
+
+// queue makes the update piggybacked on the next messages,
+// replacing older updates of the same member.
+// It must be called with mu held.
+func (n *Node) queue(m Member) {
+	n.broadcasts[m.Name] = &broadcast{member: m}
+}
+
+// emit hands the event to dispatchLoop so that the handlers
+// are called in order and without mu held.
+// It must be called with mu held.
+func (n *Node) emit(typ EventType, m Member) {
+	n.pending = append(n.pending, Event{Type: typ, Member: m})
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) dispatchLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.notify:
+			n.mu.Lock()
+			events := n.pending
+			n.pending = nil
+			n.mu.Unlock()
+			for _, e := range events {
+				n.dispatch(e)
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *Node) dispatch(e Event) {
+	n.logger.Debug("membership changed", "event", e.Type, "member", e.Member.Name)
+	if n.onEvent != nil {
+		n.onEvent(e)
+	}
+	if n.peerSet == nil {
+		return
+	}
+	var err error
+	if e.Type == EventJoin {
+		err = n.peerSet.AddPeers(e.Member.Name)
+	} else {
+		err = n.peerSet.RemovePeers(e.Member.Name)
+	}
+	if err != nil {
+		n.logger.Warn("can't update peers", "event", e.Type, "member", e.Member.Name, "err", err)
+	}
+}
+
+// message builds a message with the updates to piggyback
+func (n *Node) message(typ pb.Gossip_Type, seq uint64, target string) *pb.Gossip {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &pb.Gossip{
+		Type:    typ,
+		Seq:     seq,
+		From:    n.advertiseAddr,
+		Target:  target,
+		Members: n.piggyback(),
+	}
+}
+
+// piggyback takes the updates sent the fewest times,
+// and drops those that have been sent enough.
+// It must be called with mu held.
+func (n *Node) piggyback() []*pb.Gossip_Member {
+	live := 0
+	for _, m := range n.members {
+		if m.live() {
+			live++
+		}
+	}
+	limit := retransmitMult * bits.Len(uint(live))
+
+	pending := make([]*broadcast, 0, len(n.broadcasts))
+	for _, b := range n.broadcasts {
+		pending = append(pending, b)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].transmits < pending[j].transmits })
+	if len(pending) > maxPiggyback {
+		pending = pending[:maxPiggyback]
+	}
+
+	ret := make([]*pb.Gossip_Member, 0, len(pending))
+	for _, b := range pending {
+		ret = append(ret, toPb(b.member))
+		b.transmits++
+		if b.transmits >= limit {
+			delete(n.broadcasts, b.member.Name)
+		}
+	}
+	return ret
+}
+
+// fullState builds a message carrying every member, the dead ones included
+func (n *Node) fullState(typ pb.Gossip_Type, seq uint64) *pb.Gossip {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	members := make([]*pb.Gossip_Member, 0, len(n.members))
+	for _, m := range n.members {
+		members = append(members, toPb(m.Member))
+	}
+	return &pb.Gossip{Type: typ, Seq: seq, From: n.advertiseAddr, Members: members}
+}
+
+func (n *Node) send(addr string, msg *pb.Gossip) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := n.transport.Send(addr, b); err != nil {
+		n.logger.Debug("can't send gossip", "addr", addr, "type", msg.Type, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (n *Node) nextSeq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	return n.seq
+}
+
+func (n *Node) onAck(seq uint64, fn func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.acks[seq] = fn
+}
+
+func (n *Node) clearAck(seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.acks, seq)
+}
+
+func (n *Node) ack(seq uint64) {
+	n.mu.Lock()
+	fn, ok := n.acks[seq]
+	n.mu.Unlock()
+	if ok {
+		fn()
+	}
+}
+
+// wait tells whether ch is signaled within d,
+// a node being closed counts as signaled so that nothing is suspected
+func (n *Node) wait(ch chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-n.stop:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func signal(ch chan struct{}) func() {
+	return func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func toPb(m Member) *pb.Gossip_Member {
+	return &pb.Gossip_Member{
+		Name:        m.Name,
+		Addr:        m.Addr,
+		Incarnation: m.Incarnation,
+		State:       pb.Gossip_Member_State(m.State),
+	}
+}
+
+func fromPb(m *pb.Gossip_Member) Member {
+	return Member{
+		Name:        m.Name,
+		Addr:        m.Addr,
+		Incarnation: m.Incarnation,
+		State:       State(m.State),
+	}
+}
@@ -0,0 +1,242 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	geecache "github.com/Hawk-Zhou/better-groupcache"
+)
+
+var _ PeerSet = (*geecache.HTTPPool)(nil)
+
+// peerSet records what a node feeds it
+type peerSet struct {
+	mu    sync.Mutex
+	peers map[string]bool
+}
+
+func (ps *peerSet) AddPeers(peers ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, peer := range peers {
+		ps.peers[peer] = true
+	}
+	return nil
+}
+
+func (ps *peerSet) RemovePeers(peers ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, peer := range peers {
+		delete(ps.peers, peer)
+	}
+	return nil
+}
+
+func (ps *peerSet) list() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ret := make([]string, 0, len(ps.peers))
+	for peer := range ps.peers {
+		ret = append(ret, peer)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+type testNode struct {
+	*Node
+	addr  string
+	peers *peerSet
+}
+
+func fastOptions() []Option {
+	return []Option{
+		WithProbeInterval(20 * time.Millisecond),
+		WithProbeTimeout(5 * time.Millisecond),
+		WithSuspectTimeout(100 * time.Millisecond),
+	}
+}
+
+func startCluster(t *testing.T, network *MemNetwork, size int) []*testNode {
+	nodes := make([]*testNode, 0, size)
+	for i := 0; i < size; i++ {
+		addr := fmt.Sprintf("10.0.0.%d:7946", i)
+		ps := &peerSet{peers: make(map[string]bool)}
+		opts := append(fastOptions(), WithPeerSet(ps))
+		node, err := New(fmt.Sprintf("http://10.0.0.%d:8000/geecache/", i), network.NewTransport(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		if i > 0 {
+			if err := node.Join(nodes[0].addr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, &testNode{Node: node, addr: addr, peers: ps})
+	}
+	return nodes
+}
+
+// eventually polls cond for up to 3 seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// converged tells whether each node sees exactly want, and has fed
+// its PeerSet with all of them but itself
+func converged(nodes []*testNode, want []*testNode) bool {
+	names := make([]string, 0, len(want))
+	for _, n := range want {
+		names = append(names, n.name)
+	}
+	sort.Strings(names)
+	for _, n := range nodes {
+		got := make([]string, 0)
+		for _, m := range n.Members() {
+			got = append(got, m.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(names) {
+			return false
+		}
+		others := make([]string, 0)
+		for _, name := range names {
+			if name != n.name {
+				others = append(others, name)
+			}
+		}
+		if fmt.Sprint(n.peers.list()) != fmt.Sprint(others) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConvergence(t *testing.T) {
+	network := NewMemNetwork()
+	nodes := startCluster(t, network, 5)
+	eventually(t, "all nodes to join", func() bool { return converged(nodes, nodes) })
+
+	// a crashed node is detected and removed everywhere
+	network.SetDown(nodes[4].addr, true)
+	eventually(t, "the crashed node to be removed", func() bool { return converged(nodes[:4], nodes[:4]) })
+
+	// a node leaving on purpose is removed without waiting for suspicion
+	nodes[3].Leave()
+	nodes[3].Close()
+	eventually(t, "the node to leave", func() bool { return converged(nodes[:3], nodes[:3]) })
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	network := NewMemNetwork()
+	nodes := startCluster(t, network, 3)
+	eventually(t, "all nodes to join", func() bool { return converged(nodes, nodes) })
+
+	// a member wrongly suspected refutes with a newer incarnation
+	suspected := nodes[2].name
+	nodes[0].mu.Lock()
+	m := nodes[0].members[suspected]
+	m.State = StateSuspect
+	m.suspectedAt = time.Now()
+	nodes[0].queue(m.Member)
+	nodes[0].mu.Unlock()
+
+	eventually(t, "the suspicion to be refuted", func() bool {
+		for _, m := range nodes[0].Members() {
+			if m.Name == suspected {
+				return m.State == StateAlive && m.Incarnation > 0
+			}
+		}
+		return false
+	})
+	if !converged(nodes, nodes) {
+		t.Error("no node should have left")
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	nodes := make([]*Node, 0, 3)
+	for i := 0; i < 3; i++ {
+		transport, err := NewUDPTransport("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := New(fmt.Sprint("node", i), transport, fastOptions()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer node.Close()
+		if i > 0 {
+			if err := node.Join(nodes[0].transport.Addr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	eventually(t, "all nodes to join", func() bool {
+		for _, n := range nodes {
+			if len(n.Members()) != 3 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	seedTransport, err := NewUDPTransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := New("seed", seedTransport, fastOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seed.Close()
+
+	transport, err := NewUDPTransport("0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if _, err := New("node", transport, fastOptions()...); err == nil {
+		t.Fatalf("advertising %s should be refused", transport.Addr())
+	}
+	for _, addr := range []string{"[::]:7946", ":7946", "7946"} {
+		if _, err := New("node", transport, WithAdvertiseAddr(addr)); err == nil {
+			t.Errorf("advertising %s should be refused", addr)
+		}
+	}
+
+	_, port, _ := net.SplitHostPort(transport.Addr())
+	addr := net.JoinHostPort("127.0.0.1", port)
+	node, err := New("node", transport, append(fastOptions(), WithAdvertiseAddr(addr))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	if err := node.Join(seed.advertiseAddr); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the seed to learn the advertised address", func() bool {
+		for _, m := range seed.Members() {
+			if m.Name == "node" {
+				return m.Addr == addr && m.State == StateAlive
+			}
+		}
+		return false
+	})
+}
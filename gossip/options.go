@@ -0,0 +1,81 @@
+package gossip
+
+import (
+	"time"
+
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+)
+
+const (
+	defaultProbeInterval  = time.Second
+	defaultProbeTimeout   = 500 * time.Millisecond
+	defaultSuspectTimeout = 5 * time.Second
+	defaultIndirectChecks = 3
+)
+
+// Option configures a Node when it's created by New
+type Option func(*Node)
+
+// WithProbeInterval sets how often a member is probed.
+// A member that doesn't answer directly or through others
+// within one interval is suspected.
+func WithProbeInterval(d time.Duration) Option {
+	return func(n *Node) {
+		n.probeInterval = d
+	}
+}
+
+// WithProbeTimeout sets how long a direct ping is waited for
+// before others are asked to ping. It should be less than the interval.
+func WithProbeTimeout(d time.Duration) Option {
+	return func(n *Node) {
+		n.probeTimeout = d
+	}
+}
+
+// WithSuspectTimeout sets how long a suspected member has
+// to refute before it's declared dead
+func WithSuspectTimeout(d time.Duration) Option {
+	return func(n *Node) {
+		n.suspectTimeout = d
+	}
+}
+
+// WithIndirectChecks sets how many members are asked to ping
+// a member that doesn't answer directly
+func WithIndirectChecks(k int) Option {
+	return func(n *Node) {
+		n.indirectChecks = k
+	}
+}
+
+// WithAdvertiseAddr sets the address other members send messages to,
+// eg. "10.0.0.1:7946" for a transport listening on "0.0.0.0:7946".
+// It defaults to Addr of the transport.
+func WithAdvertiseAddr(addr string) Option {
+	return func(n *Node) {
+		n.advertiseAddr = addr
+	}
+}
+
+// WithEventFunc is told when members join or leave
+func WithEventFunc(fn func(Event)) Option {
+	return func(n *Node) {
+		n.onEvent = fn
+	}
+}
+
+// WithPeerSet keeps ps in sync with the membership, eg. an HTTPPool.
+// Members are named after their peer address, see New.
+func WithPeerSet(ps PeerSet) Option {
+	return func(n *Node) {
+		n.peerSet = ps
+	}
+}
+
+// WithLogger sets the logger of the node
+func WithLogger(l logger.Logger) Option {
+	return func(n *Node) {
+		n.logger = logger.OrNop(l)
+	}
+}
@@ -0,0 +1,170 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// maxPacketSize bounds messages over UDP.
+// A SYNC carries the whole membership, which fits
+// for clusters of up to a few hundred nodes.
+const maxPacketSize = 65507
+
+// Transport delivers messages between nodes, best effort.
+// Like UDP, it may drop messages, and the protocol copes with that.
+type Transport interface {
+	// Addr is where the transport listens, which nodes advertise
+	// unless told otherwise, see WithAdvertiseAddr
+	Addr() string
+	Send(addr string, msg []byte) error
+	// Packets are the messages received, it's closed by Close
+	Packets() <-chan []byte
+	Close() error
+}
+
+// UDPTransport sends every message in one datagram
+type UDPTransport struct {
+	conn    net.PacketConn
+	packets chan []byte
+}
+
+// NewUDPTransport listens on addr, eg. "0.0.0.0:7946".
+// A port of 0 picks a free one, see Addr. Nodes on a transport listening
+// on all interfaces must be told what to advertise, see WithAdvertiseAddr.
+func NewUDPTransport(addr string) (*UDPTransport, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &UDPTransport{conn: conn, packets: make(chan []byte, 64)}
+	go t.read()
+	return t, nil
+}
+
+func (t *UDPTransport) read() {
+	defer close(t.packets)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		select {
+		case t.packets <- msg:
+		default:
+			// the node is too busy, drop it as the network would
+		}
+	}
+}
+
+func (t *UDPTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+func (t *UDPTransport) Send(addr string, msg []byte) error {
+	if len(msg) > maxPacketSize {
+		return errors.New("message is too large for a datagram")
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.WriteTo(msg, udpAddr)
+	return err
+}
+
+func (t *UDPTransport) Packets() <-chan []byte {
+	return t.packets
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MemNetwork connects MemTransports in one process,
+// so that a cluster can be tested without sockets.
+type MemNetwork struct {
+	mu         sync.Mutex
+	transports map[string]*MemTransport
+	down       map[string]bool
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		transports: make(map[string]*MemTransport),
+		down:       make(map[string]bool),
+	}
+}
+
+// NewTransport attaches a node at addr to the network
+func (mn *MemNetwork) NewTransport(addr string) *MemTransport {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	t := &MemTransport{network: mn, addr: addr, packets: make(chan []byte, 64)}
+	mn.transports[addr] = t
+	return t
+}
+
+// SetDown drops every message to and from addr while down,
+// as if the node crashed or got partitioned away
+func (mn *MemNetwork) SetDown(addr string, down bool) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	mn.down[addr] = down
+}
+
+func (mn *MemNetwork) deliver(from string, to string, msg []byte) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	t, ok := mn.transports[to]
+	if !ok || mn.down[from] || mn.down[to] {
+		return
+	}
+	select {
+	case t.packets <- msg:
+	default:
+	}
+}
+
+func (mn *MemNetwork) detach(t *MemTransport) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	if mn.transports[t.addr] == t {
+		delete(mn.transports, t.addr)
+		close(t.packets)
+	}
+}
+
+// MemTransport is a Transport on a MemNetwork
+type MemTransport struct {
+	network *MemNetwork
+	addr    string
+	packets chan []byte
+}
+
+func (t *MemTransport) Addr() string {
+	return t.addr
+}
+
+func (t *MemTransport) Send(addr string, msg []byte) error {
+	t.network.deliver(t.addr, addr, msg)
+	return nil
+}
+
+func (t *MemTransport) Packets() <-chan []byte {
+	return t.packets
+}
+
+func (t *MemTransport) Close() error {
+	t.network.detach(t)
+	return nil
+}
+
+var _ Transport = (*UDPTransport)(nil)
+var _ Transport = (*MemTransport)(nil)
@@ -0,0 +1,462 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCGetter talks to a peer over the GroupCache service
+// One connection is shared by all requests to the peer (HTTP/2 multiplexing)
+type GRPCGetter struct {
+	addr   string // "0.0.0.0:8000"
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	// pool tells how our ring is built, see HTTPGetter
+	pool *GRPCPool
+}
+
+func (gg *GRPCGetter) Get(group string, key string) ([]byte, error) {
+	return gg.GetContext(context.Background(), group, key)
+}
+
+// GetContext sends the query, gRPC carries the deadline of ctx to the peer
+func (gg *GRPCGetter) GetContext(ctx context.Context, group string, key string) ([]byte, error) {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISQUERY
+	requestPb.Body = &pb.Request_Query_{Query: &pb.Request_Query{Group: group, Key: key}}
+	gg.stamp(requestPb)
+
+	var header metadata.MD
+	resp, err := gg.client.Get(ctx, requestPb, grpc.Header(&header))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if err := gg.checkCompatible(header); err != nil {
+		return nil, err
+	}
+	gg.checkRing(ctx, header)
+	return resp.Value, nil
+}
+
+// Remove asks the peer to delete the key, from hotCache only if hotOnly
+func (gg *GRPCGetter) Remove(ctx context.Context, group string, key string, hotOnly bool) error {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISREMOVE
+	requestPb.Body = &pb.Request_Remove_{Remove: &pb.Request_Remove{Group: group, Key: key, HotOnly: hotOnly}}
+	gg.stamp(requestPb)
+
+	var header metadata.MD
+	if _, err := gg.client.Remove(ctx, requestPb, grpc.Header(&header)); err != nil {
+		return fromStatus(err)
+	}
+	return gg.checkCompatible(header)
+}
+
+// GetMany sends one BatchQuery for all keys
+func (gg *GRPCGetter) GetMany(ctx context.Context, group string, keys []string) (map[string][]byte, error) {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISBATCH
+	requestPb.Body = &pb.Request_Batch{Batch: &pb.Request_BatchQuery{Group: group, Keys: keys}}
+	gg.stamp(requestPb)
+
+	var header metadata.MD
+	resp, err := gg.client.GetMany(ctx, requestPb, grpc.Header(&header))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if err := gg.checkCompatible(header); err != nil {
+		return nil, err
+	}
+	gg.checkRing(ctx, header)
+	return fromBatchResponse(resp)
+}
+
+// stamp tells the peer how our ring is built, as HTTPGetter does
+func (gg *GRPCGetter) stamp(requestPb *pb.Request) {
+	if gg.pool == nil {
+		return
+	}
+	requestPb.RingHash = gg.pool.RingHash()
+	requestPb.Hasher = gg.pool.Hasher()
+	requestPb.RingParams = gg.pool.RingParams()
+}
+
+// checkCompatible is HTTPGetter.checkCompatible for the response
+// metadata, which carries the same headers
+func (gg *GRPCGetter) checkCompatible(header metadata.MD) error {
+	requestPb := &pb.Request{}
+	gg.stamp(requestPb)
+	if reason := incompatible(requestPb.Hasher, requestPb.RingParams,
+		first(header, hasherHeader), first(header, ringParamsHeader)); reason != "" {
+		return fmt.Errorf("peer %s is incompatible: %s", gg.addr, reason)
+	}
+	return nil
+}
+
+// checkRing is HTTPGetter.checkRing for the response metadata
+func (gg *GRPCGetter) checkRing(ctx context.Context, header metadata.MD) {
+	theirs, err := strconv.ParseUint(first(header, ringHeader), 10, 64)
+	if err != nil || gg.pool == nil {
+		return
+	}
+	if theirs != gg.pool.RingHash() {
+		markNoCache(ctx)
+	}
+}
+
+// first is the first value of key in md, "" if there's none
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// String is the address of the peer
+func (gg *GRPCGetter) String() string {
+	return gg.addr
+}
+
+// fromStatus makes deadline errors from peers match context.DeadlineExceeded
+// as HTTPGetter does
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.DeadlineExceeded {
+		return fmt.Errorf("%v: %w", err, context.DeadlineExceeded)
+	}
+	return err
+}
+
+var _ PeerGetter = (*GRPCGetter)(nil)
+var _ ContextPeerGetter = (*GRPCGetter)(nil)
+var _ PeerRemover = (*GRPCGetter)(nil)
+var _ BatchPeerGetter = (*GRPCGetter)(nil)
+
+// GRPCPool is the gRPC counterpart of HTTPPool.
+// It picks peers with the same consistent hash
+// and serves the GroupCache service declared in geecachepb.
+// Peers compare their rings in requests and response metadata
+// as HTTPPool peers do in headers.
+type GRPCPool struct {
+	pb.UnimplementedGroupCacheServer
+
+	self     string // "ip:port" that peers reach this node at
+	mu       sync.RWMutex
+	peers    *consistentHash.CHash
+	ringHash uint64 // fingerprint of peers, kept up to date under mu
+	getters  map[string]*GRPCGetter
+	dialOpts []grpc.DialOption
+	logger   logger.Logger
+	// cache holds the groups the pool serves, DefaultCache unless it's
+	// made by Cache.NewGRPCPool
+	cache *Cache
+}
+
+// GRPCPoolOption configures a GRPCPool when it's created by NewGRPCPool
+type GRPCPoolOption func(*GRPCPool)
+
+// WithDialOptions replaces the default of dialing peers without TLS
+func WithDialOptions(opts ...grpc.DialOption) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.dialOpts = opts
+	}
+}
+
+// WithGRPCPoolLogger sets the logger of the pool
+func WithGRPCPoolLogger(l logger.Logger) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.logger = logger.OrNop(l)
+	}
+}
+
+// NewGRPCPool should be initialized with AddPeers.
+// self is the address peers use to reach this node, eg. "10.0.0.1:8000".
+func NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	p := &GRPCPool{
+		self:     self,
+		peers:    consistentHash.NewCHash(nil),
+		getters:  make(map[string]*GRPCGetter),
+		dialOpts: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		logger:   logger.Nop,
+		cache:    DefaultCache,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.ringHash = p.peers.Fingerprint()
+	p.logger = logger.With(p.logger, "pool", p.self)
+	return p
+}
+
+// NewServer returns a grpc.Server serving p.
+// Run Server.Serve in a goroutine, or it blocks.
+func (p *GRPCPool) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	pb.RegisterGroupCacheServer(s, p)
+	return s
+}
+
+// AddPeers set peers of this format: "0.0.0.0:8000"
+// * also register itself automatically
+// * idempotent operation (ignores duplicated add)
+// Connections are established lazily by gRPC.
+func (p *GRPCPool) AddPeers(peers ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// copied so that the caller's slice isn't written to
+	peers = append(append(make([]string, 0, len(peers)+1), peers...), p.self)
+
+	for _, peer := range peers {
+		if _, ok := p.peers.NameToSalt[peer]; ok {
+			continue
+		}
+
+		var getter *GRPCGetter
+		if peer != p.self {
+			conn, err := grpc.NewClient(peer, p.dialOpts...)
+			if err != nil {
+				return fmt.Errorf("can't dial the peer %s: %w", peer, err)
+			}
+			getter = &GRPCGetter{addr: peer, conn: conn, client: pb.NewGroupCacheClient(conn), pool: p}
+		}
+
+		if err := p.peers.AddNode(peer); err != nil {
+			if getter != nil {
+				getter.conn.Close()
+			}
+			return fmt.Errorf("can't add the peer %s: %w", peer, err)
+		}
+		if getter != nil {
+			p.getters[peer] = getter
+		}
+	}
+	p.ringHash = p.peers.Fingerprint()
+
+	return nil
+}
+
+// RemovePeers remove a set of peers and close connections to them
+// * Not idempotent
+// * Errs if not exist
+func (p *GRPCPool) RemovePeers(peers ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, peer := range peers {
+		if err := p.peers.RemoveNode(peer); err != nil {
+			return fmt.Errorf("can't remove peers: %w", err)
+		}
+		if getter, ok := p.getters[peer]; ok {
+			getter.conn.Close()
+			delete(p.getters, peer)
+		}
+	}
+	p.ringHash = p.peers.Fingerprint()
+
+	return nil
+}
+
+// RingHash is the fingerprint of the ring of the pool, see HTTPPool.RingHash
+func (p *GRPCPool) RingHash() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ringHash
+}
+
+// Hasher names the hash function of the ring of the pool
+func (p *GRPCPool) Hasher() string {
+	return p.peers.HasherName()
+}
+
+// RingParams tell how the ring of the pool is built besides its peers
+func (p *GRPCPool) RingParams() string {
+	return p.peers.Params().String()
+}
+
+// Close closes the connections to all peers
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for peer, getter := range p.getters {
+		if cerr := getter.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(p.getters, peer)
+	}
+	return err
+}
+
+// PickPeer returns a peer if peer is valid
+// and is not the caller itself.
+// * Return false is no peer exists.
+func (p *GRPCPool) PickPeer(query string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.peers.Len() == 0 {
+		return nil, false
+	}
+	peer := p.peers.FindNode(query)
+	if peer == p.self {
+		return nil, false
+	}
+	getter, ok := p.getters[peer]
+	return getter, ok
+}
+
+// AllPeers returns the getters of all peers but itself
+func (p *GRPCPool) AllPeers() []PeerGetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ret := make([]PeerGetter, 0, len(p.getters))
+	for _, getter := range p.getters {
+		ret = append(ret, getter)
+	}
+	return ret
+}
+
+// Get implements the GroupCache service
+func (p *GRPCPool) Get(ctx context.Context, requestPb *pb.Request) (*pb.Response, error) {
+	query := requestPb.GetQuery()
+	if requestPb.GetType() != pb.Request_ISQUERY || query == nil {
+		return nil, status.Error(codes.InvalidArgument, "bad request.query (got nil after unmarshal)")
+	}
+	ctx, err := p.checkCaller(ctx, requestPb)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Debug("got query", "group", query.Group, "key", query.Key)
+	return p.answerQuery(ctx, query.Group, query.Key)
+}
+
+// Remove implements the GroupCache service
+func (p *GRPCPool) Remove(ctx context.Context, requestPb *pb.Request) (*pb.Response, error) {
+	remove := requestPb.GetRemove()
+	if requestPb.GetType() != pb.Request_ISREMOVE || remove == nil {
+		return nil, status.Error(codes.InvalidArgument, "bad request.remove (got nil after unmarshal)")
+	}
+	if _, err := p.checkCaller(ctx, requestPb); err != nil {
+		return nil, err
+	}
+	p.logger.Debug("got remove", "group", remove.Group, "key", remove.Key, "hotOnly", remove.HotOnly)
+	g, err := p.lookupGroup(remove.Group, remove.Key)
+	if err != nil {
+		return nil, err
+	}
+	g.removeLocally(remove.Key, remove.HotOnly)
+	return &pb.Response{}, nil
+}
+
+// GetMany implements the GroupCache service.
+// Keys that fail are reported in their entries, not as a status.
+func (p *GRPCPool) GetMany(ctx context.Context, requestPb *pb.Request) (*pb.BatchResponse, error) {
+	batch := requestPb.GetBatch()
+	if requestPb.GetType() != pb.Request_ISBATCH || batch == nil {
+		return nil, status.Error(codes.InvalidArgument, "bad request.batch (got nil after unmarshal)")
+	}
+	ctx, err := p.checkCaller(ctx, requestPb)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Debug("got batch", "group", batch.Group, "keys", len(batch.Keys))
+	if len(batch.Keys) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "group name / keys should be not null")
+	}
+	g, err := p.lookupGroup(batch.Group, batch.Keys[0])
+	if err != nil {
+		return nil, err
+	}
+	g.stats.serverRequests.Add(1)
+
+	ret, err := g.GetMany(withFromPeer(ctx), batch.Keys)
+	if _, partial := err.(KeyErrors); err != nil && !partial {
+		return nil, toStatus(err)
+	}
+	return toBatchResponse(batch.Keys, ret, err), nil
+}
+
+func (p *GRPCPool) answerQuery(ctx context.Context, group string, key string) (*pb.Response, error) {
+	g, err := p.lookupGroup(group, key)
+	if err != nil {
+		return nil, err
+	}
+	g.stats.serverRequests.Add(1)
+
+	ret, err := g.GetContext(withFromPeer(ctx), key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.Response{Value: ret.Get()}, nil
+}
+
+// checkCaller is what HTTPPool.ServeHTTP does with the ring of the caller:
+// the response metadata tells ours, callers whose rings are built
+// differently are refused, and loads for callers whose rings differ
+// from ours aren't cached
+func (p *GRPCPool) checkCaller(ctx context.Context, requestPb *pb.Request) (context.Context, error) {
+	hasher, params, ringHash := p.Hasher(), p.RingParams(), p.RingHash()
+	// fails only outside of a gRPC call, eg. in tests
+	grpc.SetHeader(ctx, metadata.Pairs(hasherHeader, hasher, ringParamsHeader, params,
+		ringHeader, strconv.FormatUint(ringHash, 10)))
+	if reason := incompatible(hasher, params, requestPb.Hasher, requestPb.RingParams); reason != "" {
+		// we'd never agree on who owns a key
+		p.logger.Warn("refusing an incompatible peer", "reason", reason)
+		return nil, status.Error(codes.FailedPrecondition, reason)
+	}
+	if requestPb.RingHash != 0 && requestPb.RingHash != ringHash {
+		// the caller may think we own keys we don't
+		p.logger.Debug("ring differs from the caller's", "ours", ringHash, "theirs", requestPb.RingHash)
+		ctx = withNoCache(ctx)
+	}
+	return ctx, nil
+}
+
+// toStatus is the serving side of fromStatus
+func toStatus(err error) error {
+	if errors.Is(err, errGroupClosed) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// lookupGroup validates the parameters of a request as status errors.
+// A closed group isn't found.
+func (p *GRPCPool) lookupGroup(group string, key string) (*Group, error) {
+	if group == "" || key == "" {
+		return nil, status.Error(codes.InvalidArgument, "group name / key should be not null")
+	}
+	g, ok := p.cache.GetGroup(group)
+	if !ok || g.checkOpen() != nil {
+		return nil, status.Error(codes.NotFound, "group not found: "+group)
+	}
+	return g, nil
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ PeerLister = (*GRPCPool)(nil)
+var _ pb.GroupCacheServer = (*GRPCPool)(nil)
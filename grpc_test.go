@@ -0,0 +1,202 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufNetwork routes dials to in-process listeners by address
+type bufNetwork map[string]*bufconn.Listener
+
+func (bn bufNetwork) listen(addr string) *bufconn.Listener {
+	lis := bufconn.Listen(1 << 20)
+	bn[addr] = lis
+	return lis
+}
+
+func (bn bufNetwork) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			lis, ok := bn[addr]
+			if !ok {
+				return nil, errors.New("no listener at " + addr)
+			}
+			return lis.DialContext(ctx)
+		}),
+	}
+}
+
+func TestGRPCPool(t *testing.T) {
+	network := bufNetwork{}
+
+	count := 0
+	remoteGroup := newTestGroup(t, "grpcRemote", 100, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		count++
+		if key == "slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte(key), nil
+	}))
+	remotePool := NewGRPCPool("passthrough:///remote")
+	remoteGroup.RegisterPeers(remotePool)
+	server := remotePool.NewServer()
+	go server.Serve(network.listen("remote"))
+	defer server.Stop()
+
+	localPool := NewGRPCPool("passthrough:///local", WithDialOptions(network.dialOptions()...))
+	defer localPool.Close()
+	localGroup := newTestGroup(t, "grpcLocal", 100, nil)
+	localGroup.RegisterPeers(localPool)
+	if _, ok := localPool.PickPeer("114"); ok {
+		t.Error("should omit itself")
+	}
+
+	localPool.AddPeers("passthrough:///remote")
+	localPool.RemovePeers("passthrough:///local")
+
+	pGetter, ok := localPool.PickPeer("114")
+	if !ok {
+		t.Fatal("remote should be picked")
+	}
+	if len(localPool.AllPeers()) != 1 {
+		t.Error("expecting one peer")
+	}
+
+	ret, err := pGetter.Get("grpcRemote", "114")
+	if err != nil || string(ret) != "114" || count != 1 {
+		t.Errorf("can't get key properly from remote, ret,err,count are %v,%v,%v", string(ret), err, count)
+	}
+	pGetter.Get("grpcRemote", "114")
+	if count != 1 {
+		t.Error("remote should answer from cache")
+	}
+
+	if _, err := pGetter.Get("notExist", "114"); err == nil {
+		t.Error("should err for unknown group")
+	}
+
+	// deadline is carried to the remote getter
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pGetter.(ContextPeerGetter).GetContext(ctx, "grpcRemote", "slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+
+	// removal reaches the remote group
+	if err := pGetter.(PeerRemover).Remove(context.Background(), "grpcRemote", "114", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := remoteGroup.mainCache.get("114"); ok {
+		t.Error("key should be removed remotely")
+	}
+
+	// a batch is one call, the getter isn't a BatchGetter so keys are loaded one by one
+	values, err := pGetter.(BatchPeerGetter).GetMany(context.Background(), "grpcRemote", []string{"1", "2"})
+	if err != nil || len(values) != 2 || string(values["2"]) != "2" {
+		t.Errorf("unexpected batch ret/err %v/%v", values, err)
+	}
+
+	if err := localPool.RemovePeers("passthrough:///remote"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := localPool.PickPeer("114"); ok {
+		t.Error("no peer should be left")
+	}
+}
+
+func TestGRPCAddPeersKeepsCallerSlice(t *testing.T) {
+	p := NewGRPCPool("self:8000")
+	defer p.Close()
+	backing := []string{"a:8000", "b:8000", "untouched"}
+	if err := p.AddPeers(backing[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if backing[2] != "untouched" {
+		t.Errorf("AddPeers wrote to the caller's slice: %v", backing)
+	}
+}
+
+func TestGRPCRingChecks(t *testing.T) {
+	network := bufNetwork{}
+
+	// caches of their own, both sides have a group of the same name
+	remoteCache, localCache := NewCache(), NewCache()
+	remotePool, err := remoteCache.NewGRPCPool("passthrough:///remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotePool.AddPeers()
+	count := 0
+	remoteGroup := remoteCache.MustNewGroup("grpcRing", 100, GetterFunc(func(key string) ([]byte, error) {
+		count++
+		return []byte(key), nil
+	}))
+	server := remotePool.NewServer()
+	go server.Serve(network.listen("remote"))
+	defer server.Stop()
+
+	// the remote doesn't know about local, their rings differ
+	localPool, err := localCache.NewGRPCPool("passthrough:///local", WithDialOptions(network.dialOptions()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localPool.Close()
+	localPool.AddPeers("passthrough:///remote")
+	localGroup := localCache.MustNewGroup("grpcRing", 100, nil, WithHotCachePolicy(AlwaysPromote))
+	if localPool.RingHash() == remotePool.RingHash() {
+		t.Fatal("rings of different peers should differ")
+	}
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := localPool.PickPeer(fmt.Sprint(i)); ok {
+			key = fmt.Sprint(i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if ret, err := localGroup.Get(key); err != nil || ret.String() != key {
+			t.Fatalf("unexpected ret/err %v/%v", ret, err)
+		}
+	}
+	_, remoteCached := remoteGroup.mainCache.get(key)
+	_, localCached := localGroup.hotCache.get(key)
+	if count != 2 || remoteCached || localCached {
+		t.Errorf("neither side should cache while rings differ, got %d loads", count)
+	}
+
+	// callers whose rings are built differently are refused
+	requestPb := &pb.Request{
+		Type:   pb.Request_ISQUERY,
+		Body:   &pb.Request_Query_{Query: &pb.Request_Query{Group: "grpcRing", Key: key}},
+		Hasher: "xxhash64:0",
+	}
+	if _, err := remotePool.Get(context.Background(), requestPb); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("an incompatible caller should be refused, got %v", err)
+	}
+
+	// removals have an rpc of their own
+	requestPb = &pb.Request{
+		Type: pb.Request_ISREMOVE,
+		Body: &pb.Request_Remove_{Remove: &pb.Request_Remove{Group: "grpcRing", Key: key}},
+	}
+	if _, err := remotePool.Get(context.Background(), requestPb); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Get shouldn't remove, got %v", err)
+	}
+	if _, err := remotePool.Remove(context.Background(), requestPb); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package geecache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+)
+
+// PeerPinger is a PeerGetter that can tell whether its peer is alive
+type PeerPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PeerStateFunc is told whenever a peer is marked down (up is false)
+// or is restored (up is true). It's called from the health checker's
+// goroutine, so it shouldn't block for long.
+type PeerStateFunc func(peer string, up bool)
+
+// healthChecker probes peers every interval and marks a peer down
+// after downAfter consecutive failures. One successful probe restores it.
+// Pools skip peers that are down, so their keys go to the next node
+// on the ring, which is the pool itself if no other peer is left.
+type healthChecker struct {
+	interval  time.Duration
+	downAfter int
+	onChange  PeerStateFunc
+	logger    logger.Logger
+
+	mu       sync.Mutex
+	failures map[string]int
+	// down is replaced, never changed, so that isDown doesn't lock
+	down atomic.Pointer[map[string]bool]
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func newHealthChecker(interval time.Duration, downAfter int, onChange PeerStateFunc, l logger.Logger) *healthChecker {
+	if downAfter < 1 {
+		downAfter = 1
+	}
+	hc := &healthChecker{
+		interval:  interval,
+		downAfter: downAfter,
+		onChange:  onChange,
+		logger:    logger.OrNop(l),
+		failures:  make(map[string]int),
+		stop:      make(chan struct{}),
+	}
+	hc.down.Store(&map[string]bool{})
+	return hc
+}
+
+// start probes the peers returned by peers until Stop is called
+func (hc *healthChecker) start(peers func() map[string]PeerPinger) {
+	go func() {
+		ticker := time.NewTicker(hc.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hc.probe(peers())
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// probe pings all peers at once, each one is given an interval to answer
+func (hc *healthChecker) probe(peers map[string]PeerPinger) {
+	wg := sync.WaitGroup{}
+	for name, pinger := range peers {
+		wg.Add(1)
+		go func(name string, pinger PeerPinger) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hc.interval)
+			defer cancel()
+			hc.report(name, pinger.Ping(ctx))
+		}(name, pinger)
+	}
+	wg.Wait()
+}
+
+// report counts the result of a probe and fires onChange on transitions
+func (hc *healthChecker) report(peer string, err error) {
+	hc.mu.Lock()
+	down := *hc.down.Load()
+	changed := false
+	if err != nil {
+		hc.failures[peer]++
+		changed = hc.failures[peer] >= hc.downAfter && !down[peer]
+	} else {
+		delete(hc.failures, peer)
+		changed = down[peer]
+	}
+	if changed {
+		hc.setDown(peer, err != nil)
+	}
+	hc.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err != nil {
+		hc.logger.Warn("peer is down", "peer", peer, "err", err)
+	} else {
+		hc.logger.Info("peer is up", "peer", peer)
+	}
+	if hc.onChange != nil {
+		hc.onChange(peer, err == nil)
+	}
+}
+
+// setDown stores a new down set, it must be called with mu held
+func (hc *healthChecker) setDown(peer string, isDown bool) {
+	old := *hc.down.Load()
+	down := make(map[string]bool, len(old)+1)
+	for name := range old {
+		down[name] = true
+	}
+	if isDown {
+		down[peer] = true
+	} else {
+		delete(down, peer)
+	}
+	hc.down.Store(&down)
+}
+
+// isDown is nil-safe so that pools without health checking can call it
+func (hc *healthChecker) isDown(peer string) bool {
+	if hc == nil {
+		return false
+	}
+	return (*hc.down.Load())[peer]
+}
+
+// forget drops the state of a removed peer,
+// it starts as up if it's added back
+func (hc *healthChecker) forget(peer string) {
+	if hc == nil {
+		return
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	delete(hc.failures, peer)
+	if (*hc.down.Load())[peer] {
+		hc.setDown(peer, false)
+	}
+}
+
+// Stop can be called more than once
+func (hc *healthChecker) Stop() {
+	if hc == nil {
+		return
+	}
+	hc.once.Do(func() {
+		close(hc.stop)
+	})
+}
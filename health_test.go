@@ -0,0 +1,106 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type transition struct {
+	peer string
+	up   bool
+}
+
+func TestHealthCheckerReport(t *testing.T) {
+	got := make([]transition, 0)
+	hc := newHealthChecker(time.Second, 3, func(peer string, up bool) {
+		got = append(got, transition{peer, up})
+	}, nil)
+
+	fail := errors.New("no route to host")
+	hc.report("a", fail)
+	hc.report("a", fail)
+	if hc.isDown("a") {
+		t.Error("a shouldn't be down before 3 failures")
+	}
+	hc.report("a", nil)
+	hc.report("a", fail)
+	hc.report("a", fail)
+	if hc.isDown("a") {
+		t.Error("a success should reset the failures")
+	}
+	hc.report("a", fail)
+	hc.report("a", fail)
+	if !hc.isDown("a") {
+		t.Error("a should be down after 3 failures in a row")
+	}
+	hc.report("a", nil)
+	if hc.isDown("a") {
+		t.Error("a should be restored")
+	}
+
+	want := []transition{{"a", false}, {"a", true}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expecting transitions %v, got %v", want, got)
+	}
+
+	var nilChecker *healthChecker
+	if nilChecker.isDown("a") {
+		t.Error("nothing is down without a health checker")
+	}
+}
+
+func TestHTTPPoolFailover(t *testing.T) {
+	alive := int32(1)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&alive) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer remote.Close()
+	remoteURL := remote.URL + defaultBasePath
+
+	transitions := make(chan transition, 10)
+	p := NewHTTPPool(19634,
+		WithHealthCheck(10*time.Millisecond, 2),
+		WithPeerStateFunc(func(peer string, up bool) {
+			transitions <- transition{peer, up}
+		}))
+	defer p.Close()
+	p.AddPeers(remoteURL)
+
+	remoteKey := ""
+	for i := 0; remoteKey == ""; i++ {
+		if _, ok := p.PickPeer(fmt.Sprint(i)); ok {
+			remoteKey = fmt.Sprint(i)
+		}
+	}
+
+	expect := func(want transition) {
+		t.Helper()
+		select {
+		case got := <-transitions:
+			if got != want {
+				t.Fatalf("expecting %v, got %v", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+
+	atomic.StoreInt32(&alive, 0)
+	expect(transition{remoteURL, false})
+	if _, ok := p.PickPeer(remoteKey); ok {
+		t.Error("keys of a peer that is down should be loaded locally")
+	}
+
+	atomic.StoreInt32(&alive, 1)
+	expect(transition{remoteURL, true})
+	if pGetter, ok := p.PickPeer(remoteKey); !ok || peerName(pGetter) != remoteURL {
+		t.Error("the peer should own its keys again")
+	}
+}
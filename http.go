@@ -2,18 +2,24 @@ package geecache
 
 import (
 	"bytes"
+	"context"
+	"encoding"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
 	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+	"github.com/Hawk-Zhou/better-groupcache/placement"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -23,26 +29,81 @@ type manageOp int
 
 const defaultBasePath = "/geecache/"
 
+// timeoutHeader carries the caller's remaining time budget in milliseconds
+// so that the serving peer gives up no later than the caller does
+const timeoutHeader = "X-Geecache-Timeout"
+
+// ringHeader carries the ring fingerprint of the serving peer in responses,
+// callers tell theirs in Request.ring_hash
+const ringHeader = "X-Geecache-Ring"
+
+// hasherHeader carries the hasher of the serving peer in responses,
+// callers tell theirs in Request.hasher
+const hasherHeader = "X-Geecache-Hasher"
+
+// ringParamsHeader carries the ring params of the serving peer in responses,
+// callers tell theirs in Request.ring_params
+const ringParamsHeader = "X-Geecache-Ring-Params"
+
 const (
 	manage_PURGE = 0
 	manage_ADD   = 1
+	manage_SET   = 2
+	manage_RING  = 3
 )
 
-// sharedClient is http.Client that has timeout set properly
-var sharedClient = &http.Client{
-	Timeout: time.Millisecond * 200,
+// defaultPeerTimeout bounds requests to peers made without a deadline
+const defaultPeerTimeout = time.Millisecond * 200
+
+// sharedClient has no timeout of its own, requests are bounded by their
+// context so that peers are told the budget we really wait for,
+// see withPeerTimeout
+var sharedClient = &http.Client{}
+
+// manageClient sends manage requests, which aren't made with a context
+var manageClient = &http.Client{
+	Timeout: defaultPeerTimeout,
+}
+
+// withPeerTimeout gives ctx the default deadline if it has none
+func withPeerTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, defaultPeerTimeout)
 }
 
 type HTTPGetter struct {
 	baseURL string // "http://0.0.0.0:8000/geecache/"
+	metrics *peerMetrics
+	// ringHash tells the fingerprint of the ring of the pool, nil if unknown
+	ringHash func() uint64
+	// hasher and ringParams tell how the ring of the pool is built,
+	// nil if unknown
+	hasher     func() string
+	ringParams func() string
 }
 
 func (hg *HTTPGetter) Get(group string, key string) ([]byte, error) {
+	return hg.GetContext(context.Background(), group, key)
+}
+
+// GetContext sends the query with ctx attached to the outgoing request.
+// The deadline of ctx, or defaultPeerTimeout if it has none, is also told
+// to the peer.
+func (hg *HTTPGetter) GetContext(ctx context.Context, group string, key string) (ret []byte, err error) {
+	start := time.Now()
+	hg.metrics.begin()
+	defer func() {
+		hg.metrics.record(start, err)
+	}()
 
 	requestPb := &pb.Request{}
 	requestPb.Type = pb.Request_ISQUERY
 	queryPb := &pb.Request_Query{Group: group, Key: key}
 	requestPb.Body = &pb.Request_Query_{Query: queryPb}
+	requestPb.RingHash = hg.ring()
+	hg.stamp(requestPb)
 
 	// url := fmt.Sprintf(hg.baseURL+"%v/%v", group, key)
 
@@ -52,9 +113,18 @@ func (hg *HTTPGetter) Get(group string, key string) ([]byte, error) {
 		return nil, fmt.Errorf("http.Get can't marshal: %w", err)
 	}
 
-	resp, err := sharedClient.Post(hg.baseURL,
-		"application/octet-stream",
-		bytes.NewReader(marshalledReq))
+	ctx, cancel := withPeerTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hg.baseURL, bytes.NewReader(marshalledReq))
+	if err != nil {
+		return nil, fmt.Errorf("http.Get can't build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, fmt.Sprint(time.Until(deadline).Milliseconds()))
+	}
+
+	resp, err := sharedClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -68,31 +138,337 @@ func (hg *HTTPGetter) Get(group string, key string) ([]byte, error) {
 				resp.StatusCode,
 				err)
 		}
+		// the peer runs out of the budget we told it slightly before we do
+		if resp.StatusCode == http.StatusGatewayTimeout {
+			return nil, fmt.Errorf("%s: %w", resp.Status, context.DeadlineExceeded)
+		}
 		return nil, errors.New(resp.Status + ": " + string(body))
 	}
+	if err := hg.checkCompatible(resp.Header); err != nil {
+		return nil, err
+	}
+	hg.checkRing(ctx, resp.Header)
 
 	return body, nil
 }
 
+func (hg *HTTPGetter) ring() uint64 {
+	if hg.ringHash == nil {
+		return 0
+	}
+	return hg.ringHash()
+}
+
+// stamp tells the peer how our ring is built
+func (hg *HTTPGetter) stamp(requestPb *pb.Request) {
+	if hg.hasher != nil {
+		requestPb.Hasher = hg.hasher()
+	}
+	if hg.ringParams != nil {
+		requestPb.RingParams = hg.ringParams()
+	}
+}
+
+// checkCompatible refuses the answer of a peer whose ring is built
+// differently, eg. by another hasher. It puts keys on other owners
+// however alike the membership is.
+func (hg *HTTPGetter) checkCompatible(header http.Header) error {
+	requestPb := &pb.Request{}
+	hg.stamp(requestPb)
+	if reason := incompatible(requestPb.Hasher, requestPb.RingParams,
+		header.Get(hasherHeader), header.Get(ringParamsHeader)); reason != "" {
+		return fmt.Errorf("peer %s is incompatible: %s", hg.baseURL, reason)
+	}
+	return nil
+}
+
+// incompatible tells why rings of two peers can't agree on owners,
+// "" if they can. Unknown hashers or params, "", are taken as compatible,
+// so are params of rings that consistentHash.Params.Compatible accepts.
+func incompatible(ourHasher, ourParams, theirHasher, theirParams string) string {
+	if ourHasher != "" && theirHasher != "" && ourHasher != theirHasher {
+		return fmt.Sprintf("hasher mismatch: ours %s, theirs %s", ourHasher, theirHasher)
+	}
+	if ourParams != "" && theirParams != "" && ourParams != theirParams {
+		ours, ourErr := consistentHash.ParseParams(ourParams)
+		theirs, theirErr := consistentHash.ParseParams(theirParams)
+		// params of other placements are only compared as strings
+		if ourErr != nil || theirErr != nil || !ours.Compatible(theirs) {
+			return fmt.Sprintf("ring params mismatch: ours %s, theirs %s", ourParams, theirParams)
+		}
+	}
+	return ""
+}
+
+// checkRing tells the load of ctx not to cache the value
+// if the peer answered with a ring different from ours,
+// since one of us may be wrong about who owns the key
+func (hg *HTTPGetter) checkRing(ctx context.Context, header http.Header) {
+	theirs, err := strconv.ParseUint(header.Get(ringHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	if ours := hg.ring(); ours != 0 && theirs != ours {
+		markNoCache(ctx)
+	}
+}
+
+// Remove asks the peer to delete the key, from hotCache only if hotOnly
+func (hg *HTTPGetter) Remove(ctx context.Context, group string, key string, hotOnly bool) error {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISREMOVE
+	removePb := &pb.Request_Remove{Group: group, Key: key, HotOnly: hotOnly}
+	requestPb.Body = &pb.Request_Remove_{Remove: removePb}
+	hg.stamp(requestPb)
+
+	_, header, err := postRequest(ctx, hg.baseURL, requestPb)
+	if err != nil {
+		return err
+	}
+	return hg.checkCompatible(header)
+}
+
+// GetMany sends one BatchQuery for all keys
+func (hg *HTTPGetter) GetMany(ctx context.Context, group string, keys []string) (ret map[string][]byte, err error) {
+	start := time.Now()
+	hg.metrics.begin()
+	defer func() {
+		// failures of single keys aren't failures of the request
+		if _, partial := err.(KeyErrors); partial {
+			hg.metrics.record(start, nil)
+			return
+		}
+		hg.metrics.record(start, err)
+	}()
+
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISBATCH
+	batchPb := &pb.Request_BatchQuery{Group: group, Keys: keys}
+	requestPb.Body = &pb.Request_Batch{Batch: batchPb}
+	requestPb.RingHash = hg.ring()
+	hg.stamp(requestPb)
+
+	body, header, err := postRequest(ctx, hg.baseURL, requestPb)
+	if err != nil {
+		return nil, err
+	}
+	if err := hg.checkCompatible(header); err != nil {
+		return nil, err
+	}
+	hg.checkRing(ctx, header)
+	respPb := &pb.BatchResponse{}
+	if err = proto.Unmarshal(body, respPb); err != nil {
+		return nil, fmt.Errorf("can't unmarshal batch response: %w", err)
+	}
+	return fromBatchResponse(respPb)
+}
+
+// postRequest sends requestPb to url and returns the body and header of a 200 response.
+// The deadline of ctx is told to the peer as GetContext does.
+func postRequest(ctx context.Context, url string, requestPb *pb.Request) ([]byte, http.Header, error) {
+	marshalledReq, err := proto.Marshal(requestPb)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't marshal %v request: %w", requestPb.Type, err)
+	}
+
+	ctx, cancel := withPeerTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(marshalledReq))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't build %v request: %w", requestPb.Type, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, fmt.Sprint(time.Until(deadline).Milliseconds()))
+	}
+
+	resp, err := sharedClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	// otherwise memory will leak
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if err != nil {
+			return nil, nil, fmt.Errorf("another error happened when handling statusCode(%v) from response:%w",
+				resp.StatusCode,
+				err)
+		}
+		if resp.StatusCode == http.StatusGatewayTimeout {
+			return nil, nil, fmt.Errorf("%s: %w", resp.Status, context.DeadlineExceeded)
+		}
+		return nil, nil, errors.New(resp.Status + ": " + string(body))
+	}
+	return body, resp.Header, err
+}
+
+// Ping asks the peer whether it's alive.
+// A peer that hashes keys differently fails it, so it's marked down.
+func (hg *HTTPGetter) Ping(ctx context.Context) error {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISPING
+	hg.stamp(requestPb)
+
+	_, header, err := postRequest(ctx, hg.baseURL, requestPb)
+	if err != nil {
+		return err
+	}
+	return hg.checkCompatible(header)
+}
+
+// String is the URL of the peer
+func (hg *HTTPGetter) String() string {
+	return hg.baseURL
+}
+
 // trick to validate a struct implements an interface properly
 var _ PeerGetter = (*HTTPGetter)(nil)
+var _ ContextPeerGetter = (*HTTPGetter)(nil)
+var _ PeerRemover = (*HTTPGetter)(nil)
+var _ BatchPeerGetter = (*HTTPGetter)(nil)
+var _ PeerPinger = (*HTTPGetter)(nil)
 
-type HTTPPool struct {
-	host        string // "ip:port"
-	basePath    string // "/pathname/"
-	mu          sync.Mutex
-	peers       *consistentHash.CHash
+// ringSnapshot is what the peers of an HTTPPool are at some point.
+// It's never changed once stored, membership changes store a new one,
+// so that lookups read it without locking.
+type ringSnapshot struct {
+	peers       placement.Placement
 	httpGetters map[string]*HTTPGetter
+	hash        uint64 // fingerprint of peers
+}
+
+func newRingSnapshot(peers placement.Placement, httpGetters map[string]*HTTPGetter) *ringSnapshot {
+	return &ringSnapshot{peers: peers, httpGetters: httpGetters, hash: peers.Fingerprint()}
+}
+
+type HTTPPool struct {
+	host     string // "ip:port"
+	basePath string // "/pathname/"
+	// mu serializes membership changes, readers load ring instead
+	mu   sync.Mutex
+	ring atomic.Pointer[ringSnapshot]
+	// peerMetrics outlives the peer so that counters don't reset
+	// when a peer is removed and added back
+	peerMetrics map[string]*peerMetrics
+	logger      logger.Logger
+
+	// health is nil unless WithHealthCheck is given
+	health          *healthChecker
+	healthInterval  time.Duration
+	healthDownAfter int
+	onPeerState     PeerStateFunc
+
+	// newPlacement makes the empty rings peers are put on, consistent hash
+	// rings of hasher and ringOpts unless WithPlacement is given
+	newPlacement func() placement.Placement
+	hasher       string
+	ringOpts     []consistentHash.Option
+	// err tells why the options are invalid, see Validate
+	err error
+
+	// boundedLoad turns on bounded loads of the ring, see WithBoundedLoad
+	boundedLoad bool
+	loadEpsilon float64
+	// inFlight counts requests sent to peers and being served by the pool
+	inFlight AtomicInt
+	serving  AtomicInt
+
+	// cache holds the groups the pool serves, DefaultCache unless it's
+	// made by Cache.NewHTTPPool
+	cache *Cache
+
+	// peerLabels tell where peers are, the pool itself included,
+	// see WithLabels and SetPeerLabels. Peers get theirs when they join.
+	peerLabels map[string]consistentHash.Labels
+}
+
+// inFlightLoads tells the ring how loaded the nodes are as the pool sees it,
+// by the requests sent to a peer that are still in flight.
+// The load of the pool itself is the requests it's serving.
+type inFlightLoads struct {
+	p *HTTPPool
+}
+
+func (l inFlightLoads) Load(name string) int64 {
+	if name == "http://"+l.p.host+l.p.basePath {
+		return l.p.serving.Get()
+	}
+	if getter, ok := l.p.ring.Load().httpGetters[name]; ok {
+		return getter.metrics.inFlight.Get()
+	}
+	return 0
+}
+
+func (l inFlightLoads) TotalLoad() int64 {
+	return l.p.inFlight.Get()
+}
+
+// beginServing counts a request as the load of the pool until done is called
+func (p *HTTPPool) beginServing() (done func()) {
+	p.serving.Add(1)
+	p.inFlight.Add(1)
+	return func() {
+		p.serving.Add(-1)
+		p.inFlight.Add(-1)
+	}
+}
+
+// newRing returns an empty placement with bounded loads on if the pool has it
+func (p *HTTPPool) newRing() placement.Placement {
+	ring := p.newPlacement()
+	if p.boundedLoad {
+		ring.SetBoundedLoad(inFlightLoads{p}, p.loadEpsilon)
+	}
+	return ring
 }
 
 // NewHTTPPool should be initialized with AddPeers
-func NewHTTPPool(port int) *HTTPPool {
-	return &HTTPPool{
+func NewHTTPPool(port int, opts ...PoolOption) *HTTPPool {
+	p := &HTTPPool{
 		host:        "0.0.0.0:" + fmt.Sprint(port),
 		basePath:    defaultBasePath,
-		peers:       consistentHash.NewCHash(nil),
-		httpGetters: make(map[string]*HTTPGetter),
+		peerMetrics: make(map[string]*peerMetrics),
+		logger:      logger.Nop,
+		hasher:      consistentHash.CRC32,
+		cache:       DefaultCache,
+		peerLabels:  make(map[string]consistentHash.Labels),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.newPlacement == nil {
+		newRing, err := placement.RingByName(p.hasher, p.ringOpts...)
+		if err != nil {
+			p.err = fmt.Errorf("WithHasher: %w", err)
+			newRing, _ = placement.RingByName(consistentHash.CRC32, p.ringOpts...)
+		}
+		p.newPlacement = newRing
+	}
+	p.logger = logger.With(p.logger, "pool", p.host)
+	if p.err != nil {
+		p.logger.Error("invalid pool options, falling back to the defaults", "err", p.err)
+	}
+	p.ring.Store(newRingSnapshot(p.newRing(), make(map[string]*HTTPGetter)))
+	if p.healthInterval > 0 {
+		p.health = newHealthChecker(p.healthInterval, p.healthDownAfter, p.onPeerState, p.logger)
+		p.health.start(p.pingers)
 	}
+	return p
+}
+
+// Validate tells whether the options the pool is made with are valid,
+// eg. WithHasher of a hasher that exists. A pool of invalid options
+// works with the defaults in their place, its peers may refuse it.
+func (p *HTTPPool) Validate() error {
+	return p.err
+}
+
+// Close stops the health checker, if any
+func (p *HTTPPool) Close() error {
+	p.health.Stop()
+	return nil
 }
 
 // signal a remote peer to remove its peers
@@ -111,7 +487,7 @@ func (p *HTTPPool) RemovePeerRemote(remoteURL string, peers ...string) error {
 		return fmt.Errorf("http.removePeerRemote can't marshal: %w", err)
 	}
 
-	resp, err := sharedClient.Post(remoteURL,
+	resp, err := manageClient.Post(remoteURL,
 		"application/octet-stream",
 		bytes.NewReader(marshalledReq))
 	if err != nil {
@@ -138,9 +514,22 @@ func (p *HTTPPool) AddPeerRemote(remoteURL string, peers ...string) error {
 	if len(peers) == 0 {
 		return errors.New("no peer to add, check the parameter")
 	}
+	managePb := &pb.Request_Manage{Op: pb.Request_Manage_ADD, Node: peers}
+	return p.addPeerRemote(remoteURL, managePb)
+}
+
+// signal a remote peer to add peers of the given weights,
+// or to re-weight them if they exist
+func (p *HTTPPool) AddPeersWeightedRemote(remoteURL string, weights map[string]int) error {
+	if len(weights) == 0 {
+		return errors.New("no peer to add, check the parameter")
+	}
+	return p.addPeerRemote(remoteURL, toManagePb(pb.Request_Manage_ADD, weights))
+}
+
+func (p *HTTPPool) addPeerRemote(remoteURL string, managePb *pb.Request_Manage) error {
 	requestPb := &pb.Request{}
 	requestPb.Type = pb.Request_ISMANAGE
-	managePb := &pb.Request_Manage{Op: pb.Request_Manage_ADD, Node: peers}
 	requestPb.Body = &pb.Request_Manage_{Manage: managePb}
 
 	marshalledReq, err := proto.Marshal(requestPb)
@@ -149,7 +538,7 @@ func (p *HTTPPool) AddPeerRemote(remoteURL string, peers ...string) error {
 		return fmt.Errorf("http.addPeerRemote can't marshal: %w", err)
 	}
 
-	resp, err := sharedClient.Post(remoteURL,
+	resp, err := manageClient.Post(remoteURL,
 		"application/octet-stream",
 		bytes.NewReader(marshalledReq))
 	if err != nil {
@@ -171,6 +560,50 @@ func (p *HTTPPool) AddPeerRemote(remoteURL string, peers ...string) error {
 	return nil
 }
 
+// signal a remote peer to replace all its peers at once,
+// the remote peer adds itself to peers
+func (p *HTTPPool) SetPeersRemote(remoteURL string, peers ...string) error {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISMANAGE
+	managePb := &pb.Request_Manage{Op: pb.Request_Manage_SET, Node: peers}
+	requestPb.Body = &pb.Request_Manage_{Manage: managePb}
+
+	_, _, err := postRequest(context.Background(), remoteURL, requestPb)
+	return err
+}
+
+// signal a remote peer to replace all its peers at once with peers of
+// the given weights, the remote peer adds itself of weight 1 to peers
+// unless it's given
+func (p *HTTPPool) SetPeersWeightedRemote(remoteURL string, weights map[string]int) error {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISMANAGE
+	requestPb.Body = &pb.Request_Manage_{Manage: toManagePb(pb.Request_Manage_SET, weights)}
+
+	_, _, err := postRequest(context.Background(), remoteURL, requestPb)
+	return err
+}
+
+// FetchRingRemote asks the pool at remoteURL for its ring snapshot,
+// see RingSnapshot and RestoreRing
+func (p *HTTPPool) FetchRingRemote(remoteURL string) ([]byte, error) {
+	requestPb := &pb.Request{}
+	requestPb.Type = pb.Request_ISMANAGE
+	requestPb.Body = &pb.Request_Manage_{Manage: &pb.Request_Manage{Op: pb.Request_Manage_RING}}
+
+	body, _, err := postRequest(context.Background(), remoteURL, requestPb)
+	return body, err
+}
+
+func toManagePb(op pb.Request_Manage_OpType, weights map[string]int) *pb.Request_Manage {
+	managePb := &pb.Request_Manage{Op: op, Node: sortedPeers(weights)}
+	managePb.Weight = make([]uint32, 0, len(managePb.Node))
+	for _, peer := range managePb.Node {
+		managePb.Weight = append(managePb.Weight, uint32(weights[peer]))
+	}
+	return managePb
+}
+
 func (p *HTTPPool) answerQuery(group string, key string, w http.ResponseWriter, r *http.Request) {
 
 	if group == "" || key == "" {
@@ -179,13 +612,26 @@ func (p *HTTPPool) answerQuery(group string, key string, w http.ResponseWriter,
 		return
 	}
 
-	g, ok := GetGroup(group)
+	g, ok := p.lookupGroup(group, w)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("group name doesn't exist\n"))
 		return
 	}
-	ret, err := g.Get(key)
+	g.stats.serverRequests.Add(1)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	ret, err := g.GetContext(withFromPeer(ctx), key)
+	if errors.Is(err, errGroupClosed) {
+		// closed after it was looked up
+		writeGroupNotFound(group, w)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error() + "\n"))
@@ -195,10 +641,98 @@ func (p *HTTPPool) answerQuery(group string, key string, w http.ResponseWriter,
 	w.Write(ret.Get())
 }
 
+// lookupGroup finds the group of a request, a closed group isn't found.
+// It writes 404 if not found.
+func (p *HTTPPool) lookupGroup(group string, w http.ResponseWriter) (*Group, bool) {
+	g, ok := p.cache.GetGroup(group)
+	if !ok || g.checkOpen() != nil {
+		writeGroupNotFound(group, w)
+		return nil, false
+	}
+	return g, true
+}
+
+func writeGroupNotFound(group string, w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("group not found: " + group + "\n"))
+}
+
+// answerRemove drops the key from the caches of the group
+func (p *HTTPPool) answerRemove(group string, key string, hotOnly bool, w http.ResponseWriter, r *http.Request) {
+	if group == "" || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("group name / key should be not null\n"))
+		return
+	}
+
+	g, ok := p.lookupGroup(group, w)
+	if !ok {
+		return
+	}
+	g.removeLocally(key, hotOnly)
+	w.WriteHeader(http.StatusOK)
+}
+
+// answerBatch gets all keys of the batch and writes a BatchResponse.
+// Keys that fail are reported in their entries, the status is still 200.
+func (p *HTTPPool) answerBatch(group string, keys []string, w http.ResponseWriter, r *http.Request) {
+	if group == "" || len(keys) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("group name / keys should be not null\n"))
+		return
+	}
+
+	g, ok := p.lookupGroup(group, w)
+	if !ok {
+		return
+	}
+	g.stats.serverRequests.Add(1)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	ret, err := g.GetMany(withFromPeer(ctx), keys)
+	if errors.Is(err, errGroupClosed) {
+		writeGroupNotFound(group, w)
+		return
+	}
+	if _, partial := err.(KeyErrors); err != nil && !partial {
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	body, err := proto.Marshal(toBatchResponse(keys, ret, err))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// requestContext derives the context of a query from the request.
+// It's cancelled when the caller goes away, and also bounded by
+// the time budget the caller put in timeoutHeader.
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := r.Context()
+	ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64)
+	if err != nil {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+}
+
 // answerManage add/delete peers on request
 // It returns 200 if all removal are successful
 // If any fails, it returns InternalSeverError and a list of nodes failed to modify in the body
-func (p *HTTPPool) answerManage(op manageOp, peers []string, w http.ResponseWriter, r *http.Request) {
+// weights are ignored by PURGE, peers are of weight 1 if it's empty
+func (p *HTTPPool) answerManage(op manageOp, peers []string, weights []uint32, w http.ResponseWriter, r *http.Request) {
 	var (
 		total       = len(peers)
 		success     = 0
@@ -207,17 +741,57 @@ func (p *HTTPPool) answerManage(op manageOp, peers []string, w http.ResponseWrit
 		err         error
 	)
 
-	var opFunc func(...string) error
+	if len(weights) != 0 && len(weights) != total {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("got %d weights for %d nodes\n", len(weights), total)))
+		return
+	}
+	weightOf := func(i int) int {
+		if len(weights) == 0 {
+			return 1
+		}
+		return int(weights[i])
+	}
+
+	var opFunc func(int) error
 
 	switch op {
+	case manage_RING:
+		snapshot, err := p.RingSnapshot()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		w.Write(snapshot)
+		return
 	case manage_PURGE:
-		opFunc = p.RemovePeers
+		opFunc = func(i int) error { return p.RemovePeers(peers[i]) }
 	case manage_ADD:
-		opFunc = p.AddPeers
+		opFunc = func(i int) error {
+			if len(weights) == 0 {
+				// existing peers keep their weights
+				return p.AddPeers(peers[i])
+			}
+			return p.AddPeersWeighted(map[string]int{peers[i]: weightOf(i)})
+		}
+	case manage_SET:
+		weighted := make(map[string]int, total)
+		for i, peer := range peers {
+			weighted[peer] = weightOf(i)
+		}
+		// all or nothing, so there's no partial success to report
+		if err := p.SetPeersWeighted(weighted); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("0/%d nodes set: %v\n", total, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
-	for _, peer := range peers {
-		err = opFunc(peer)
+	for i, peer := range peers {
+		err = opFunc(i)
 		if err != nil {
 			failedtoMod = append(failedtoMod, peer+":"+err.Error())
 			fail++
@@ -249,16 +823,35 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	reqBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[http.ServeHTTP] unexpected error: %v\n", err)
+		p.logger.Error("can't read request body", "err", err)
 		return
 	}
 	requestPb := &pb.Request{}
 	err = proto.Unmarshal(reqBytes, requestPb)
 	if err != nil {
-		log.Printf("[http.ServeHTTP] can't unmarshal: %v\n", err)
+		p.logger.Error("can't unmarshal request", "err", err)
 		return
 	}
 
+	hasher, params := p.Hasher(), p.RingParams()
+	w.Header().Set(hasherHeader, hasher)
+	w.Header().Set(ringParamsHeader, params)
+	if reason := incompatible(hasher, params, requestPb.Hasher, requestPb.RingParams); reason != "" {
+		// we'd never agree on who owns a key
+		p.logger.Warn("refusing an incompatible peer", "reason", reason)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(reason + "\n"))
+		return
+	}
+
+	ringHash := p.RingHash()
+	w.Header().Set(ringHeader, strconv.FormatUint(ringHash, 10))
+	if requestPb.RingHash != 0 && requestPb.RingHash != ringHash {
+		// the caller may think we own keys we don't
+		p.logger.Debug("ring differs from the caller's", "ours", ringHash, "theirs", requestPb.RingHash)
+		r = r.WithContext(withNoCache(r.Context()))
+	}
+
 	reqTypePb := requestPb.GetType()
 	if reqTypePb == pb.Request_ISQUERY {
 		query := requestPb.GetQuery()
@@ -267,7 +860,8 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(fmt.Sprintf("bad request.query (got nil after unmarshal): %v \n", path)))
 			return
 		}
-		log.Printf("got query %+v,%+v\n", query.Group, query.Key)
+		p.logger.Debug("got query", "group", query.Group, "key", query.Key)
+		defer p.beginServing()()
 		p.answerQuery(query.Group, query.Key, w, r)
 		return
 	}
@@ -285,8 +879,42 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			op = manage_PURGE
 		case pb.Request_Manage_ADD:
 			op = manage_ADD
+		case pb.Request_Manage_SET:
+			op = manage_SET
+		case pb.Request_Manage_RING:
+			op = manage_RING
+		}
+		p.answerManage(op, manage.Node, manage.Weight, w, r)
+		return
+	}
+
+	if reqTypePb == pb.Request_ISREMOVE {
+		remove := requestPb.GetRemove()
+		if remove == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("bad request.remove (got nil after unmarshal): %v \n", path)))
+			return
+		}
+		p.logger.Debug("got remove", "group", remove.Group, "key", remove.Key, "hotOnly", remove.HotOnly)
+		p.answerRemove(remove.Group, remove.Key, remove.HotOnly, w, r)
+		return
+	}
+
+	if reqTypePb == pb.Request_ISBATCH {
+		batch := requestPb.GetBatch()
+		if batch == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("bad request.batch (got nil after unmarshal): %v \n", path)))
+			return
 		}
-		p.answerManage(op, manage.Node, w, r)
+		p.logger.Debug("got batch", "group", batch.Group, "keys", len(batch.Keys))
+		defer p.beginServing()()
+		p.answerBatch(batch.Group, batch.Keys, w, r)
+		return
+	}
+
+	if reqTypePb == pb.Request_ISPING {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 }
@@ -312,66 +940,379 @@ func newHttpServer(addr string, handler http.Handler) *http.Server {
 //  "http://0.0.0.0:8000/geecache/"
 // * also register itself automatically
 // * idempotent operation (ignores duplicated add)
+// * the pool is left as it was if it fails
+// * new peers are of weight 1, see AddPeersWeighted
 func (p *HTTPPool) AddPeers(peers ...string) error {
+	return p.addPeers(peers, nil)
+}
+
+// AddPeersWeighted is AddPeers with the weight of each peer,
+// a peer of weight w owns about w times the keys one of weight 1 owns.
+// Peers are added in sorted order.
+// * a peer that exists with another weight is given the new weight
+func (p *HTTPPool) AddPeersWeighted(weights map[string]int) error {
+	return p.addPeers(sortedPeers(weights), weights)
+}
+
+// addPeers adds peers with their weights. Peers missing from weights
+// keep their weight if they exist, otherwise they are of weight 1.
+func (p *HTTPPool) addPeers(peers []string, weights map[string]int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	peers = append(peers, "http://"+p.host+p.basePath)
+	old := p.ring.Load()
+	ring := old.peers.Clone()
+	getters := make(map[string]*HTTPGetter, len(old.httpGetters)+len(peers)+1)
+	for peer, getter := range old.httpGetters {
+		getters[peer] = getter
+	}
+
+	// copied so that the caller's slice isn't written to
+	peers = append(append(make([]string, 0, len(peers)+1), peers...), "http://"+p.host+p.basePath)
 
 	for _, peer := range peers {
-		if _, ok := p.peers.NameToSalt[peer]; ok {
-			continue
+		weight, weighted := weights[peer]
+		if current := ring.Weight(peer); current != 0 {
+			if !weighted || weight == current {
+				continue
+			}
+			// re-weighted
+			if err := ring.RemoveNode(peer); err != nil {
+				return fmt.Errorf("can't re-weight the peer %s: %w", peer, err)
+			}
+		}
+		if !weighted {
+			weight = 1
 		}
 
-		err := p.peers.AddNode(peer)
+		err := ring.AddNode(peer, weight)
 
 		if err != nil {
 			return fmt.Errorf("can't add the peer %s: %w", peer, err)
 		}
+		if labels, ok := p.peerLabels[peer]; ok {
+			ring.SetLabels(peer, labels)
+		}
+
+		if _, ok := getters[peer]; !ok {
+			getters[peer] = p.newGetter(peer)
+		}
+	}
+
+	p.ring.Store(newRingSnapshot(ring, getters))
+	return nil
+}
+
+// SetPeers replaces all peers at once, in the format of AddPeers.
+// The new ring is fully built before it's swapped in, so the pool is
+// left as it was if it fails. Peers are added in sorted order, so that
+// pools given the same peers build the same ring even on salt collisions.
+// * also register itself automatically
+// * all peers are of weight 1, see SetPeersWeighted
+func (p *HTTPPool) SetPeers(peers ...string) error {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	return p.SetPeersWeighted(weights)
+}
+
+// SetPeersWeighted is SetPeers with the weight of each peer.
+// The pool itself is of weight 1 unless it's given in weights.
+func (p *HTTPPool) SetPeersWeighted(weights map[string]int) error {
+	self := "http://" + p.host + p.basePath
+	peers := sortedPeers(weights)
+	if _, ok := weights[self]; !ok {
+		peers = append(peers, self)
+		sort.Strings(peers)
+	}
+
+	ring := p.newRing()
+	for _, peer := range peers {
+		weight, ok := weights[peer]
+		if !ok {
+			weight = 1
+		}
+		if err := ring.AddNode(peer, weight); err != nil {
+			return fmt.Errorf("can't add the peer %s: %w", peer, err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-		p.httpGetters[peer] = &HTTPGetter{
-			baseURL: peer,
+	for peer, labels := range p.peerLabels {
+		if ring.Weight(peer) != 0 {
+			ring.SetLabels(peer, labels)
 		}
 	}
+	p.swapRing(ring)
+	return nil
+}
+
+// RingSnapshot encodes the ring of the pool, see
+// consistentHash.CHash.MarshalBinary. It fails unless the placement is a
+// consistent hash ring, or another that is an encoding.BinaryMarshaler.
+func (p *HTTPPool) RingSnapshot() ([]byte, error) {
+	marshaler, ok := p.ring.Load().peers.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.New("the placement of the pool can't be snapshotted")
+	}
+	return marshaler.MarshalBinary()
+}
 
+// RestoreRing replaces the ring of the pool by a snapshot of RingSnapshot,
+// eg. one it took before restarting, or one of FetchRingRemote, so that
+// it routes exactly as the ring it was taken of. The snapshot is taken
+// as is, whether the pool is on it or not.
+// * the ring must be built the same way as the pool builds its own, see RingParams
+// * the pool is left as it was if it fails
+func (p *HTTPPool) RestoreRing(snapshot []byte) error {
+	ring := p.newRing()
+	unmarshaler, ok := ring.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.New("the placement of the pool can't be restored")
+	}
+	if err := unmarshaler.UnmarshalBinary(snapshot); err != nil {
+		return err
+	}
+	if ours := p.newRing().Params(); ring.Params() != ours {
+		return fmt.Errorf("can't restore a ring of %s, the pool builds %s", ring.Params(), ours)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.swapRing(ring)
 	return nil
 }
 
+// swapRing stores ring with the getters of its peers, reusing the ones
+// the pool has. It must be called with mu held.
+func (p *HTTPPool) swapRing(ring placement.Placement) {
+	old := p.ring.Load()
+	getters := make(map[string]*HTTPGetter, ring.Len())
+	for _, peer := range ring.Nodes() {
+		if getter, ok := old.httpGetters[peer]; ok {
+			getters[peer] = getter
+			continue
+		}
+		getters[peer] = p.newGetter(peer)
+	}
+	for peer := range old.httpGetters {
+		if _, ok := getters[peer]; !ok {
+			p.health.forget(peer)
+		}
+	}
+
+	p.ring.Store(newRingSnapshot(ring, getters))
+}
+
+func sortedPeers(weights map[string]int) []string {
+	peers := make([]string, 0, len(weights)+1)
+	for peer := range weights {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// newGetter must be called with mu held
+func (p *HTTPPool) newGetter(peer string) *HTTPGetter {
+	if _, ok := p.peerMetrics[peer]; !ok {
+		p.peerMetrics[peer] = newPeerMetrics(&p.inFlight)
+	}
+	return &HTTPGetter{
+		baseURL:    peer,
+		metrics:    p.peerMetrics[peer],
+		ringHash:   p.RingHash,
+		hasher:     p.Hasher,
+		ringParams: p.RingParams,
+	}
+}
+
+// RingHash is the fingerprint of the ring of the pool.
+// Pools with the same peers have the same RingHash.
+func (p *HTTPPool) RingHash() uint64 {
+	return p.ring.Load().hash
+}
+
+// Hasher names the hash function of the ring of the pool, see WithHasher.
+// Pools of different hashers refuse to talk to each other.
+func (p *HTTPPool) Hasher() string {
+	return p.ring.Load().peers.Hasher()
+}
+
+// RingParams tell how the ring of the pool is built besides its peers,
+// see WithRingOptions. Pools of different params refuse to talk to each other.
+func (p *HTTPPool) RingParams() string {
+	return p.ring.Load().peers.Params()
+}
+
 // RemovePeers remove a set of peers:
 // format:"http://0.0.0.0:8000/geecache/"
 // * Not idempotent
 // * Errs if not exist
+// * the pool is left as it was if it fails
 func (p *HTTPPool) RemovePeers(peers ...string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	old := p.ring.Load()
+	ring := old.peers.Clone()
+	getters := make(map[string]*HTTPGetter, len(old.httpGetters))
+	for peer, getter := range old.httpGetters {
+		getters[peer] = getter
+	}
+
 	for _, peer := range peers {
 		// errs if not exist
-		err := p.peers.RemoveNode(peer)
+		err := ring.RemoveNode(peer)
 
 		if err != nil {
 			return fmt.Errorf("can't remove peers: +%w", err)
 		}
 
-		delete(p.httpGetters, peer)
+		delete(getters, peer)
+	}
+
+	for _, peer := range peers {
+		p.health.forget(peer)
+	}
+	p.ring.Store(newRingSnapshot(ring, getters))
+	return nil
+}
+
+// SetPeerLabels tells where peers are, see WithLabels.
+// Labels of peers that haven't joined yet are kept until they do,
+// empty labels forget a peer's. Labels are part of RingHash, so every
+// pool of the cluster should be told the same.
+func (p *HTTPPool) SetPeerLabels(labels map[string]consistentHash.Labels) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.ring.Load()
+	ring := old.peers.Clone()
+	for peer, l := range labels {
+		if ring.Weight(peer) != 0 {
+			if err := ring.SetLabels(peer, l); err != nil {
+				return fmt.Errorf("can't label the peer %s: %w", peer, err)
+			}
+		}
+	}
+	for peer, l := range labels {
+		if l == (consistentHash.Labels{}) {
+			delete(p.peerLabels, peer)
+			continue
+		}
+		p.peerLabels[peer] = l
 	}
 
+	p.ring.Store(newRingSnapshot(ring, old.httpGetters))
 	return nil
 }
 
 // PickPeer returns a peer if peer is valid (not "")
 // and is not the caller itself.
 // * Return false is no peer exists.
+// * Skip peers marked down by the health checker.
+// * If the owner is skipped, prefer a peer in the zone of the pool.
+// * Safe to call while peers change, it doesn't lock.
 func (p *HTTPPool) PickPeer(query string) (PeerGetter, bool) {
+	r := p.ring.Load()
+
+	up := func(name string) bool {
+		return !p.health.isDown(name)
+	}
+	peer, ok := r.peers.FindNodeFunc(query, up)
 
-	peer := p.peers.FindNode(query)
+	// the owner is down or full, whoever takes over only holds the key
+	// for a while, so it may as well be close by
+	if zone := r.peers.Labels("http://" + p.host + p.basePath).Zone; ok && zone != "" &&
+		peer != r.peers.FindN(query, 1)[0] {
+		if near, found := r.peers.FindNodeFunc(query, func(name string) bool {
+			return up(name) && r.peers.Labels(name).Zone == zone
+		}); found {
+			peer = near
+		}
+	}
 
-	if "http://"+p.host+p.basePath == peer {
+	if !ok || "http://"+p.host+p.basePath == peer {
 		return nil, false
 	}
 
-	pGetter, valid := p.httpGetters[peer]
+	pGetter, valid := r.httpGetters[peer]
 	return pGetter, valid
 }
 
+// PickPeers returns up to n owners of the key, the pool itself is nil.
+// Peers marked down are left out. Owners are spread across zones and
+// racks, see placement.Placement.FindNSpread. They are in ring order,
+// unless the pool knows its zone, then the pool itself comes first and
+// the owners in its zone next, so that reads stay in the zone.
+func (p *HTTPPool) PickPeers(key string, n int) []PeerGetter {
+	r := p.ring.Load()
+
+	self := "http://" + p.host + p.basePath
+	owners := r.peers.FindNSpread(key, n)
+	if zone := r.peers.Labels(self).Zone; zone != "" {
+		rank := func(peer string) int {
+			switch {
+			case peer == self:
+				return 0
+			case r.peers.Labels(peer).Zone == zone:
+				return 1
+			}
+			return 2
+		}
+		sort.SliceStable(owners, func(i, j int) bool {
+			return rank(owners[i]) < rank(owners[j])
+		})
+	}
+
+	ret := make([]PeerGetter, 0, n)
+	for _, peer := range owners {
+		if p.health.isDown(peer) {
+			continue
+		}
+		if peer == self {
+			ret = append(ret, nil)
+			continue
+		}
+		if pGetter, ok := r.httpGetters[peer]; ok {
+			ret = append(ret, pGetter)
+		}
+	}
+	return ret
+}
+
+// AllPeers returns the getters of all peers but itself
+func (p *HTTPPool) AllPeers() []PeerGetter {
+	r := p.ring.Load()
+
+	self := "http://" + p.host + p.basePath
+	ret := make([]PeerGetter, 0, len(r.httpGetters))
+	for peer, getter := range r.httpGetters {
+		if peer != self {
+			ret = append(ret, getter)
+		}
+	}
+	return ret
+}
+
+// pingers returns the getters the health checker probes
+func (p *HTTPPool) pingers() map[string]PeerPinger {
+	r := p.ring.Load()
+
+	self := "http://" + p.host + p.basePath
+	ret := make(map[string]PeerPinger, len(r.httpGetters))
+	for peer, getter := range r.httpGetters {
+		if peer != self {
+			ret[peer] = getter
+		}
+	}
+	return ret
+}
+
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ PeerLister = (*HTTPPool)(nil)
+var _ ReplicaPicker = (*HTTPPool)(nil)
@@ -0,0 +1,94 @@
+//go:build testrace
+// +build testrace
+
+package geecache
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// test me with go test -race -tags testrace
+// lookups must neither race with membership changes nor see a half changed ring
+func TestPickPeerStress(t *testing.T) {
+	p := NewHTTPPool(19638)
+	peers := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		peers = append(peers, fmt.Sprintf("http://10.0.0.%d:8000/geecache/", i))
+	}
+	p.AddPeers(peers...)
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				pGetter, ok := p.PickPeer(fmt.Sprint(i, j))
+				if ok && pGetter == nil {
+					t.Error("picked a peer without getter")
+					return
+				}
+				p.AllPeers()
+				p.RingHash()
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p.WriteMetrics(io.Discard)
+		}
+	}()
+
+	writers := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			for j := 0; j < 100; j++ {
+				peer := peers[(i*3+j)%len(peers)]
+				switch j % 3 {
+				case 0:
+					p.RemovePeers(peer)
+				case 1:
+					p.AddPeers(peer)
+				case 2:
+					p.SetPeers(peers[:5+j%5]...)
+				}
+			}
+		}(i)
+	}
+	writers.Wait()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// the snapshot left is consistent
+	r := p.ring.Load()
+	if len(r.httpGetters) != r.peers.Len() {
+		t.Errorf("%d getters for %d peers", len(r.httpGetters), r.peers.Len())
+	}
+	for _, name := range r.peers.Nodes() {
+		if _, ok := r.httpGetters[name]; !ok {
+			t.Errorf("no getter for %s", name)
+		}
+	}
+}
@@ -2,14 +2,22 @@ package geecache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+	"github.com/Hawk-Zhou/better-groupcache/placement"
 )
 
 var testingClient = &http.Client{
@@ -36,13 +44,13 @@ func testGet(url string) (bodyText string, code int, err error) {
 func TestServerAbnormalRequest(t *testing.T) {
 	println("the following groups exist")
 
-	for key := range groups {
+	for key := range DefaultCache.groups {
 		println(key)
 	}
 
 	callbackCount := 0
 
-	g := NewGroup("g1", 10, GetterFunc(func(key string) ([]byte, error) {
+	g := newTestGroup(t, "g1", 10, GetterFunc(func(key string) ([]byte, error) {
 		callbackCount++
 		return []byte(key), nil
 	}))
@@ -127,7 +135,7 @@ func TestHTTPGetter_Get(t *testing.T) {
 	groupName := "test_httpGetter"
 	callbackFlag := false
 
-	g := NewGroup("test_httpGetter", 10, GetterFunc(func(key string) ([]byte, error) {
+	g := newTestGroup(t, "test_httpGetter", 10, GetterFunc(func(key string) ([]byte, error) {
 		callbackFlag = true
 		return []byte(key), nil
 	}))
@@ -137,7 +145,7 @@ func TestHTTPGetter_Get(t *testing.T) {
 	go server.ListenAndServe()
 	time.Sleep(time.Millisecond * 50)
 
-	for key := range groups {
+	for key := range DefaultCache.groups {
 		println(key)
 	}
 
@@ -177,7 +185,7 @@ func TestHTTPGetter_Get(t *testing.T) {
 
 func TestHTTPPool_PeerOp(t *testing.T) {
 	count := 0
-	g := NewGroup("peerOp", 10, nil)
+	g := newTestGroup(t, "peerOp", 10, nil)
 	localPool := NewHTTPPool(8001)
 	g.RegisterPeers(localPool)
 
@@ -203,7 +211,7 @@ func TestHTTPPool_PeerOp(t *testing.T) {
 	// itself is removed from its peers. So any query it got will be directed to remote.
 	// If remote use localPool, then the query will be given to localPool to choose a peer to answer.
 	// Boom bang, now it loops forever.
-	remoteGroup := NewGroup("remoteG", 10, GetterFunc(func(key string) ([]byte, error) {
+	remoteGroup := newTestGroup(t, "remoteG", 10, GetterFunc(func(key string) ([]byte, error) {
 		count++
 		return []byte(key), nil
 	}))
@@ -233,7 +241,7 @@ func TestHTTPPool_PeerOp(t *testing.T) {
 }
 
 func Test_remoteManagePeers(t *testing.T) {
-	g := NewGroup("remoteMgtPurgePeers", 10, nil)
+	g := newTestGroup(t, "remoteMgtPurgePeers", 10, nil)
 	localPool := NewHTTPPool(4584)
 	g.RegisterPeers(localPool)
 
@@ -246,7 +254,7 @@ func Test_remoteManagePeers(t *testing.T) {
 	localPool.AddPeers(peerAddr)
 
 	// check peer is added
-	_, ok := localPool.httpGetters[peerAddr]
+	_, ok := localPool.ring.Load().httpGetters[peerAddr]
 	if !ok {
 		t.Error("unexpected error, the node isn't added as peer")
 	}
@@ -258,7 +266,7 @@ func Test_remoteManagePeers(t *testing.T) {
 	}
 
 	// check remote removal success
-	_, ok = localPool.httpGetters[peerAddr]
+	_, ok = localPool.ring.Load().httpGetters[peerAddr]
 	if ok {
 		t.Error("unexpected error, the node isn't removed")
 	}
@@ -270,7 +278,7 @@ func Test_remoteManagePeers(t *testing.T) {
 	}
 
 	// check peer is not there before remotely add
-	_, ok = localPool.httpGetters[peerAddr]
+	_, ok = localPool.ring.Load().httpGetters[peerAddr]
 	if ok {
 		t.Error("unexpected error, the node shouldn't be there")
 	}
@@ -283,7 +291,7 @@ func Test_remoteManagePeers(t *testing.T) {
 	}
 
 	// check peer is there after remotely add
-	_, ok = localPool.httpGetters[peerAddr]
+	_, ok = localPool.ring.Load().httpGetters[peerAddr]
 	if !ok {
 		t.Error("unexpected error, the node should be added")
 	}
@@ -294,3 +302,458 @@ func Test_remoteManagePeers(t *testing.T) {
 		t.Error("should deny empty peer list")
 	}
 }
+
+func TestHTTPGetterDeadline(t *testing.T) {
+	served := make(chan error, 1)
+	g := newTestGroup(t, "slowRemote", 10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			served <- ctx.Err()
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			served <- nil
+			return []byte(key), nil
+		}
+	}))
+	p := NewHTTPPool(8003)
+	g.RegisterPeers(p)
+	server := p.NewServer()
+	go server.ListenAndServe()
+	defer server.Shutdown(context.TODO())
+	time.Sleep(50 * time.Millisecond)
+
+	getter := &HTTPGetter{baseURL: "http://" + p.host + p.basePath}
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	_, err := getter.GetContext(ctx, "slowRemote", "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("serving side didn't honour the deadline")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("serving side didn't give up in time")
+	}
+}
+
+func TestHTTPGetterLongDeadline(t *testing.T) {
+	told := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		told <- r.Header.Get(timeoutHeader)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("v"))
+	}))
+	defer server.Close()
+	getter := &HTTPGetter{baseURL: server.URL + defaultBasePath}
+
+	// a budget beyond defaultPeerTimeout is waited for in full
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ret, err := getter.GetContext(ctx, "g", "k")
+	if err != nil || string(ret) != "v" {
+		t.Errorf("expecting v, got %s, %v", ret, err)
+	}
+	if ms, _ := strconv.Atoi(<-told); ms <= 200 {
+		t.Errorf("the peer should be told the whole budget, got %dms", ms)
+	}
+
+	// without a deadline the default one is told and waited for
+	_, err = getter.GetContext(context.Background(), "g", "k")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+	if ms, _ := strconv.Atoi(<-told); ms <= 0 || ms > 200 {
+		t.Errorf("the peer should be told the default budget, got %dms", ms)
+	}
+}
+
+func TestSetPeers(t *testing.T) {
+	p1, p2 := NewHTTPPool(19635), NewHTTPPool(19636)
+	self1, self2 := "http://"+p1.host+p1.basePath, "http://"+p2.host+p2.basePath
+	other := "http://10.0.0.1:8000/geecache/"
+
+	if err := p1.SetPeers(other, self2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.SetPeers(self1, other, self1); err != nil {
+		t.Fatal(err)
+	}
+	if p1.RingHash() != p2.RingHash() {
+		t.Error("pools with the same peers should have the same ring")
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if p1.ring.Load().peers.FindNode(key) != p2.ring.Load().peers.FindNode(key) {
+			t.Fatalf("pools disagree on the owner of %s", key)
+		}
+	}
+
+	// peers that are left out are removed, getters of kept ones are reused
+	kept := p1.ring.Load().httpGetters[other]
+	before := p1.RingHash()
+	if err := p1.SetPeers(other); err != nil {
+		t.Fatal(err)
+	}
+	if p1.RingHash() == before {
+		t.Error("ring hash should change with the peers")
+	}
+	if getters := p1.ring.Load().httpGetters; len(getters) != 2 || getters[other] != kept {
+		t.Errorf("unexpected getters %v", getters)
+	}
+	for i := 0; i < 1000; i++ {
+		if pGetter, ok := p1.PickPeer(fmt.Sprint(i)); ok && peerName(pGetter) != other {
+			t.Fatalf("removed peer %s is still picked", peerName(pGetter))
+		}
+	}
+
+	// over the manage op
+	server := httptest.NewServer(p2)
+	defer server.Close()
+	if err := p1.SetPeersRemote(server.URL+defaultBasePath, other); err != nil {
+		t.Fatal(err)
+	}
+	if n := p2.ring.Load().peers.Len(); n != 2 {
+		t.Errorf("remote should be left with 2 peers, got %d", n)
+	}
+}
+
+func TestWeightedPeers(t *testing.T) {
+	p := NewHTTPPool(19639)
+	big, small := "http://10.0.0.1:8000/geecache/", "http://10.0.0.2:8000/geecache/"
+	if err := p.AddPeersWeighted(map[string]int{big: 8, small: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ring := p.ring.Load().peers
+	if ring.Weight(big) != 8 || ring.Weight(small) != 1 || ring.Weight("http://"+p.host+p.basePath) != 1 {
+		t.Error("got wrong weights")
+	}
+
+	// AddPeers doesn't re-weight, AddPeersWeighted does
+	p.AddPeers(big)
+	if p.ring.Load().peers.Weight(big) != 8 {
+		t.Error("AddPeers shouldn't change the weight of an existing peer")
+	}
+	getter := p.ring.Load().httpGetters[big]
+	if err := p.AddPeersWeighted(map[string]int{big: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if p.ring.Load().peers.Weight(big) != 2 || p.ring.Load().httpGetters[big] != getter {
+		t.Error("re-weighting should keep the getter")
+	}
+	if err := p.AddPeersWeighted(map[string]int{small: 0}); err == nil {
+		t.Error("weight 0 should be rejected")
+	}
+
+	// over the manage op
+	remote := NewHTTPPool(19640)
+	server := httptest.NewServer(remote)
+	defer server.Close()
+	if err := p.AddPeersWeightedRemote(server.URL+defaultBasePath, map[string]int{big: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if w := remote.ring.Load().peers.Weight(big); w != 4 {
+		t.Errorf("remote should have %s of weight 4, got %d", big, w)
+	}
+	if err := p.SetPeersWeightedRemote(server.URL+defaultBasePath, map[string]int{big: 3, small: 2}); err != nil {
+		t.Fatal(err)
+	}
+	ring = remote.ring.Load().peers
+	if ring.Len() != 3 || ring.Weight(big) != 3 || ring.Weight(small) != 2 {
+		t.Errorf("remote got wrong weights after set")
+	}
+	if err := p.AddPeerRemote(server.URL+defaultBasePath, big); err != nil {
+		t.Fatal(err)
+	}
+	if w := remote.ring.Load().peers.Weight(big); w != 3 {
+		t.Errorf("unweighted add shouldn't re-weight, got %d", w)
+	}
+}
+
+func TestBoundedLoadPool(t *testing.T) {
+	p := NewHTTPPool(19641, WithBoundedLoad(0.25))
+	peerA, peerB := "http://10.0.0.1:8000/geecache/", "http://10.0.0.2:8000/geecache/"
+	p.AddPeers(peerA, peerB)
+
+	key := ""
+	for i := 0; ; i++ {
+		key = fmt.Sprint("key", i)
+		if pGetter, ok := p.PickPeer(key); ok && peerName(pGetter) == peerA {
+			break
+		}
+	}
+
+	// pretend that peerA is busy with requests
+	metrics := p.ring.Load().httpGetters[peerA].metrics
+	for i := 0; i < 10; i++ {
+		metrics.begin()
+	}
+	if pGetter, ok := p.PickPeer(key); ok && peerName(pGetter) == peerA {
+		t.Error("keys of an overloaded peer should go to the next node")
+	}
+	if p.inFlight.Get() != 10 {
+		t.Errorf("pool should count 10 requests in flight, got %d", p.inFlight.Get())
+	}
+	for i := 0; i < 10; i++ {
+		metrics.record(time.Now(), nil)
+	}
+	if pGetter, ok := p.PickPeer(key); !ok || peerName(pGetter) != peerA {
+		t.Error("keys should go back to their owner once it's idle")
+	}
+}
+
+func TestPoolPlacement(t *testing.T) {
+	peers := []string{"http://10.0.0.1:8000/geecache/", "http://10.0.0.2:8000/geecache/"}
+	placements := map[string]func() placement.Placement{
+		"ring":       placement.NewRing,
+		"rendezvous": placement.NewRendezvous,
+		"jump":       placement.NewJump,
+		"maglev":     placement.NewMaglev,
+	}
+	hashes := make(map[uint64]string)
+	for name, newPlacement := range placements {
+		p1 := NewHTTPPool(19642, WithPlacement(newPlacement))
+		p2 := NewHTTPPool(19642, WithPlacement(newPlacement))
+		p1.SetPeers(peers...)
+		p2.SetPeers(peers...)
+		if p1.RingHash() != p2.RingHash() {
+			t.Errorf("%s: pools with the same peers should have the same ring", name)
+		}
+		if other, ok := hashes[p1.RingHash()]; ok {
+			t.Errorf("%s and %s have the same ring hash", name, other)
+		}
+		hashes[p1.RingHash()] = name
+
+		picked := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			pGetter, ok := p1.PickPeer(fmt.Sprint(i))
+			if ok {
+				picked[peerName(pGetter)] = true
+			}
+		}
+		if len(picked) != 2 {
+			t.Errorf("%s: keys should be spread over both peers, got %v", name, picked)
+		}
+
+		// AddPeers adds the pool itself after the first peer, so each pool
+		// adds the members in a different order
+		ports := []int{19655, 19656, 19657}
+		members := make([]string, len(ports))
+		for i, port := range ports {
+			members[i] = fmt.Sprintf("http://0.0.0.0:%d%s", port, defaultBasePath)
+		}
+		fingerprints := make(map[uint64]bool)
+		for i, port := range ports {
+			p := NewHTTPPool(port, WithPlacement(newPlacement))
+			for _, member := range members {
+				if member != members[i] {
+					p.AddPeers(member)
+				}
+			}
+			fingerprints[p.RingHash()] = true
+		}
+		if len(fingerprints) != 1 {
+			t.Errorf("%s: pools of the same members should agree on owners", name)
+		}
+	}
+}
+
+func TestRingMismatch(t *testing.T) {
+	count := 0
+	g := newTestGroup(t, "ringGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		count++
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19637)
+	g.RegisterPeers(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	ours := uint64(42)
+	getter := &HTTPGetter{baseURL: server.URL + defaultBasePath, ringHash: func() uint64 { return ours }}
+
+	// the callee doesn't cache what it loads for a caller with another ring,
+	// and the caller is told not to cache it either
+	ctx, hints := withLoadHints(context.Background())
+	ret, err := getter.GetContext(ctx, "ringGroup", "k")
+	if err != nil || string(ret) != "k" {
+		t.Fatalf("unexpected ret/err %v/%v", string(ret), err)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Error("callee shouldn't cache on ring mismatch")
+	}
+	if hints.noCache.Get() == 0 {
+		t.Error("caller should be told not to cache on ring mismatch")
+	}
+
+	ours = p.RingHash()
+	ctx, hints = withLoadHints(context.Background())
+	getter.GetContext(ctx, "ringGroup", "k")
+	if _, ok := g.mainCache.get("k"); !ok {
+		t.Error("callee should cache when rings agree")
+	}
+	if hints.noCache.Get() != 0 {
+		t.Error("caller should cache when rings agree")
+	}
+	if count != 2 {
+		t.Errorf("expecting 2 loads, got %d", count)
+	}
+}
+
+func TestHasherMismatch(t *testing.T) {
+	g := newTestGroup(t, "hasherGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19645, WithHasher("xxhash64:1"))
+	g.RegisterPeers(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+	if p.Hasher() != "xxhash64:1" {
+		t.Fatalf("the pool should hash with xxhash64:1, got %s", p.Hasher())
+	}
+
+	ours := consistentHash.CRC32
+	getter := &HTTPGetter{baseURL: server.URL + defaultBasePath, hasher: func() string { return ours }}
+	if _, err := getter.Get("hasherGroup", "k"); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("the peer should refuse another hasher, got %v", err)
+	}
+	if err := getter.Ping(context.Background()); err == nil {
+		t.Error("a peer of another hasher should fail pings")
+	}
+
+	ours = p.Hasher()
+	if ret, err := getter.Get("hasherGroup", "k"); err != nil || string(ret) != "k" {
+		t.Errorf("peers of the same hasher should talk, got %v/%v", string(ret), err)
+	}
+
+	// the caller refuses a peer that doesn't check
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(hasherHeader, consistentHash.Murmur3)
+		w.Write([]byte("v"))
+	}))
+	defer other.Close()
+	getter.baseURL = other.URL + defaultBasePath
+	if _, err := getter.Get("hasherGroup", "k"); err == nil {
+		t.Error("the caller should refuse a peer of another hasher")
+	}
+
+	if p.Validate() != nil {
+		t.Errorf("xxhash64:1 is a hasher, got %v", p.Validate())
+	}
+	unknown := NewHTTPPool(19645, WithHasher("sha1"))
+	if unknown.Validate() == nil || unknown.Hasher() != consistentHash.CRC32 {
+		t.Errorf("an unknown hasher should be reported, got %v", unknown.Validate())
+	}
+}
+
+func TestRingParamsMismatch(t *testing.T) {
+	g := newTestGroup(t, "paramsGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19646, WithRingOptions(consistentHash.WithVNodes(100)))
+	g.RegisterPeers(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+	if want := "crc32/vnodes=100/salt=1"; p.RingParams() != want {
+		t.Fatalf("expecting %s, got %s", want, p.RingParams())
+	}
+
+	ours := NewHTTPPool(19646).RingParams()
+	getter := &HTTPGetter{baseURL: server.URL + defaultBasePath, ringParams: func() string { return ours }}
+	if _, err := getter.Get("paramsGroup", "k"); err == nil || !strings.Contains(err.Error(), "ring params") {
+		t.Errorf("the peer should refuse a ring built differently, got %v", err)
+	}
+	ours = p.RingParams()
+	if ret, err := getter.Get("paramsGroup", "k"); err != nil || string(ret) != "k" {
+		t.Errorf("rings built alike should talk, got %v/%v", string(ret), err)
+	}
+
+	// the same peers on rings built differently
+	peers := []string{"http://10.0.0.1:8000/geecache/", "http://10.0.0.2:8000/geecache/"}
+	other := NewHTTPPool(19646)
+	p.SetPeers(peers...)
+	other.SetPeers(peers...)
+	if p.RingHash() == other.RingHash() {
+		t.Error("rings built differently should have different ring hashes")
+	}
+
+	// a ring hashed by a hasher of its own is told apart by its other params only
+	custom := NewHTTPPool(19646, WithPlacement(func() placement.Placement {
+		return &placement.Ring{CHash: consistentHash.NewCHash(func(b []byte) uint32 { return crc32.ChecksumIEEE(b) })}
+	}))
+	if want := "/vnodes=50/salt=1"; custom.RingParams() != want {
+		t.Fatalf("expecting %s, got %s", want, custom.RingParams())
+	}
+	defaultServer := httptest.NewServer(NewHTTPPool(19646))
+	defer defaultServer.Close()
+	getter = &HTTPGetter{baseURL: defaultServer.URL + defaultBasePath, hasher: custom.Hasher, ringParams: custom.RingParams}
+	if ret, err := getter.Get("paramsGroup", "k"); err != nil || string(ret) != "k" {
+		t.Errorf("a custom hasher should be taken as compatible, got %v/%v", string(ret), err)
+	}
+	if incompatible("", custom.RingParams(), consistentHash.CRC32, p.RingParams()) == "" {
+		t.Error("a custom hasher should still be refused on other params")
+	}
+}
+
+func TestRingSnapshot(t *testing.T) {
+	p := NewHTTPPool(19647, WithHasher("murmur3"))
+	p.AddPeersWeighted(map[string]int{
+		"http://10.0.0.1:8000/geecache/": 2,
+		"http://10.0.0.2:8000/geecache/": 1,
+	})
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	// a new pool routes as p does without being told the peers
+	fresh := NewHTTPPool(19648, WithHasher("murmur3"))
+	snapshot, err := fresh.FetchRingRemote(server.URL + defaultBasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.RestoreRing(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.RingHash() != p.RingHash() {
+		t.Error("the restored ring should be the same ring")
+	}
+	ours, theirs := fresh.ring.Load().peers, p.ring.Load().peers
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprint(i); ours.FindNode(key) != theirs.FindNode(key) {
+			t.Fatalf("%s should be owned by %s", key, theirs.FindNode(key))
+		}
+	}
+	if _, ok := fresh.ring.Load().httpGetters["http://10.0.0.1:8000/geecache/"]; !ok {
+		t.Error("peers of the restored ring should have getters")
+	}
+
+	// rings built differently aren't restored
+	other := NewHTTPPool(19648)
+	before := other.RingHash()
+	if err := other.RestoreRing(snapshot); err == nil || other.RingHash() != before {
+		t.Errorf("a ring of another hasher shouldn't be restored, got %v", err)
+	}
+
+	maglev := NewHTTPPool(19648, WithPlacement(placement.NewMaglev))
+	if _, err := maglev.RingSnapshot(); err == nil {
+		t.Error("only rings can be snapshotted")
+	}
+	maglevServer := httptest.NewServer(maglev)
+	defer maglevServer.Close()
+	if _, err := fresh.FetchRingRemote(maglevServer.URL + defaultBasePath); err == nil {
+		t.Error("fetching a ring that can't be snapshotted should fail")
+	}
+}
+
+func TestAddPeersKeepsCallerSlice(t *testing.T) {
+	p := NewHTTPPool(19653)
+	backing := []string{"http://10.0.0.1:8000/geecache/", "untouched"}
+	if err := p.AddPeers(backing[:1]...); err != nil {
+		t.Fatal(err)
+	}
+	if backing[1] != "untouched" {
+		t.Errorf("AddPeers wrote to the caller's slice: %v", backing)
+	}
+}
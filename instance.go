@@ -0,0 +1,157 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Cache owns a set of groups and the pool that serves them to peers,
+// so that independent caches can live in one process, eg.
+//
+//	c := NewCache()
+//	p, err := c.NewHTTPPool(8000)
+//	g, err := c.NewGroup("scores", 1<<20, getter)
+//
+// Groups of different Caches may share names. The package-level NewGroup
+// and GetGroup use DefaultCache, as do pools made by NewHTTPPool.
+type Cache struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	// pool is nil until NewHTTPPool or NewGRPCPool is called
+	pool PeerPicker
+}
+
+// DefaultCache holds the groups of NewGroup
+var DefaultCache = NewCache()
+
+func NewCache() *Cache {
+	return &Cache{groups: make(map[string]*Group)}
+}
+
+// NewGroup creates a group and registers it under name.
+// maxBytes is the capacity of mainCache, see GroupOption for the rest.
+// The group picks peers from the pool of c, if it has one.
+// It errs if c has a group of the name, see ReplaceGroup.
+func (c *Cache) NewGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) (*Group, error) {
+	return c.addGroup(newGroup(name, maxBytes, getter, opts...), false)
+}
+
+// MustNewGroup is NewGroup that panics if c has a group of the name
+func (c *Cache) MustNewGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
+	g, err := c.NewGroup(name, maxBytes, getter, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// ReplaceGroup is NewGroup that replaces the group of the name, if any.
+// The old group is closed, peers asking for the name are served by the
+// new one from then on.
+func (c *Cache) ReplaceGroup(name string, maxBytes int, getter Getter, opts ...GroupOption) *Group {
+	g, _ := c.addGroup(newGroup(name, maxBytes, getter, opts...), true)
+	return g
+}
+
+// addGroup registers g, the group already of its name is closed if replace
+func (c *Cache) addGroup(g *Group, replace bool) (*Group, error) {
+	c.mu.Lock()
+	old, ok := c.groups[g.name]
+	if ok && !replace {
+		c.mu.Unlock()
+		// g was never seen by anyone, stop its janitor
+		g.Close()
+		return nil, fmt.Errorf("group %s already exists", g.name)
+	}
+	c.groups[g.name] = g
+	if c.pool != nil {
+		g.RegisterPeers(c.pool)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		old.Close()
+	}
+	return g, nil
+}
+
+// DeleteGroup unregisters the group of the name and closes it.
+// Peers asking for it are told that the group isn't found.
+// It returns false if c has no group of the name.
+func (c *Cache) DeleteGroup(name string) bool {
+	c.mu.Lock()
+	g, ok := c.groups[name]
+	delete(c.groups, name)
+	c.mu.Unlock()
+
+	if ok {
+		g.Close()
+	}
+	return ok
+}
+
+// GetGroup doesn't create if not exist
+func (c *Cache) GetGroup(name string) (*Group, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	g, ok := c.groups[name]
+	return g, ok
+}
+
+// Groups returns the groups of c, sorted by name
+func (c *Cache) Groups() []*Group {
+	c.mu.RLock()
+	ret := make([]*Group, 0, len(c.groups))
+	for _, g := range c.groups {
+		ret = append(ret, g)
+	}
+	c.mu.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// NewHTTPPool is NewHTTPPool serving the groups of c.
+// Groups of c pick peers from it, so it must be made before them.
+// A Cache has one pool, it errs if c has one already or has groups,
+// or if the options are invalid, see HTTPPool.Validate.
+func (c *Cache) NewHTTPPool(port int, opts ...PoolOption) (*HTTPPool, error) {
+	p := NewHTTPPool(port, opts...)
+	p.cache = c
+	err := p.Validate()
+	if err == nil {
+		err = c.setPool(p)
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewGRPCPool is NewGRPCPool serving the groups of c, see NewHTTPPool
+func (c *Cache) NewGRPCPool(self string, opts ...GRPCPoolOption) (*GRPCPool, error) {
+	p := NewGRPCPool(self, opts...)
+	p.cache = c
+	if err := c.setPool(p); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// setPool refuses caches that have groups, as RegisterPeers refuses
+// groups that have peers, for groups read their peers without locking
+func (c *Cache) setPool(pool PeerPicker) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != nil {
+		return errors.New("the cache has a pool already")
+	}
+	if len(c.groups) > 0 {
+		return errors.New("the pool of a cache must be made before its groups")
+	}
+	c.pool = pool
+	return nil
+}
@@ -0,0 +1,158 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndependentCaches(t *testing.T) {
+	caches := []*Cache{NewCache(), NewCache()}
+	servers := make([]*httptest.Server, len(caches))
+	for i, c := range caches {
+		value := []byte{byte('a' + i)}
+		p, err := c.NewHTTPPool(19649 + i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shared := c.MustNewGroup("shared", 100, GetterFunc(func(key string) ([]byte, error) {
+			return value, nil
+		}))
+		after := c.MustNewGroup("after", 100, GetterFunc(func(key string) ([]byte, error) {
+			return value, nil
+		}))
+		if shared.peers != p || after.peers != p {
+			t.Fatal("groups of a cache should pick peers from its pool")
+		}
+		servers[i] = httptest.NewServer(p)
+		defer servers[i].Close()
+	}
+
+	// the same group name is served from each cache's own group
+	for i, server := range servers {
+		getter := &HTTPGetter{baseURL: server.URL + defaultBasePath}
+		ret, err := getter.GetContext(context.Background(), "shared", "k")
+		if want := string(rune('a' + i)); err != nil || string(ret) != want {
+			t.Errorf("cache %d should serve %s, got %s, %v", i, want, ret, err)
+		}
+	}
+
+	if _, ok := GetGroup("after"); ok {
+		t.Error("groups of a cache shouldn't be in DefaultCache")
+	}
+	if _, ok := caches[0].GetGroup("after"); !ok {
+		t.Error("the cache should have its group")
+	}
+	newTestGroup(t, "defaultOnly", 100, GetterFunc(func(key string) ([]byte, error) { return nil, nil }))
+	if _, ok := caches[0].GetGroup("defaultOnly"); ok {
+		t.Error("groups of DefaultCache shouldn't be in other caches")
+	}
+
+	// metrics only tell the groups of the pool's cache
+	rec := httptest.NewRecorder()
+	caches[0].pool.(*HTTPPool).MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if text := string(body); !strings.Contains(text, `group="shared"`) || strings.Contains(text, "defaultOnly") {
+		t.Errorf("metrics should be of the groups of the cache, got\n%s", text)
+	}
+
+	if p, err := caches[0].NewHTTPPool(19651); err == nil || p != nil {
+		t.Error("a cache should have one pool")
+	}
+
+	// groups read their peers unlocked, the pool can't change under them
+	c := NewCache()
+	c.MustNewGroup("early", 100, nil)
+	if p, err := c.NewHTTPPool(19654); err == nil || p != nil {
+		t.Error("the pool of a cache should be made before its groups")
+	}
+	if p, err := NewCache().NewHTTPPool(19654, WithHasher("sha1")); err == nil || p != nil {
+		t.Error("a pool with invalid options shouldn't be made")
+	}
+}
+
+func TestGroupLifecycle(t *testing.T) {
+	c := NewCache()
+	p, err := c.NewHTTPPool(19652)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(p)
+	defer server.Close()
+	getter := &HTTPGetter{baseURL: server.URL + defaultBasePath}
+
+	old := c.MustNewGroup("lifecycle", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("old"), nil
+	}), WithJanitor(time.Millisecond))
+	if _, err := old.Get("k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, err := c.NewGroup("lifecycle", 100, nil); err == nil || g != nil {
+		t.Error("NewGroup should refuse a name that is taken")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MustNewGroup should panic on a name that is taken")
+			}
+		}()
+		c.MustNewGroup("lifecycle", 100, nil)
+	}()
+	if g, _ := c.GetGroup("lifecycle"); g != old {
+		t.Fatal("a refused group shouldn't replace the old one")
+	}
+
+	// the old group is closed and its caches are freed
+	c.ReplaceGroup("lifecycle", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("new"), nil
+	}))
+	if _, err := old.Get("k"); !errors.Is(err, errGroupClosed) {
+		t.Errorf("gets on a replaced group should fail, got %v", err)
+	}
+	if old.mainCache.lru != nil {
+		t.Error("caches of a closed group should be freed")
+	}
+	old.populateCache("k", ByteView{b: []byte("old")})
+	if _, ok := old.mainCache.get("k"); ok {
+		t.Error("a closed group shouldn't cache")
+	}
+	select {
+	case <-old.janitor.stop:
+	default:
+		t.Error("the janitor of a closed group should be stopped")
+	}
+	ret, err := getter.GetContext(context.Background(), "lifecycle", "k")
+	if err != nil || string(ret) != "new" {
+		t.Errorf("peers should be served by the new group, got %s, %v", ret, err)
+	}
+
+	// a closed group that is still registered isn't found either
+	g, _ := c.GetGroup("lifecycle")
+	g.Close()
+	g.Close()
+	if _, err := getter.GetContext(context.Background(), "lifecycle", "k"); err == nil ||
+		!strings.Contains(err.Error(), "group not found") {
+		t.Errorf("closed groups shouldn't be found, got %v", err)
+	}
+
+	if !c.DeleteGroup("lifecycle") {
+		t.Error("the group should be deleted")
+	}
+	if c.DeleteGroup("lifecycle") {
+		t.Error("a deleted group can't be deleted again")
+	}
+	if _, ok := c.GetGroup("lifecycle"); ok {
+		t.Error("a deleted group shouldn't be there")
+	}
+	rec := httptest.NewRecorder()
+	p.answerQuery("lifecycle", "k", rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); rec.Code != http.StatusNotFound || !strings.Contains(body, "group not found") {
+		t.Errorf("expecting 404 group not found, got %d %q", rec.Code, body)
+	}
+}
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is what the packages of this module log with.
+// args are alternating keys and values, the same as log/slog,
+// so a *slog.Logger can be used as a Logger as is.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+type Level int
+
+// the values match slog.Level
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "DEBUG"
+	case l <= LevelInfo:
+		return "INFO"
+	case l <= LevelWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+type nop struct{}
+
+func (nop) Debug(string, ...any) {}
+func (nop) Info(string, ...any)  {}
+func (nop) Warn(string, ...any)  {}
+func (nop) Error(string, ...any) {}
+
+// Nop discards everything, it's the default everywhere
+var Nop Logger = nop{}
+
+// OrNop returns Nop if l is nil
+func OrNop(l Logger) Logger {
+	if l == nil {
+		return Nop
+	}
+	return l
+}
+
+// std writes to a *log.Logger from the standard library
+type std struct {
+	l   *log.Logger
+	min Level
+}
+
+// Std adapts a *log.Logger, lines below min are dropped.
+// Lines look like
+//
+//	INFO cache miss group=g1 key=k
+//
+// Pass log.Default() to keep the old behaviour of logging everything.
+func Std(l *log.Logger, min Level) Logger {
+	return &std{l: l, min: min}
+}
+
+func (s *std) Debug(msg string, args ...any) { s.logDepth(1, LevelDebug, msg, args) }
+func (s *std) Info(msg string, args ...any)  { s.logDepth(1, LevelInfo, msg, args) }
+func (s *std) Warn(msg string, args ...any)  { s.logDepth(1, LevelWarn, msg, args) }
+func (s *std) Error(msg string, args ...any) { s.logDepth(1, LevelError, msg, args) }
+
+// depthLogger is a Logger that can tell the caller depth frames above it,
+// so that wrappers don't show up as the caller (log.Lshortfile)
+type depthLogger interface {
+	logDepth(depth int, level Level, msg string, args []any)
+}
+
+// logDepth logs for the caller depth frames above the method of s called
+func (s *std) logDepth(depth int, level Level, msg string, args []any) {
+	if level < s.min {
+		return
+	}
+	b := strings.Builder{}
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(args) {
+			// a dangling value, slog calls it !BADKEY
+			fmt.Fprintf(&b, "!BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, "%v=%v", args[i], args[i+1])
+	}
+	s.l.Output(depth+2, b.String())
+}
+
+// with prepends args to every line
+type with struct {
+	l    Logger
+	args []any
+}
+
+// withNative is set to use the With of loggers that have their own,
+// see slog.go
+var withNative func(l Logger, args []any) (Logger, bool)
+
+// With returns a Logger that attaches args to everything logged with it.
+// Nop is returned as is, so that logging to it stays free.
+func With(l Logger, args ...any) Logger {
+	if len(args) == 0 || l == Nop {
+		return l
+	}
+	if w, ok := l.(*with); ok {
+		return &with{l: w.l, args: append(append([]any{}, w.args...), args...)}
+	}
+	if withNative != nil {
+		if ret, ok := withNative(l, args); ok {
+			return ret
+		}
+	}
+	return &with{l: l, args: args}
+}
+
+// prefixed copies so that concurrent calls don't share the backing array
+func (w *with) prefixed(args []any) []any {
+	return append(w.args[:len(w.args):len(w.args)], args...)
+}
+
+func (w *with) Debug(msg string, args ...any) { w.logDepth(1, LevelDebug, msg, args) }
+func (w *with) Info(msg string, args ...any)  { w.logDepth(1, LevelInfo, msg, args) }
+func (w *with) Warn(msg string, args ...any)  { w.logDepth(1, LevelWarn, msg, args) }
+func (w *with) Error(msg string, args ...any) { w.logDepth(1, LevelError, msg, args) }
+
+func (w *with) logDepth(depth int, level Level, msg string, args []any) {
+	args = w.prefixed(args)
+	if d, ok := w.l.(depthLogger); ok {
+		d.logDepth(depth+1, level, msg, args)
+		return
+	}
+	switch level {
+	case LevelDebug:
+		w.l.Debug(msg, args...)
+	case LevelInfo:
+		w.l.Info(msg, args...)
+	case LevelWarn:
+		w.l.Warn(msg, args...)
+	default:
+		w.l.Error(msg, args...)
+	}
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStd(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := Std(log.New(buf, "", 0), LevelInfo)
+
+	l.Debug("dropped", "key", "k")
+	l.Info("kept", "key", "k", "n", 1)
+	l.Error("dangling", "oops")
+
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"INFO kept key=k n=1",
+		"ERROR dangling !BADKEY=oops",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expecting %q, got %q", want, got)
+	}
+}
+
+func TestWith(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := With(With(Std(log.New(buf, "", 0), LevelDebug), "group", "g"), "peer", "p")
+	l.Warn("msg", "key", "k")
+	if got := strings.TrimSpace(buf.String()); got != "WARN msg group=g peer=p key=k" {
+		t.Errorf("unexpected line %q", got)
+	}
+
+	if OrNop(nil) != Nop {
+		t.Error("nil should be replaced with Nop")
+	}
+}
+
+func TestWithCaller(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := With(Std(log.New(buf, "", log.Lshortfile), LevelDebug), "group", "g")
+	l.Info("msg")
+	if got := buf.String(); !strings.HasPrefix(got, "logger_test.go:") {
+		t.Errorf("the caller should be reported, got %q", got)
+	}
+
+	if With(Nop, "group", "g") != Nop {
+		t.Error("Nop shouldn't be wrapped")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import "log/slog"
+
+// a *slog.Logger attaches args with its own With, which keeps AddSource
+// pointing at the caller rather than at a wrapper
+func init() {
+	withNative = func(l Logger, args []any) (Logger, bool) {
+		if sl, ok := l.(*slog.Logger); ok {
+			return sl.With(args...), true
+		}
+		return nil, false
+	}
+}
@@ -0,0 +1,23 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// a *slog.Logger should be usable wherever a Logger is asked for
+var _ Logger = (*slog.Logger)(nil)
+
+func TestWithSlogSource(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := With(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{AddSource: true})), "group", "g")
+	l.Info("msg")
+	if got := buf.String(); !strings.Contains(got, "slog_test.go:") || !strings.Contains(got, "group=g") {
+		t.Errorf("the caller and args should be reported, got %q", got)
+	}
+}
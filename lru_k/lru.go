@@ -3,6 +3,7 @@ package lru_k
 import (
 	"container/list"
 	"errors"
+	"time"
 )
 
 type KCache struct {
@@ -11,6 +12,9 @@ type KCache struct {
 	fifoMap  map[string]*list.Element
 	fifoSize int
 	fifoLen  int
+	// bookkeeping for Stats
+	fifoEvictions int64
+	promotions    int64
 }
 
 // ll-element-element.Value-*entry-entry.value
@@ -19,7 +23,53 @@ type Cache struct {
 	usedBytes  int
 	ll         *list.List
 	cacheMap   map[string]*list.Element
-	onEviction func(key string, value Value)
+	onEviction EvictionCallback
+	evictions  int64
+	expired    int64
+}
+
+// EvictionReason tells why an entry leaves the cache
+type EvictionReason int
+
+const (
+	// EvictCapacity means the entry is evicted to make room
+	EvictCapacity EvictionReason = iota
+	// EvictExpired means the TTL of the entry passed
+	EvictExpired
+	// EvictRemoved means the entry is removed explicitly by Remove
+	EvictRemoved
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// EvictionCallback is called with every entry that leaves the cache
+type EvictionCallback func(key string, value Value, reason EvictionReason)
+
+// now is replaced in tests
+var now = time.Now
+
+// Stats is a snapshot of the bookkeeping of a cache.
+// Fifo* and Promotions are only meaningful for KCache:
+// entries evicted from the FIFO queue are the ones that were never
+// accessed twice, which plain LRU would have let into the cache.
+type Stats struct {
+	Bytes         int64 // bytes of keys and values held
+	Items         int64 // number of entries held
+	Evictions     int64 // entries evicted due to capacity
+	Expired       int64 // entries dropped because their TTL passed
+	FifoItems     int64 // entries still in the FIFO queue
+	FifoEvictions int64 // entries evicted before being accessed twice
+	Promotions    int64 // entries moved from the FIFO queue to LRU
 }
 
 type Value interface {
@@ -27,15 +77,33 @@ type Value interface {
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // zero means never
+}
+
+func (e *entry) expired(t time.Time) bool {
+	return !e.expire.IsZero() && !t.Before(e.expire)
+}
+
+// expireAt turns ttl into a deadline, ttl <= 0 means no expiration
+func expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now().Add(ttl)
 }
 
 var MaxFifoSize = 10
 
 func New(maxBytes int, onEviction func(string, Value)) *Cache {
+	return NewWithCallback(maxBytes, withoutReason(onEviction))
+}
+
+// NewWithCallback is New with a callback that's told why entries leave
+func NewWithCallback(maxBytes int, onEviction EvictionCallback) *Cache {
 	if onEviction == nil {
-		onEviction = func(s string, v Value) {}
+		onEviction = func(string, Value, EvictionReason) {}
 	}
 	return &Cache{
 		maxBytes:   maxBytes,
@@ -45,6 +113,15 @@ func New(maxBytes int, onEviction func(string, Value)) *Cache {
 	}
 }
 
+func withoutReason(onEviction func(string, Value)) EvictionCallback {
+	if onEviction == nil {
+		return nil
+	}
+	return func(key string, value Value, _ EvictionReason) {
+		onEviction(key, value)
+	}
+}
+
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	element, ok := c.cacheMap[key]
 	if !ok {
@@ -53,6 +130,12 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 		return nil, ok
 	}
 
+	// expiration is checked lazily
+	if thisEntry := element.Value.(*entry); thisEntry.expired(now()) {
+		c.removeElement(element, EvictExpired)
+		return nil, false
+	}
+
 	c.ll.MoveToFront(element)
 	return element.Value.(*entry).value, ok
 }
@@ -62,19 +145,64 @@ func (c *Cache) RemoveOldest() {
 	if element == nil {
 		return
 	}
-	delete(c.cacheMap, element.Value.(*entry).key)
+	c.removeElement(element, EvictCapacity)
+}
+
+// removeElement removes the entry and does the bookkeeping
+func (c *Cache) removeElement(element *list.Element, reason EvictionReason) {
 	thisEntry := element.Value.(*entry)
+	delete(c.cacheMap, thisEntry.key)
 	c.usedBytes -= thisEntry.value.Len()
 	c.usedBytes -= len(thisEntry.key)
-	if c.onEviction != nil {
-		c.onEviction(thisEntry.key, thisEntry.value)
+	switch reason {
+	case EvictCapacity:
+		c.evictions++
+	case EvictExpired:
+		c.expired++
 	}
+	c.onEviction(thisEntry.key, thisEntry.value, reason)
 	c.ll.Remove(element)
 }
 
+// Remove deletes the key and tells whether it was there
+func (c *Cache) Remove(key string) bool {
+	element, ok := c.cacheMap[key]
+	if !ok {
+		return false
+	}
+	c.removeElement(element, EvictRemoved)
+	return true
+}
+
+// RemoveExpired drops all expired entries and returns how many are dropped.
+// Get already ignores expired entries, this is for reclaiming their bytes.
+func (c *Cache) RemoveExpired() int {
+	t := now()
+	count := 0
+	for element := c.ll.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*entry).expired(t) {
+			c.removeElement(element, EvictExpired)
+			count++
+		}
+		element = prev
+	}
+	return count
+}
+
 // Add also can serve as Modify
 // should not be called with data larger than maxBytes
 func (c *Cache) Add(key string, value Value) error {
+	return c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL is Add with an entry that expires after ttl.
+// ttl <= 0 means it never expires. Modifying an entry resets its ttl.
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) error {
+	return c.add(key, value, expireAt(ttl))
+}
+
+func (c *Cache) add(key string, value Value, expire time.Time) error {
 	if len(key)+value.Len() > c.maxBytes {
 		return errors.New("add exceeds max capacity")
 	}
@@ -84,10 +212,11 @@ func (c *Cache) Add(key string, value Value) error {
 		thisEntry := element.Value.(*entry)
 		deltaSize = value.Len() - thisEntry.value.Len()
 		thisEntry.value = value
+		thisEntry.expire = expire
 		c.ll.MoveToFront(element)
 	} else {
 		deltaSize = value.Len() + len(key)
-		newEntry := &entry{key: key, value: value}
+		newEntry := &entry{key: key, value: value, expire: expire}
 		newElement := c.ll.PushFront(newEntry)
 		c.cacheMap[key] = newElement
 	}
@@ -103,8 +232,22 @@ func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Bytes:     int64(c.usedBytes),
+		Items:     int64(c.ll.Len()),
+		Evictions: c.evictions,
+		Expired:   c.expired,
+	}
+}
+
 func NewK(maxBytes int, onEviction func(string, Value)) *KCache {
-	return &KCache{cache: *New(maxBytes, onEviction),
+	return NewKWithCallback(maxBytes, withoutReason(onEviction))
+}
+
+// NewKWithCallback is NewK with a callback that's told why entries leave
+func NewKWithCallback(maxBytes int, onEviction EvictionCallback) *KCache {
+	return &KCache{cache: *NewWithCallback(maxBytes, onEviction),
 		fifoll:   list.New(),
 		fifoMap:  make(map[string]*list.Element),
 		fifoSize: MaxFifoSize,
@@ -112,6 +255,12 @@ func NewK(maxBytes int, onEviction func(string, Value)) *KCache {
 }
 
 func (kc *KCache) Add(key string, value Value) error {
+	return kc.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL is Add with an entry that expires after ttl.
+// ttl <= 0 means it never expires. Modifying an entry resets its ttl.
+func (kc *KCache) AddWithTTL(key string, value Value, ttl time.Duration) error {
 	if len(key)+value.Len() > kc.cache.maxBytes {
 		return errors.New("add exceeds max capacity")
 	}
@@ -123,7 +272,8 @@ func (kc *KCache) Add(key string, value Value) error {
 			kc.RemoveOldest()
 		}
 		kc.cache.usedBytes -= deltaSize
-		kc.cache.Add(key, value)
+		// Get has moved it to the LRU list, it shouldn't be added to FIFO again
+		return kc.cache.AddWithTTL(key, value, ttl)
 	}
 	// creating new entry
 	for kc.fifoLen >= kc.fifoSize {
@@ -136,7 +286,7 @@ func (kc *KCache) Add(key string, value Value) error {
 		kc.RemoveOldest()
 	}
 	kc.cache.usedBytes += size
-	newEntry := &entry{key: key, value: value}
+	newEntry := &entry{key: key, value: value, expire: expireAt(ttl)}
 	newElement := kc.fifoll.PushFront(newEntry)
 	kc.fifoMap[key] = newElement
 	return nil
@@ -158,8 +308,9 @@ func (kc *KCache) RemoveOldest() {
 	if back := kc.fifoll.Back(); back != nil {
 		back := kc.fifoll.Back()
 		thisEntry := back.Value.(*entry)
-		kc.cache.onEviction(thisEntry.key, thisEntry.value)
+		kc.cache.onEviction(thisEntry.key, thisEntry.value, EvictCapacity)
 		kc.removeElement(back)
+		kc.fifoEvictions++
 	} else {
 		kc.cache.RemoveOldest()
 	}
@@ -172,10 +323,59 @@ func (kc *KCache) Get(key string) (value Value, ok bool) {
 	}
 	thisEntry := element.Value.(*entry)
 	kc.removeElement(element)
-	kc.cache.Add(thisEntry.key, thisEntry.value)
+	if thisEntry.expired(now()) {
+		kc.cache.expired++
+		kc.cache.onEviction(thisEntry.key, thisEntry.value, EvictExpired)
+		return nil, false
+	}
+	// keeps the deadline it's added with
+	kc.cache.add(thisEntry.key, thisEntry.value, thisEntry.expire)
+	kc.promotions++
 	return thisEntry.value, true
 }
 
 func (kc *KCache) Len() int {
 	return kc.cache.ll.Len() + kc.fifoll.Len()
 }
+
+func (kc *KCache) Stats() Stats {
+	return Stats{
+		Bytes:         int64(kc.cache.usedBytes),
+		Items:         int64(kc.Len()),
+		Evictions:     kc.cache.evictions + kc.fifoEvictions,
+		Expired:       kc.cache.expired,
+		FifoItems:     int64(kc.fifoll.Len()),
+		FifoEvictions: kc.fifoEvictions,
+		Promotions:    kc.promotions,
+	}
+}
+
+// Remove deletes the key from either list and tells whether it was there
+func (kc *KCache) Remove(key string) bool {
+	element, ok := kc.fifoMap[key]
+	if !ok {
+		return kc.cache.Remove(key)
+	}
+	thisEntry := element.Value.(*entry)
+	kc.removeElement(element)
+	kc.cache.onEviction(thisEntry.key, thisEntry.value, EvictRemoved)
+	return true
+}
+
+// RemoveExpired drops all expired entries in both lists
+// and returns how many are dropped
+func (kc *KCache) RemoveExpired() int {
+	t := now()
+	count := 0
+	for element := kc.fifoll.Back(); element != nil; {
+		prev := element.Prev()
+		if thisEntry := element.Value.(*entry); thisEntry.expired(t) {
+			kc.removeElement(element)
+			kc.cache.expired++
+			kc.cache.onEviction(thisEntry.key, thisEntry.value, EvictExpired)
+			count++
+		}
+		element = prev
+	}
+	return count + kc.cache.RemoveExpired()
+}
@@ -53,3 +53,34 @@ func TestAddExceedMaxCapK(t *testing.T) {
 		t.Error("didn't refuse add that exceeds max capacity")
 	}
 }
+
+func TestKStats(t *testing.T) {
+	kc := NewK(10, nil)
+	kc.fifoSize = 2
+	kc.Add("a", make(testBytes, 1))
+	kc.Add("b", make(testBytes, 1))
+	kc.Get("a")                     // a is promoted to LRU
+	kc.Add("c", make(testBytes, 1)) // b, c in FIFO
+	kc.Add("d", make(testBytes, 1)) // b is evicted from FIFO
+
+	got := kc.Stats()
+	want := Stats{
+		Bytes:         6,
+		Items:         3,
+		Evictions:     1,
+		FifoItems:     2,
+		FifoEvictions: 1,
+		Promotions:    1,
+	}
+	if got != want {
+		t.Errorf("expecting %+v, got %+v", want, got)
+	}
+
+	c := New(4, nil)
+	c.Add("a", make(testBytes, 1))
+	c.Add("b", make(testBytes, 1))
+	c.Add("c", make(testBytes, 1))
+	if s := c.Stats(); s.Items != 2 || s.Bytes != 4 || s.Evictions != 1 {
+		t.Errorf("unexpected LRU stats %+v", s)
+	}
+}
@@ -0,0 +1,134 @@
+package lru_k
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock replaces now until the returned func is called
+func fakeClock(t time.Time) (advance func(time.Duration), restore func()) {
+	now = func() time.Time { return t }
+	advance = func(d time.Duration) {
+		t = t.Add(d)
+	}
+	restore = func() { now = time.Now }
+	return advance, restore
+}
+
+type evicted struct {
+	key    string
+	reason EvictionReason
+}
+
+func TestCacheTTL(t *testing.T) {
+	advance, restore := fakeClock(time.Unix(0, 0))
+	defer restore()
+
+	got := []evicted{}
+	c := NewWithCallback(50, func(key string, value Value, reason EvictionReason) {
+		got = append(got, evicted{key, reason})
+	})
+	c.AddWithTTL("short", make(testBytes, 1), time.Second)
+	c.AddWithTTL("long", make(testBytes, 1), time.Minute)
+	c.Add("forever", make(testBytes, 1))
+
+	advance(time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Error("short should have expired")
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Error("long shouldn't have expired")
+	}
+
+	advance(time.Hour)
+	if n := c.RemoveExpired(); n != 1 {
+		t.Errorf("expecting 1 entry reclaimed, got %d", n)
+	}
+	if _, ok := c.Get("forever"); !ok || c.Len() != 1 || c.usedBytes != len("forever")+1 {
+		t.Error("forever shouldn't expire")
+	}
+
+	// capacity eviction has a different reason
+	c.Add("big", make(testBytes, 45))
+
+	want := []evicted{{"short", EvictExpired}, {"long", EvictExpired}, {"forever", EvictCapacity}}
+	if len(got) != len(want) {
+		t.Fatalf("expecting %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expecting %v, got %v", want, got)
+		}
+	}
+	if s := c.Stats(); s.Expired != 2 || s.Evictions != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestKCacheTTL(t *testing.T) {
+	advance, restore := fakeClock(time.Unix(0, 0))
+	defer restore()
+
+	reasons := map[string]EvictionReason{}
+	kc := NewKWithCallback(50, func(key string, value Value, reason EvictionReason) {
+		reasons[key] = reason
+	})
+	kc.fifoSize = 4
+	kc.AddWithTTL("fifo", make(testBytes, 1), time.Second)
+	kc.AddWithTTL("lru", make(testBytes, 1), time.Second)
+	kc.AddWithTTL("stale", make(testBytes, 1), time.Second)
+	kc.Get("lru") // promoted, keeps its deadline
+
+	advance(time.Second)
+	if _, ok := kc.Get("lru"); ok {
+		t.Error("promoted entry should keep its deadline")
+	}
+	if _, ok := kc.Get("fifo"); ok {
+		t.Error("entry in FIFO should expire too")
+	}
+	if n := kc.RemoveExpired(); n != 1 || kc.Len() != 0 || kc.cache.usedBytes != 0 {
+		t.Errorf("expecting everything reclaimed, got %d/%d/%d", n, kc.Len(), kc.cache.usedBytes)
+	}
+	for _, key := range []string{"fifo", "lru", "stale"} {
+		if r, ok := reasons[key]; !ok || r != EvictExpired {
+			t.Errorf("%s should be reported as expired", key)
+		}
+	}
+
+	// modifying resets the ttl
+	kc.AddWithTTL("k", make(testBytes, 1), time.Second)
+	kc.AddWithTTL("k", make(testBytes, 2), time.Minute)
+	advance(time.Second)
+	if v, ok := kc.Get("k"); !ok || v.Len() != 2 {
+		t.Error("ttl should be reset on modify")
+	}
+	if kc.Len() != 1 {
+		t.Error("modifying shouldn't duplicate the entry")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := map[string]EvictionReason{}
+	kc := NewKWithCallback(50, func(key string, value Value, reason EvictionReason) {
+		reasons[key] = reason
+	})
+	kc.Add("fifo", make(testBytes, 1))
+	kc.Add("lru", make(testBytes, 1))
+	kc.Get("lru")
+
+	if !kc.Remove("fifo") || !kc.Remove("lru") {
+		t.Error("existing keys should be removed")
+	}
+	if kc.Remove("fifo") {
+		t.Error("key is already removed")
+	}
+	if kc.Len() != 0 || kc.cache.usedBytes != 0 || kc.fifoLen != 0 {
+		t.Error("bookkeeping isn't updated")
+	}
+	if reasons["fifo"] != EvictRemoved || reasons["lru"] != EvictRemoved {
+		t.Errorf("wrong reasons %v", reasons)
+	}
+	if s := kc.Stats(); s.Evictions != 0 || s.Expired != 0 {
+		t.Errorf("removal isn't eviction, got %+v", s)
+	}
+}
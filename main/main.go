@@ -9,7 +9,7 @@ import (
 
 // used in tests, start up by ./script.sh
 func main() {
-	g := geecache.NewGroup("getRemoteGroup", 10, geecache.GetterFunc(func(key string) ([]byte, error) {
+	g := geecache.MustNewGroup("getRemoteGroup", 10, geecache.GetterFunc(func(key string) ([]byte, error) {
 		// don't change this, it's relied on by a test
 		return []byte("remote"), nil
 	}))
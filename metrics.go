@@ -0,0 +1,249 @@
+package geecache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics are exposed in the Prometheus text exposition format
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+// The format is simple enough that pulling in the client library isn't worth it.
+
+// latencyBuckets are upper bounds in seconds.
+// Peers are told to give up after 200ms by default (see defaultPeerTimeout),
+// so buckets are denser below that.
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .2, .5, 1}
+
+// histogram counts observations into latencyBuckets, safe for concurrent use
+type histogram struct {
+	counts []AtomicInt // counts[i] is not cumulative, counts[len(latencyBuckets)] is +Inf
+	sumNs  AtomicInt
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]AtomicInt, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	sec := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, sec)
+	h.counts[i].Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// peerMetrics is shared by the HTTPGetters talking to the same peer
+type peerMetrics struct {
+	latency  *histogram
+	errors   AtomicInt
+	inFlight AtomicInt
+	// poolInFlight is the sum of inFlight of all peers of the pool
+	poolInFlight *AtomicInt
+}
+
+func newPeerMetrics(poolInFlight *AtomicInt) *peerMetrics {
+	return &peerMetrics{latency: newHistogram(), poolInFlight: poolInFlight}
+}
+
+// begin counts a request as in flight until it's recorded
+func (pm *peerMetrics) begin() {
+	if pm == nil {
+		return
+	}
+	pm.inFlight.Add(1)
+	pm.poolInFlight.Add(1)
+}
+
+// record is nil-safe so that HTTPGetters built by hand work too
+func (pm *peerMetrics) record(start time.Time, err error) {
+	if pm == nil {
+		return
+	}
+	pm.inFlight.Add(-1)
+	pm.poolInFlight.Add(-1)
+	pm.latency.observe(time.Since(start))
+	if err != nil {
+		pm.errors.Add(1)
+	}
+}
+
+// metricsWriter writes families one after another
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one line, labels come in name/value pairs
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			fmt.Fprintf(mw.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// MetricsHandler serves the metrics of the groups p serves and of its peers.
+// Mount it next to p, eg.
+//
+//	mux.Handle("/metrics", p.MetricsHandler())
+func (p *HTTPPool) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes what MetricsHandler serves
+func (p *HTTPPool) WriteMetrics(w io.Writer) error {
+	mw := metricsWriter{w: bufio.NewWriter(w)}
+	writeGroupMetrics(mw, snapshotGroups(p.cache))
+	p.writePeerMetrics(mw)
+	return mw.w.Flush()
+}
+
+type groupSnapshot struct {
+	name  string
+	stats Stats
+	main  CacheStats
+	hot   CacheStats
+}
+
+// snapshotGroups reads the stats of the groups of c, sorted by name
+func snapshotGroups(c *Cache) []groupSnapshot {
+	groups := c.Groups()
+	ret := make([]groupSnapshot, 0, len(groups))
+	for _, g := range groups {
+		ret = append(ret, groupSnapshot{
+			name:  g.name,
+			stats: g.Stats(),
+			main:  g.CacheStats(MainCache),
+			hot:   g.CacheStats(HotCache),
+		})
+	}
+	return ret
+}
+
+func writeGroupMetrics(mw metricsWriter, snaps []groupSnapshot) {
+	counters := []struct {
+		name string
+		help string
+		get  func(Stats) int64
+	}{
+		{"geecache_gets_total", "Gets on the group, including those from peers.", func(s Stats) int64 { return s.Gets }},
+		{"geecache_main_cache_hits_total", "Gets served by the main cache.", func(s Stats) int64 { return s.MainCacheHits }},
+		{"geecache_hot_cache_hits_total", "Gets served by the hot cache.", func(s Stats) int64 { return s.HotCacheHits }},
+		{"geecache_peer_loads_total", "Misses loaded from a peer.", func(s Stats) int64 { return s.PeerLoads }},
+		{"geecache_peer_errors_total", "Misses that failed to load from a peer.", func(s Stats) int64 { return s.PeerErrors }},
+		{"geecache_local_loads_total", "Misses loaded by the Getter.", func(s Stats) int64 { return s.LocalLoads }},
+		{"geecache_local_load_errors_total", "Misses that the Getter failed to load.", func(s Stats) int64 { return s.LocalLoadErrs }},
+		{"geecache_loads_deduped_total", "Misses that waited on an identical load in flight.", func(s Stats) int64 { return s.LoadsDeduped }},
+		{"geecache_hedged_loads_total", "Replicas asked because the one asked before was slow.", func(s Stats) int64 { return s.HedgedLoads }},
+		{"geecache_server_requests_total", "Gets that came from peers.", func(s Stats) int64 { return s.ServerRequests }},
+	}
+	for _, c := range counters {
+		mw.header(c.name, "counter", c.help)
+		for _, snap := range snaps {
+			mw.sample(c.name, float64(c.get(snap.stats)), "group", snap.name)
+		}
+	}
+
+	cacheMetrics := []struct {
+		name string
+		typ  string
+		help string
+		get  func(CacheStats) int64
+	}{
+		{"geecache_cache_gets_total", "counter", "Lookups on the cache.", func(s CacheStats) int64 { return s.Gets }},
+		{"geecache_cache_hits_total", "counter", "Lookups that hit.", func(s CacheStats) int64 { return s.Hits }},
+		{"geecache_cache_evictions_total", "counter", "Entries evicted due to capacity.", func(s CacheStats) int64 { return s.Evictions }},
+		{"geecache_cache_bytes", "gauge", "Bytes of keys and values held.", func(s CacheStats) int64 { return s.Bytes }},
+		{"geecache_cache_items", "gauge", "Entries held.", func(s CacheStats) int64 { return s.Items }},
+	}
+	for _, c := range cacheMetrics {
+		mw.header(c.name, c.typ, c.help)
+		for _, snap := range snaps {
+			mw.sample(c.name, float64(c.get(snap.main)), "group", snap.name, "cache", MainCache.String())
+			mw.sample(c.name, float64(c.get(snap.hot)), "group", snap.name, "cache", HotCache.String())
+		}
+	}
+}
+
+func (p *HTTPPool) writePeerMetrics(mw metricsWriter) {
+	members := p.ring.Load().peers.Len()
+	p.mu.Lock()
+	metrics := make(map[string]*peerMetrics, len(p.peerMetrics))
+	for name, pm := range p.peerMetrics {
+		metrics[name] = pm
+	}
+	p.mu.Unlock()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mw.header("geecache_ring_members", "gauge", "Physical nodes on the consistent hash ring.")
+	mw.sample("geecache_ring_members", float64(members))
+
+	const latency = "geecache_peer_request_duration_seconds"
+	mw.header(latency, "histogram", "Latency of requests sent to peers.")
+	for _, name := range names {
+		h := metrics[name].latency
+		cumulative := int64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i].Get()
+			mw.sample(latency+"_bucket", float64(cumulative), "peer", name, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		cumulative += h.counts[len(latencyBuckets)].Get()
+		mw.sample(latency+"_bucket", float64(cumulative), "peer", name, "le", "+Inf")
+		mw.sample(latency+"_sum", time.Duration(h.sumNs.Get()).Seconds(), "peer", name)
+		mw.sample(latency+"_count", float64(cumulative), "peer", name)
+	}
+
+	const inFlight = "geecache_peer_requests_in_flight"
+	mw.header(inFlight, "gauge", "Requests sent to peers that haven't been answered yet.")
+	for _, name := range names {
+		mw.sample(inFlight, float64(metrics[name].inFlight.Get()), "peer", name)
+	}
+
+	const errs = "geecache_peer_request_errors_total"
+	mw.header(errs, "counter", "Requests to peers that failed.")
+	for _, name := range names {
+		mw.sample(errs, float64(metrics[name].errors.Get()), "peer", name)
+	}
+
+	if p.health != nil {
+		mw.header("geecache_peer_up", "gauge", "Whether the health checker considers the peer up.")
+		for _, name := range names {
+			up := 1.0
+			if p.health.isDown(name) {
+				up = 0
+			}
+			mw.sample("geecache_peer_up", up, "peer", name)
+		}
+	}
+}
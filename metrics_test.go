@@ -0,0 +1,59 @@
+package geecache
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	g := newTestGroup(t, "metricsGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19626)
+	g.RegisterPeers(p)
+	g.Get("k")
+	g.Get("k")
+
+	peer := "http://0.0.0.0:19627/geecache/"
+	p.AddPeers(peer)
+	p.ring.Load().httpGetters[peer].metrics.record(time.Now().Add(-30*time.Millisecond), nil)
+	p.ring.Load().httpGetters[peer].metrics.record(time.Now(), errors.New("failed"))
+
+	rec := httptest.NewRecorder()
+	p.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	text := string(body)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	for _, want := range []string{
+		"# TYPE geecache_gets_total counter",
+		`geecache_gets_total{group="metricsGroup"} 2`,
+		`geecache_main_cache_hits_total{group="metricsGroup"} 1`,
+		`geecache_local_loads_total{group="metricsGroup"} 1`,
+		`geecache_cache_items{group="metricsGroup",cache="main"} 1`,
+		`geecache_cache_evictions_total{group="metricsGroup",cache="hot"} 0`,
+		"geecache_ring_members 2",
+		"# TYPE geecache_peer_request_duration_seconds histogram",
+		`geecache_peer_request_duration_seconds_bucket{peer="` + peer + `",le="0.025"} 1`,
+		`geecache_peer_request_duration_seconds_bucket{peer="` + peer + `",le="0.05"} 2`,
+		`geecache_peer_request_duration_seconds_bucket{peer="` + peer + `",le="+Inf"} 2`,
+		`geecache_peer_request_duration_seconds_count{peer="` + peer + `"} 2`,
+		`geecache_peer_request_errors_total{peer="` + peer + `"} 1`,
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("wrong escape: %s", got)
+	}
+}
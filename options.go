@@ -0,0 +1,186 @@
+package geecache
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+	"github.com/Hawk-Zhou/better-groupcache/logger"
+	"github.com/Hawk-Zhou/better-groupcache/placement"
+)
+
+// GroupOption configures a Group when it's created by NewGroup
+type GroupOption func(*Group)
+
+// WithHotCacheBytes sets the capacity of hotCache.
+// It defaults to the maxBytes given to NewGroup, 0 disables hotCache.
+func WithHotCacheBytes(maxBytes int) GroupOption {
+	return func(g *Group) {
+		g.hotCache = cache{maxBytes: maxBytes}
+	}
+}
+
+// WithHotCachePolicy replaces the default RandomPromotion(10)
+func WithHotCachePolicy(policy HotCachePolicy) GroupOption {
+	return func(g *Group) {
+		g.hotPolicy = policy
+	}
+}
+
+// WithTTL makes entries of both caches expire after ttl.
+// Expired entries are dropped lazily when looked up, see WithJanitor.
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithJanitor reclaims expired entries every interval in the background.
+// The goroutine runs until the group is closed, see Group.Close.
+func WithJanitor(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.janitorInterval = interval
+	}
+}
+
+// WithLogger sets the logger of the group and of its singleflight.
+// The group name is attached to every line.
+func WithLogger(l logger.Logger) GroupOption {
+	return func(g *Group) {
+		g.logger = logger.OrNop(l)
+	}
+}
+
+// WithReplicas reads each key from up to n owners, the primary first.
+// The next owner is asked if one fails, so losing the primary doesn't
+// lose its keys until the ring rebalances. Every owner caches the key in
+// mainCache. The PeerPicker must be a ReplicaPicker, eg. HTTPPool.
+// Groups of the same name should have the same n everywhere.
+func WithReplicas(n int) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+	}
+}
+
+// WithHedgedRequests also asks the next owner when the one asked last
+// hasn't answered within delay, the first answer wins. It trades load
+// on replicas for tail latency and only works with WithReplicas.
+// Batches of GetMany fail over but aren't hedged.
+func WithHedgedRequests(delay time.Duration) GroupOption {
+	return func(g *Group) {
+		g.hedgeDelay = delay
+	}
+}
+
+// PoolOption configures an HTTPPool when it's created by NewHTTPPool
+type PoolOption func(*HTTPPool)
+
+// WithPoolLogger sets the logger of the pool
+func WithPoolLogger(l logger.Logger) PoolOption {
+	return func(p *HTTPPool) {
+		p.logger = logger.OrNop(l)
+	}
+}
+
+// WithHealthCheck pings every peer each interval and marks a peer down
+// after downAfter consecutive failures. Keys of a peer that is down go
+// to the next node on the ring until a ping succeeds again.
+// Call HTTPPool.Close to stop it.
+func WithHealthCheck(interval time.Duration, downAfter int) PoolOption {
+	return func(p *HTTPPool) {
+		p.healthInterval = interval
+		p.healthDownAfter = downAfter
+	}
+}
+
+// WithPeerStateFunc is told when a peer is marked down or restored
+func WithPeerStateFunc(fn PeerStateFunc) PoolOption {
+	return func(p *HTTPPool) {
+		p.onPeerState = fn
+	}
+}
+
+// WithBoundedLoad turns on consistent hashing with bounded loads.
+// No node is given more than (1+epsilon) times its share of the requests
+// in flight, keys of a node that is full go to the next node on the ring.
+// Load is counted as the pool sees it: requests it sent to a peer that
+// are still in flight, and requests from peers it's serving itself.
+// See placement.Placement.SetBoundedLoad.
+func WithBoundedLoad(epsilon float64) PoolOption {
+	return func(p *HTTPPool) {
+		p.boundedLoad = true
+		p.loadEpsilon = epsilon
+	}
+}
+
+// WithPlacement picks how keys are placed on peers, eg.
+//
+//	NewHTTPPool(port, WithPlacement(placement.NewMaglev))
+//
+// It defaults to a consistent hash ring, see WithHasher and
+// WithRingOptions. Every pool of the cluster must use the same placement,
+// the ring hash tells if they don't.
+func WithPlacement(newPlacement func() placement.Placement) PoolOption {
+	return func(p *HTTPPool) {
+		p.newPlacement = newPlacement
+	}
+}
+
+// WithHasher hashes the consistent hash ring by a built-in hasher of
+// consistentHash, eg. "xxhash64:42", see consistentHash.HasherByName.
+// It defaults to CRC32 and is ignored if WithPlacement is given.
+// An unknown name is reported by HTTPPool.Validate.
+// Pools of different hashers refuse to talk to each other.
+func WithHasher(name string) PoolOption {
+	return func(p *HTTPPool) {
+		p.hasher = name
+	}
+}
+
+// WithRingOptions builds the consistent hash ring with opts, eg.
+//
+//	NewHTTPPool(port, WithRingOptions(consistentHash.WithVNodes(200)))
+//
+// They are ignored if WithPlacement is given. Pools whose rings are
+// built differently refuse to talk to each other, see HTTPPool.RingParams.
+func WithRingOptions(opts ...consistentHash.Option) PoolOption {
+	return func(p *HTTPPool) {
+		p.ringOpts = append(p.ringOpts, opts...)
+	}
+}
+
+// WithLabels tells where the pool is. Replicas of a key are spread
+// across zones and racks, and reads go to owners in the zone of the
+// pool first, see HTTPPool.PickPeers. Tell the pool where its peers are
+// with HTTPPool.SetPeerLabels.
+func WithLabels(labels consistentHash.Labels) PoolOption {
+	return func(p *HTTPPool) {
+		p.peerLabels["http://"+p.host+p.basePath] = labels
+	}
+}
+
+// HotCachePolicy decides whether a value loaded from its authoritative peer
+// should be kept in hotCache, so that later gets don't go over the network
+type HotCachePolicy interface {
+	ShouldPromote(key string, value ByteView) bool
+}
+
+type HotCachePolicyFunc func(key string, value ByteView) bool
+
+func (f HotCachePolicyFunc) ShouldPromote(key string, value ByteView) bool {
+	return f(key, value)
+}
+
+// RandomPromotion promotes one in n values.
+// Keys that are hot are fetched often, so they'll get promoted soon enough.
+func RandomPromotion(n int) HotCachePolicy {
+	return HotCachePolicyFunc(func(key string, value ByteView) bool {
+		return n > 0 && rand.Intn(n) == 0
+	})
+}
+
+// AlwaysPromote keeps every value fetched from peers
+var AlwaysPromote HotCachePolicy = HotCachePolicyFunc(func(string, ByteView) bool { return true })
+
+// NeverPromote disables hotCache
+var NeverPromote HotCachePolicy = HotCachePolicyFunc(func(string, ByteView) bool { return false })
@@ -1,5 +1,10 @@
 package geecache
 
+import (
+	"context"
+	"fmt"
+)
+
 // The whole process goes like: initialize to be group-aware or not.
 // Every time looking up a key, call portPicker to look at groupName.
 // Regardless of whether portPicker is init'ed to be group-aware or not,
@@ -11,6 +16,38 @@ type PeerGetter interface {
 	Get(group string, key string) ([]byte, error)
 }
 
+// ContextPeerGetter is a PeerGetter that carries deadlines and cancellation
+// to the peer. Group prefers it over Get when a PeerGetter implements it.
+type ContextPeerGetter interface {
+	GetContext(ctx context.Context, group string, key string) ([]byte, error)
+}
+
+// PeerRemover is a PeerGetter that can delete keys on its peer.
+// With hotOnly the peer drops the key from hotCache only,
+// which is how non-owners are told to invalidate.
+type PeerRemover interface {
+	Remove(ctx context.Context, group string, key string, hotOnly bool) error
+}
+
+// BatchPeerGetter is a PeerGetter that can ask its peer for many keys
+// in one request. The error is a KeyErrors if only some keys failed.
+type BatchPeerGetter interface {
+	GetMany(ctx context.Context, group string, keys []string) (map[string][]byte, error)
+}
+
+// PeerLister is a PeerPicker that can list all its peers but itself,
+// so that invalidation can be broadcast
+type PeerLister interface {
+	AllPeers() []PeerGetter
+}
+
+// ReplicaPicker is a PeerPicker that can tell up to n owners of a key
+// in order of preference, the primary first. A nil PeerGetter stands for
+// the picker itself. Groups read from replicas with WithReplicas.
+type ReplicaPicker interface {
+	PickPeers(key string, n int) []PeerGetter
+}
+
 // PeerPicker is already bound to a group if the portPicker is initialized
 // so it doesn't receive group name
 type PeerPicker interface {
@@ -18,3 +55,11 @@ type PeerPicker interface {
 }
 
 var portPicker func(group string) PeerPicker
+
+// peerName tells which peer a PeerGetter talks to for logging
+func peerName(pGetter PeerGetter) string {
+	if s, ok := pGetter.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", pGetter)
+}
@@ -0,0 +1,127 @@
+package placement
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+)
+
+// The benchmarks and reports compare the placements on
+//   - lookup speed: BenchmarkFindNode, BenchmarkFindN
+//   - memory: TestMemoryReport, the heap a placement of n nodes holds,
+//     and BenchmarkBuild, the cost of adding n nodes one by one
+//   - balance: TestBalanceReport, the most loaded node over the mean
+//   - key movement: TestMovementReport, the share of keys that move when
+//     a node is added or removed, next to the ideal share
+//
+// run them with
+//
+//	go test -run ^$ -bench . -benchmem ./placement
+//	go test -run Report -v ./placement
+
+var benchSizes = []int{10, 100, 1000}
+
+// buildSizes skip 1000 nodes, Maglev rebuilds its table on every add
+// and Rendezvous takes long to look up enough keys to tell the balance
+var buildSizes = []int{10, 100}
+
+func BenchmarkFindNode(b *testing.B) {
+	for _, pl := range placements {
+		for _, n := range benchSizes {
+			p := withNodes(pl.new, n)
+			keys := benchKeys(1024)
+			b.Run(fmt.Sprintf("%s/%d", pl.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.FindNode(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFindN(b *testing.B) {
+	for _, pl := range placements {
+		for _, n := range benchSizes {
+			p := withNodes(pl.new, n)
+			keys := benchKeys(1024)
+			b.Run(fmt.Sprintf("%s/%d", pl.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.FindN(keys[i%len(keys)], 3)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	for _, pl := range placements {
+		for _, n := range buildSizes {
+			b.Run(fmt.Sprintf("%s/%d", pl.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					withNodes(pl.new, n)
+				}
+			})
+		}
+	}
+}
+
+// The reports below measure a placement once rather than timing it,
+// see them with go test -run Report -v ./placement
+
+func TestMemoryReport(t *testing.T) {
+	for _, pl := range placements {
+		for _, n := range buildSizes {
+			before, after := runtime.MemStats{}, runtime.MemStats{}
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			p := withNodes(pl.new, n)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			t.Logf("%s/%d: %.0f heap-bytes", pl.name, n, float64(after.HeapAlloc)-float64(before.HeapAlloc))
+			runtime.KeepAlive(p)
+		}
+	}
+}
+
+func TestBalanceReport(t *testing.T) {
+	for _, pl := range placements {
+		for _, n := range buildSizes {
+			p := withNodes(pl.new, n)
+			got := shares(p, 100*n)
+			max, sumSq := 0.0, 0.0
+			mean := 1 / float64(n)
+			for i := 0; i < n; i++ {
+				share := got[fmt.Sprint("node", i)]
+				max = math.Max(max, share)
+				sumSq += (share - mean) * (share - mean)
+			}
+			t.Logf("%s/%d: %.3f max/mean, %.3f stddev/mean", pl.name, n, max/mean, math.Sqrt(sumSq/float64(n))/mean)
+		}
+	}
+}
+
+func TestMovementReport(t *testing.T) {
+	for _, pl := range placements {
+		for _, n := range buildSizes {
+			before := withNodes(pl.new, n)
+			after := before.Clone()
+			after.AddNode("new", 1)
+			t.Logf("%s/%d/add: %.2f %%moved, %.2f %%ideal", pl.name, n, 100*moved(before, after, 100*n), 100/float64(n+1))
+
+			after = before.Clone()
+			// not the last one in sorted order, which is the cheap case of Jump
+			after.RemoveNode("node0")
+			t.Logf("%s/%d/remove: %.2f %%moved, %.2f %%ideal", pl.name, n, 100*moved(before, after, 100*n), 100/float64(n))
+		}
+	}
+}
+
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+	}
+	return keys
+}
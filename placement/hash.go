@@ -0,0 +1,34 @@
+package placement
+
+import (
+	"fmt"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+)
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+	// golden is used to derive more hashes from one
+	golden = 0x9e3779b97f4a7c15
+)
+
+// hashString is the consistentHash.FNV1a64Mix hasher of s,
+// FNV alone doesn't spread short strings well enough
+func hashString(seed uint64, s string) uint64 {
+	h := uint64(fnvOffset) ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return consistentHash.Mix64(h)
+}
+
+// rehash derives the i-th hash of a key, for picking nodes other than the owner
+func rehash(h uint64, i int) uint64 {
+	return consistentHash.Mix64(h + uint64(i)*golden)
+}
+
+func errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("placement: "+format, args...)
+}
@@ -0,0 +1,86 @@
+package placement
+
+// Jump is jump consistent hash (Lamping and Veach). It needs no memory
+// but a bucket per unit of weight and is the fastest to look up.
+// Buckets are numbered, which would make the owners depend on the order
+// nodes are added and removed in, so the buckets are laid out by the
+// sorted names of the nodes on every change instead. Adding or removing
+// a node moves the keys of every bucket after it, so only nodes that
+// sort last move few keys. Prefer the others if membership changes often.
+type Jump struct {
+	nodeSet
+	buckets []string // never changed in place, so that clones can share it
+}
+
+func NewJump() Placement {
+	return &Jump{nodeSet: newNodeSet()}
+}
+
+func (j *Jump) AddNode(name string, weight int) error {
+	if err := j.add(name, weight); err != nil {
+		return err
+	}
+	j.layOut()
+	return nil
+}
+
+func (j *Jump) RemoveNode(name string) error {
+	if err := j.remove(name); err != nil {
+		return err
+	}
+	j.layOut()
+	return nil
+}
+
+// layOut gives each node as many buckets as its weight, in sorted order
+func (j *Jump) layOut() {
+	buckets := make([]string, 0, j.totalWeight)
+	for _, name := range j.names {
+		for i := 0; i < j.weights[name]; i++ {
+			buckets = append(buckets, name)
+		}
+	}
+	j.buckets = buckets
+}
+
+// jumpHash maps key to one of n buckets
+func jumpHash(key uint64, n int) int {
+	b, next := int64(-1), int64(0)
+	for next < int64(n) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (j *Jump) pick(h uint64) string {
+	return j.buckets[jumpHash(h, len(j.buckets))]
+}
+
+func (j *Jump) FindNode(key string) string {
+	keyHash := hashString(0, key)
+	return j.findNode(j.rehashWalker(keyHash, j.pick), func() string {
+		return j.pick(keyHash)
+	})
+}
+
+func (j *Jump) FindN(key string, n int) []string {
+	return j.findN(j.rehashWalker(hashString(0, key), j.pick), n)
+}
+
+func (j *Jump) FindNSpread(key string, n int) []string {
+	return j.findNSpread(j.rehashWalker(hashString(0, key), j.pick), n)
+}
+
+func (j *Jump) FindNodeFunc(key string, accept func(name string) bool) (string, bool) {
+	return j.findFunc(j.rehashWalker(hashString(0, key), j.pick), accept)
+}
+
+func (j *Jump) Fingerprint() uint64 {
+	return j.fingerprint("jump", j.buckets)
+}
+
+func (j *Jump) Clone() Placement {
+	return &Jump{nodeSet: j.clone(), buckets: j.buckets}
+}
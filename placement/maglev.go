@@ -0,0 +1,106 @@
+package placement
+
+// maglevSize is the size of the lookup table, a prime.
+// It should be much larger than the number of nodes, the paper suggests
+// 100 times, so it's good for a few hundred nodes.
+const maglevSize = 65537
+
+// Maglev is the consistent hashing of Google's Maglev load balancer
+// (Eisenbud et al., 2016). Nodes take turns filling a lookup table by
+// their own permutation of it, so a lookup is a single index and the
+// load is very even. The table costs 256 KB and is rebuilt on every
+// change, which moves slightly more keys than strictly needed.
+type Maglev struct {
+	nodeSet
+	// table holds indexes into names. It's replaced on change, never
+	// changed in place, so that clones can share it.
+	table []int32
+}
+
+func NewMaglev() Placement {
+	return &Maglev{nodeSet: newNodeSet()}
+}
+
+func (m *Maglev) AddNode(name string, weight int) error {
+	if err := m.add(name, weight); err != nil {
+		return err
+	}
+	m.populate()
+	return nil
+}
+
+func (m *Maglev) RemoveNode(name string) error {
+	if err := m.remove(name); err != nil {
+		return err
+	}
+	m.populate()
+	return nil
+}
+
+// populate fills the table, a node takes as many slots
+// as its weight in each turn
+func (m *Maglev) populate() {
+	if len(m.names) == 0 {
+		m.table = nil
+		return
+	}
+	offsets := make([]uint64, len(m.names))
+	skips := make([]uint64, len(m.names))
+	for i, name := range m.names {
+		offsets[i] = hashString(0, name) % maglevSize
+		skips[i] = hashString(golden, name)%(maglevSize-1) + 1
+	}
+
+	table := make([]int32, maglevSize)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, len(m.names))
+	filled := 0
+	for filled < maglevSize {
+		for i, name := range m.names {
+			for turn := 0; turn < m.weights[name] && filled < maglevSize; turn++ {
+				slot := (offsets[i] + next[i]*skips[i]) % maglevSize
+				for table[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % maglevSize
+				}
+				table[slot] = int32(i)
+				next[i]++
+				filled++
+			}
+		}
+	}
+	m.table = table
+}
+
+func (m *Maglev) pick(h uint64) string {
+	return m.names[m.table[h%maglevSize]]
+}
+
+func (m *Maglev) FindNode(key string) string {
+	keyHash := hashString(0, key)
+	return m.findNode(m.rehashWalker(keyHash, m.pick), func() string {
+		return m.pick(keyHash)
+	})
+}
+
+func (m *Maglev) FindN(key string, n int) []string {
+	return m.findN(m.rehashWalker(hashString(0, key), m.pick), n)
+}
+
+func (m *Maglev) FindNSpread(key string, n int) []string {
+	return m.findNSpread(m.rehashWalker(hashString(0, key), m.pick), n)
+}
+
+func (m *Maglev) FindNodeFunc(key string, accept func(name string) bool) (string, bool) {
+	return m.findFunc(m.rehashWalker(hashString(0, key), m.pick), accept)
+}
+
+func (m *Maglev) Fingerprint() uint64 {
+	return m.fingerprint("maglev", m.names)
+}
+
+func (m *Maglev) Clone() Placement {
+	return &Maglev{nodeSet: m.clone(), table: m.table}
+}
@@ -0,0 +1,341 @@
+// Package placement decides which nodes own a key.
+//
+// Placement is implemented by
+//   - Ring, the consistent hash ring of package consistentHash
+//   - Rendezvous, highest random weight hashing
+//   - Jump, jump consistent hash
+//   - Maglev, the lookup table of Google's Maglev load balancer
+//
+// They trade lookup speed, memory, balance and how many keys move when
+// membership changes differently, see bench_test.go for a comparison.
+// None of them is safe for concurrent use, but a Placement can be read
+// while its Clone is being changed.
+package placement
+
+import (
+	"math"
+	"sort"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+)
+
+// Placement maps keys to weighted nodes
+type Placement interface {
+	// AddNode puts a node of weight at least 1 in,
+	// a node of weight w owns about w times the keys one of weight 1 owns.
+	AddNode(name string, weight int) error
+	RemoveNode(name string) error
+	// FindNode returns the owner of key, "" if there's no node
+	FindNode(key string) string
+	// FindN returns up to n distinct nodes for key in order of preference,
+	// the owner first. The second is usually who owns the key once the
+	// owner is removed.
+	FindN(key string, n int) []string
+	// FindNodeFunc returns the first node in order of preference that
+	// accept says yes to. Each node is asked at most once.
+	FindNodeFunc(key string, accept func(name string) bool) (string, bool)
+	// SetBoundedLoad skips nodes above their share of the load,
+	// see consistentHash.CHash.SetBoundedLoad
+	SetBoundedLoad(loads consistentHash.LoadReporter, epsilon float64)
+
+	// SetLabels tells where a node is, see FindNSpread
+	SetLabels(name string, labels consistentHash.Labels) error
+	Labels(name string) consistentHash.Labels
+	// FindNSpread is FindN that spreads the nodes across zones, then racks,
+	// see consistentHash.Spread. The owner is always first.
+	FindNSpread(key string, n int) []string
+
+	// Weight returns the weight of a node, 0 if it doesn't exist
+	Weight(name string) int
+	// Nodes returns the names of all nodes, sorted
+	Nodes() []string
+	Len() int
+	// Hasher names the hash function keys are placed by
+	Hasher() string
+	// Params tell how the placement is built besides its nodes,
+	// placements of different Params can't agree on owners
+	Params() string
+	// Fingerprint identifies the placement, placements that map keys
+	// the same way have the same fingerprint
+	Fingerprint() uint64
+	Clone() Placement
+}
+
+// Ring is the consistent hash ring as a Placement
+type Ring struct {
+	*consistentHash.CHash
+}
+
+func NewRing() Placement {
+	return &Ring{consistentHash.NewCHash(nil)}
+}
+
+// RingByName returns a constructor of Rings that hash with a built-in
+// hasher, see consistentHash.HasherByName, and are built with opts
+func RingByName(hasher string, opts ...consistentHash.Option) (func() Placement, error) {
+	if _, err := consistentHash.HasherByName(hasher); err != nil {
+		return nil, err
+	}
+	return func() Placement {
+		ch, _ := consistentHash.NewCHashByName(hasher, opts...)
+		return &Ring{ch}
+	}, nil
+}
+
+func (r *Ring) Hasher() string {
+	return r.HasherName()
+}
+
+// Params is consistentHash.Params as a string
+func (r *Ring) Params() string {
+	return r.CHash.Params().String()
+}
+
+func (r *Ring) AddNode(name string, weight int) error {
+	return r.AddNodeWeighted(name, weight)
+}
+
+func (r *Ring) FindNode(key string) string {
+	if r.Len() == 0 {
+		return ""
+	}
+	return r.CHash.FindNode(key)
+}
+
+func (r *Ring) Nodes() []string {
+	names := make([]string, 0, len(r.NameToSalt))
+	for name := range r.NameToSalt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Ring) Clone() Placement {
+	return &Ring{r.CHash.Clone()}
+}
+
+// nodeSet is the bookkeeping shared by Rendezvous, Jump and Maglev
+type nodeSet struct {
+	weights     map[string]int
+	labels      map[string]consistentHash.Labels
+	names       []string // sorted
+	totalWeight int
+
+	// loads is nil unless bounded loads are on
+	loads   consistentHash.LoadReporter
+	epsilon float64
+}
+
+func newNodeSet() nodeSet {
+	return nodeSet{
+		weights: make(map[string]int),
+		labels:  make(map[string]consistentHash.Labels),
+	}
+}
+
+func (ns *nodeSet) add(name string, weight int) error {
+	if weight < 1 {
+		return errorf("weight of %s must be at least 1, got %d", name, weight)
+	}
+	if _, ok := ns.weights[name]; ok {
+		return errorf("the node %s already exists", name)
+	}
+	ns.weights[name] = weight
+	ns.totalWeight += weight
+	// names is replaced, never changed in place, so that clones can share it
+	i := sort.SearchStrings(ns.names, name)
+	names := make([]string, 0, len(ns.names)+1)
+	names = append(append(append(names, ns.names[:i]...), name), ns.names[i:]...)
+	ns.names = names
+	return nil
+}
+
+func (ns *nodeSet) remove(name string) error {
+	weight, ok := ns.weights[name]
+	if !ok {
+		return errorf("the node %s doesn't exist", name)
+	}
+	delete(ns.weights, name)
+	delete(ns.labels, name)
+	ns.totalWeight -= weight
+	i := sort.SearchStrings(ns.names, name)
+	names := make([]string, 0, len(ns.names)-1)
+	ns.names = append(append(names, ns.names[:i]...), ns.names[i+1:]...)
+	return nil
+}
+
+func (ns *nodeSet) clone() nodeSet {
+	weights := make(map[string]int, len(ns.weights))
+	for name, weight := range ns.weights {
+		weights[name] = weight
+	}
+	labels := make(map[string]consistentHash.Labels, len(ns.labels))
+	for name, l := range ns.labels {
+		labels[name] = l
+	}
+	return nodeSet{
+		weights:     weights,
+		labels:      labels,
+		names:       ns.names,
+		totalWeight: ns.totalWeight,
+		loads:       ns.loads,
+		epsilon:     ns.epsilon,
+	}
+}
+
+func (ns *nodeSet) Weight(name string) int {
+	return ns.weights[name]
+}
+
+func (ns *nodeSet) SetLabels(name string, labels consistentHash.Labels) error {
+	if _, ok := ns.weights[name]; !ok {
+		return errorf("the node %s doesn't exist", name)
+	}
+	if labels == (consistentHash.Labels{}) {
+		delete(ns.labels, name)
+		return nil
+	}
+	ns.labels[name] = labels
+	return nil
+}
+
+func (ns *nodeSet) Labels(name string) consistentHash.Labels {
+	return ns.labels[name]
+}
+
+// Hasher is hashString, which Rendezvous, Jump and Maglev all place keys by
+func (ns *nodeSet) Hasher() string {
+	return consistentHash.FNV1a64Mix
+}
+
+// Params is the hasher, nothing else is configurable
+func (ns *nodeSet) Params() string {
+	return ns.Hasher()
+}
+
+func (ns *nodeSet) Nodes() []string {
+	return append([]string{}, ns.names...)
+}
+
+func (ns *nodeSet) Len() int {
+	return len(ns.names)
+}
+
+func (ns *nodeSet) SetBoundedLoad(loads consistentHash.LoadReporter, epsilon float64) {
+	if epsilon < 0 {
+		epsilon = 0
+	}
+	ns.loads, ns.epsilon = loads, epsilon
+}
+
+// walkFunc visits distinct nodes in order of preference for a key
+// until visit returns true or every node is visited
+type walkFunc func(visit func(name string) bool)
+
+// rehashWalker visits the owner told by pick, then the owners of
+// rehashes of the key, then the nodes left in sorted order.
+// It's for placements that only know the owner of a hash.
+func (ns *nodeSet) rehashWalker(keyHash uint64, pick func(h uint64) string) walkFunc {
+	return func(visit func(name string) bool) {
+		visited := make(map[string]bool, len(ns.names))
+		for i := 0; i < 2*len(ns.names) && len(visited) < len(ns.names); i++ {
+			h := keyHash
+			if i > 0 {
+				h = rehash(keyHash, i)
+			}
+			name := pick(h)
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+			if visit(name) {
+				return
+			}
+		}
+		for _, name := range ns.names {
+			if !visited[name] && visit(name) {
+				return
+			}
+		}
+	}
+}
+
+// findN collects up to n nodes of walk
+func (ns *nodeSet) findN(walk walkFunc, n int) []string {
+	ret := make([]string, 0)
+	if n <= 0 {
+		return ret
+	}
+	walk(func(name string) bool {
+		ret = append(ret, name)
+		return len(ret) >= n
+	})
+	return ret
+}
+
+// findNSpread is FindNSpread on top of walk
+func (ns *nodeSet) findNSpread(walk walkFunc, n int) []string {
+	return consistentHash.Spread(n, ns.Labels, walk)
+}
+
+// findFunc is FindNodeFunc on top of walk, nodes over capacity are
+// skipped unless every accepted node is
+func (ns *nodeSet) findFunc(walk walkFunc, accept func(name string) bool) (name string, ok bool) {
+	find := func(accept func(name string) bool) {
+		walk(func(this string) bool {
+			if accept(this) {
+				name, ok = this, true
+			}
+			return ok
+		})
+	}
+	if ns.loads != nil {
+		total := ns.loads.TotalLoad()
+		find(func(name string) bool {
+			return accept(name) && ns.underCapacity(name, total)
+		})
+		if ok {
+			return name, ok
+		}
+	}
+	find(accept)
+	return name, ok
+}
+
+// findNode is the owner of the key as told by first,
+// or the first node under capacity with bounded loads on
+func (ns *nodeSet) findNode(walk walkFunc, first func() string) string {
+	if len(ns.names) == 0 {
+		return ""
+	}
+	if ns.loads == nil {
+		return first()
+	}
+	name, _ := ns.findFunc(walk, func(string) bool { return true })
+	return name
+}
+
+func (ns *nodeSet) underCapacity(name string, total int64) bool {
+	share := float64(ns.weights[name]) / float64(ns.totalWeight)
+	capacity := math.Ceil((1 + ns.epsilon) * float64(total+1) * share)
+	return float64(ns.loads.Load(name)) < capacity
+}
+
+// fingerprint hashes the kind of placement and its nodes in order
+func (ns *nodeSet) fingerprint(kind string, order []string) uint64 {
+	h := hashString(0, kind)
+	for _, name := range order {
+		h = consistentHash.Mix64(h ^ hashString(h, name))
+		h = consistentHash.Mix64(h ^ uint64(ns.weights[name]))
+		labels := ns.labels[name]
+		h = consistentHash.Mix64(h ^ hashString(h, labels.Zone))
+		h = consistentHash.Mix64(h ^ hashString(h, labels.Rack))
+	}
+	return h
+}
+
+var _ Placement = (*Ring)(nil)
+var _ Placement = (*Rendezvous)(nil)
+var _ Placement = (*Jump)(nil)
+var _ Placement = (*Maglev)(nil)
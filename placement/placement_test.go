@@ -0,0 +1,310 @@
+package placement
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", NewRing},
+	{"rendezvous", NewRendezvous},
+	{"jump", NewJump},
+	{"maglev", NewMaglev},
+}
+
+func withNodes(newPlacement func() Placement, n int) Placement {
+	p := newPlacement()
+	for i := 0; i < n; i++ {
+		if err := p.AddNode(fmt.Sprint("node", i), 1); err != nil {
+			panic(err)
+		}
+	}
+	return p
+}
+
+// shares counts the fraction of keys each node owns
+func shares(p Placement, keys int) map[string]float64 {
+	ret := make(map[string]float64)
+	for i := 0; i < keys; i++ {
+		ret[p.FindNode(fmt.Sprint("key", i))] += 1 / float64(keys)
+	}
+	return ret
+}
+
+// moved counts the fraction of keys whose owner differs
+func moved(before, after Placement, keys int) (ret float64) {
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprint("key", i)
+		if before.FindNode(key) != after.FindNode(key) {
+			ret += 1 / float64(keys)
+		}
+	}
+	return ret
+}
+
+func TestNodeOps(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := pl.new()
+			if p.FindNode("key") != "" || len(p.FindN("key", 3)) != 0 {
+				t.Error("empty placement should find nothing")
+			}
+			if _, ok := p.FindNodeFunc("key", func(string) bool { return true }); ok {
+				t.Error("empty placement should find nothing")
+			}
+			if err := p.AddNode("a", 0); err == nil {
+				t.Error("weight 0 should be rejected")
+			}
+			p.AddNode("b", 1)
+			p.AddNode("a", 2)
+			if err := p.AddNode("a", 1); err == nil {
+				t.Error("adding twice should fail")
+			}
+			if p.Len() != 2 || p.Weight("a") != 2 || p.Weight("c") != 0 {
+				t.Error("got wrong nodes")
+			}
+			if nodes := p.Nodes(); len(nodes) != 2 || nodes[0] != "a" || nodes[1] != "b" {
+				t.Errorf("got nodes %v", nodes)
+			}
+			if err := p.RemoveNode("c"); err == nil {
+				t.Error("removing a nonexistent node should fail")
+			}
+			if err := p.RemoveNode("a"); err != nil || p.Len() != 1 || p.FindNode("key") != "b" {
+				t.Error("b should be left alone")
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := withNodes(pl.new, 10)
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint("key", i)
+				got := p.FindN(key, 3)
+				if len(got) != 3 || got[0] != p.FindNode(key) {
+					t.Fatalf("expecting 3 nodes with the owner first, got %v", got)
+				}
+				if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+					t.Fatalf("nodes should be distinct, got %v", got)
+				}
+				next, ok := p.FindNodeFunc(key, func(name string) bool { return name != got[0] })
+				if !ok || next != got[1] {
+					t.Fatalf("skipping the owner should find %s, got %s", got[1], next)
+				}
+			}
+			if got := p.FindN("key", 20); len(got) != 10 {
+				t.Errorf("there are only 10 nodes to find, got %v", got)
+			}
+			asked := 0
+			if _, ok := p.FindNodeFunc("key", func(string) bool { asked++; return false }); ok || asked != 10 {
+				t.Errorf("each node should be asked once, got %d", asked)
+			}
+		})
+	}
+}
+
+func TestWeights(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := withNodes(pl.new, 8)
+			p.AddNode("big", 4)
+			got := shares(p, 100000)
+			// big is a third of the total weight,
+			// the ring with crc32 is the least even of all
+			if got["big"] < 0.5/3 || got["big"] > 1.5/3 {
+				t.Errorf("big owns %.3f of the keys, expecting about 0.333", got["big"])
+			}
+			for name, share := range got {
+				if name != "big" && (share < 0.5/12 || share > 1.5/12) {
+					t.Errorf("%s owns %.3f of the keys, expecting about 0.083", name, share)
+				}
+			}
+		})
+	}
+}
+
+func TestMovement(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			before := withNodes(pl.new, 10)
+			after := before.Clone()
+			// Jump moves few keys only for nodes that sort last
+			added := "new"
+			if pl.name == "jump" {
+				added = "zz-new"
+			}
+			after.AddNode(added, 1)
+
+			// ideally 1/11 of the keys move, all of them to the new node
+			keys := 20000
+			toOthers := 0
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprint("key", i)
+				if owner := after.FindNode(key); owner != before.FindNode(key) && owner != added {
+					toOthers++
+				}
+			}
+			if got := moved(before, after, keys); got > 2.0/11 {
+				t.Errorf("%.3f of the keys moved, expecting about 0.09", got)
+			}
+			// Maglev rebuilds its table, a few keys move between old nodes
+			if pl.name != "maglev" && toOthers > 0 {
+				t.Errorf("%d keys moved between old nodes", toOthers)
+			}
+			if pl.name == "maglev" && float64(toOthers)/float64(keys) > 0.02 {
+				t.Errorf("%d keys moved between old nodes", toOthers)
+			}
+		})
+	}
+}
+
+func TestCloneAndFingerprint(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := withNodes(pl.new, 5)
+			before := p.Fingerprint()
+			if withNodes(pl.new, 5).Fingerprint() != before {
+				t.Error("placements of the same nodes should agree")
+			}
+
+			clone := p.Clone()
+			if clone.Fingerprint() != before {
+				t.Error("clone should be the same placement")
+			}
+			clone.RemoveNode("node0")
+			clone.AddNode("node9", 1)
+			if clone.Fingerprint() == before {
+				t.Error("fingerprint should change with the nodes")
+			}
+			if p.Fingerprint() != before || p.Len() != 5 {
+				t.Error("changing the clone shouldn't change the original")
+			}
+			for i := 0; i < 1000; i++ {
+				if p.FindNode(fmt.Sprint("key", i)) == "node9" {
+					t.Fatal("the original shouldn't see nodes added to the clone")
+				}
+			}
+		})
+	}
+
+	seen := make(map[uint64]string)
+	for _, pl := range placements {
+		fp := withNodes(pl.new, 5).Fingerprint()
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s and %s have the same fingerprint", pl.name, other)
+		}
+		seen[fp] = pl.name
+	}
+}
+
+type fakeLoads map[string]int64
+
+func (fl fakeLoads) Load(name string) int64 {
+	return fl[name]
+}
+
+func (fl fakeLoads) TotalLoad() int64 {
+	total := int64(0)
+	for _, load := range fl {
+		total += load
+	}
+	return total
+}
+
+func TestBoundedLoad(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := withNodes(pl.new, 4)
+			owner := p.FindNode("hot")
+			loads := fakeLoads{}
+			p.SetBoundedLoad(loads, 0.25)
+
+			for i := 0; i < 100; i++ {
+				loads[p.FindNode("hot")]++
+			}
+			// capacity is ceil(1.25 * 100 / 4)
+			if len(loads) != 4 || loads[owner] > 32 {
+				t.Errorf("the hot key should be spread over all nodes, got %v", loads)
+			}
+			got, ok := p.FindNodeFunc("hot", func(name string) bool { return name == owner })
+			if !ok || got != owner {
+				t.Errorf("should fall back to %s, got %s", owner, got)
+			}
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			// 3 zones of 3 nodes each
+			p := withNodes(pl.new, 9)
+			zones := []string{"a", "b", "c"}
+			for i := 0; i < 9; i++ {
+				p.SetLabels(fmt.Sprint("node", i), consistentHash.Labels{Zone: zones[i%3]})
+			}
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint("key", i)
+				got := p.FindNSpread(key, 3)
+				if len(got) != 3 || got[0] != p.FindNode(key) {
+					t.Fatalf("expecting 3 nodes with the owner first, got %v", got)
+				}
+				seen := make(map[string]bool)
+				for _, name := range got {
+					seen[p.Labels(name).Zone] = true
+				}
+				if len(seen) != 3 {
+					t.Fatalf("nodes should be in 3 zones, got %v", got)
+				}
+			}
+
+			before := p.Fingerprint()
+			clone := p.Clone()
+			clone.SetLabels("node0", consistentHash.Labels{Zone: "b"})
+			if clone.Fingerprint() == before || p.Labels("node0").Zone != "a" {
+				t.Error("labels should be part of the fingerprint and not shared by clones")
+			}
+			if err := p.SetLabels("nowhere", consistentHash.Labels{}); err == nil {
+				t.Error("labeling a node that doesn't exist should fail")
+			}
+		})
+	}
+}
+
+func TestRingByName(t *testing.T) {
+	newRing, err := RingByName("xxhash64:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := newRing().Hasher(); got != "xxhash64:7" {
+		t.Errorf("the ring should record its hasher, got %s", got)
+	}
+	if NewRing().Hasher() != consistentHash.CRC32 || NewMaglev().Hasher() == "" {
+		t.Error("every placement should name its hasher")
+	}
+	withOpts, _ := RingByName(consistentHash.CRC32, consistentHash.WithVNodes(10))
+	if withOpts().Params() == NewRing().Params() || withOpts().Params() != "crc32/vnodes=10/salt=1" {
+		t.Errorf("options should be part of the params, got %s", withOpts().Params())
+	}
+	if _, err := RingByName("sha1"); err == nil {
+		t.Error("unknown hashers should be refused")
+	}
+
+	// the other placements hash as the hasher they are named after does
+	for _, seed := range []uint64{0, 42} {
+		hasher, err := consistentHash.HasherByName(fmt.Sprintf("%s:%d", NewJump().Hasher(), seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasher([]byte("key")) != hashString(seed, "key") {
+			t.Errorf("hashString of seed %d isn't %s", seed, NewJump().Hasher())
+		}
+	}
+}
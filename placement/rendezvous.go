@@ -0,0 +1,99 @@
+package placement
+
+import (
+	"math"
+	"sort"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+)
+
+// Rendezvous is highest random weight hashing (Thaler and Ravishankar).
+// Every node scores the key and the highest score owns it. Only the keys
+// of a removed node move, and nothing needs to be stored per key, but a
+// lookup scores every node, so it's O(n).
+// Weights use the logarithmic method of Schindelhauer and Resch.
+type Rendezvous struct {
+	nodeSet
+	nodeHashes map[string]uint64
+}
+
+func NewRendezvous() Placement {
+	return &Rendezvous{nodeSet: newNodeSet(), nodeHashes: make(map[string]uint64)}
+}
+
+func (rv *Rendezvous) AddNode(name string, weight int) error {
+	if err := rv.add(name, weight); err != nil {
+		return err
+	}
+	rv.nodeHashes[name] = hashString(0, name)
+	return nil
+}
+
+func (rv *Rendezvous) RemoveNode(name string) error {
+	if err := rv.remove(name); err != nil {
+		return err
+	}
+	delete(rv.nodeHashes, name)
+	return nil
+}
+
+// score is -weight/ln(u) for u uniform in (0, 1) picked by key and node
+func (rv *Rendezvous) score(keyHash uint64, name string) float64 {
+	h := consistentHash.Mix64(keyHash ^ rv.nodeHashes[name])
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(rv.weights[name]) / math.Log(u)
+}
+
+func (rv *Rendezvous) FindNode(key string) string {
+	keyHash := hashString(0, key)
+	return rv.findNode(rv.walker(keyHash), func() string {
+		best, bestScore := "", math.Inf(-1)
+		for _, name := range rv.names {
+			if s := rv.score(keyHash, name); s > bestScore {
+				best, bestScore = name, s
+			}
+		}
+		return best
+	})
+}
+
+func (rv *Rendezvous) FindN(key string, n int) []string {
+	return rv.findN(rv.walker(hashString(0, key)), n)
+}
+
+func (rv *Rendezvous) FindNSpread(key string, n int) []string {
+	return rv.findNSpread(rv.walker(hashString(0, key)), n)
+}
+
+func (rv *Rendezvous) FindNodeFunc(key string, accept func(name string) bool) (string, bool) {
+	return rv.findFunc(rv.walker(hashString(0, key)), accept)
+}
+
+// walker visits nodes from the highest score down
+func (rv *Rendezvous) walker(keyHash uint64) walkFunc {
+	return func(visit func(name string) bool) {
+		scores := make(map[string]float64, len(rv.names))
+		order := append([]string{}, rv.names...)
+		for _, name := range order {
+			scores[name] = rv.score(keyHash, name)
+		}
+		sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+		for _, name := range order {
+			if visit(name) {
+				return
+			}
+		}
+	}
+}
+
+func (rv *Rendezvous) Fingerprint() uint64 {
+	return rv.fingerprint("rendezvous", rv.names)
+}
+
+func (rv *Rendezvous) Clone() Placement {
+	nodeHashes := make(map[string]uint64, len(rv.nodeHashes))
+	for name, h := range rv.nodeHashes {
+		nodeHashes[name] = h
+	}
+	return &Rendezvous{nodeSet: rv.clone(), nodeHashes: nodeHashes}
+}
@@ -0,0 +1,136 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	pb "github.com/Hawk-Zhou/better-groupcache/geecachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+// removeRecorder is a fake peer that records the removals it receives
+type removeRecorder struct {
+	mu      sync.Mutex
+	removes []*pb.Request_Remove
+}
+
+func (rr *removeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	requestPb := &pb.Request{}
+	if err := proto.Unmarshal(body, requestPb); err != nil || requestPb.GetRemove() == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rr.mu.Lock()
+	rr.removes = append(rr.removes, requestPb.GetRemove())
+	rr.mu.Unlock()
+}
+
+func (rr *removeRecorder) take() []*pb.Request_Remove {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	ret := rr.removes
+	rr.removes = nil
+	return ret
+}
+
+func TestGroupRemove(t *testing.T) {
+	recorder := &removeRecorder{}
+	remote := httptest.NewServer(recorder)
+	defer remote.Close()
+
+	g := newTestGroup(t, "removeGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19631)
+	g.RegisterPeers(p)
+	p.AddPeers(remote.URL + defaultBasePath)
+
+	// find a key for each owner
+	localKey, remoteKey := "", ""
+	for i := 0; localKey == "" || remoteKey == ""; i++ {
+		key := fmt.Sprint(i)
+		if _, ok := p.PickPeer(key); ok {
+			remoteKey = key
+		} else {
+			localKey = key
+		}
+	}
+
+	ctx := context.Background()
+
+	// owned locally: dropped from mainCache, others invalidate hotCache
+	g.Get(localKey)
+	if err := g.Remove(ctx, localKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.get(localKey); ok {
+		t.Error("key should be removed from mainCache")
+	}
+	got := recorder.take()
+	if len(got) != 1 || !got[0].HotOnly || got[0].Key != localKey || got[0].Group != "removeGroup" {
+		t.Errorf("expecting one hot cache invalidation, got %v", got)
+	}
+
+	// owned remotely: the owner is told to remove, hotCache is dropped locally
+	g.hotCache.add(remoteKey, ByteView{b: []byte("stale")})
+	if err := g.Remove(ctx, remoteKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.hotCache.get(remoteKey); ok {
+		t.Error("key should be removed from hotCache")
+	}
+	got = recorder.take()
+	if len(got) != 1 || got[0].HotOnly || got[0].Key != remoteKey {
+		t.Errorf("expecting the owner to be asked to remove, got %v", got)
+	}
+}
+
+func TestAnswerRemove(t *testing.T) {
+	g := newTestGroup(t, "answerRemoveGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPool(19632)
+	g.RegisterPeers(p)
+
+	send := func(group string, hotOnly bool) int {
+		requestPb := &pb.Request{
+			Type: pb.Request_ISREMOVE,
+			Body: &pb.Request_Remove_{Remove: &pb.Request_Remove{Group: group, Key: "k", HotOnly: hotOnly}},
+		}
+		b, _ := proto.Marshal(requestPb)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, defaultBasePath, bytes.NewReader(b)))
+		return rec.Code
+	}
+
+	g.Get("k")
+	g.hotCache.add("k", ByteView{b: []byte("k")})
+
+	if code := send("answerRemoveGroup", true); code != http.StatusOK {
+		t.Errorf("unexpected code %d", code)
+	}
+	if _, ok := g.hotCache.get("k"); ok {
+		t.Error("hotCache should be invalidated")
+	}
+	if _, ok := g.mainCache.get("k"); !ok {
+		t.Error("mainCache shouldn't be touched by hot only removal")
+	}
+
+	if code := send("answerRemoveGroup", false); code != http.StatusOK {
+		t.Errorf("unexpected code %d", code)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Error("mainCache should be removed from")
+	}
+
+	if code := send("notExist", false); code != http.StatusNotFound {
+		t.Errorf("expecting not found for unknown group, got %d", code)
+	}
+}
@@ -0,0 +1,112 @@
+package geecache
+
+import (
+	"context"
+	"time"
+)
+
+// fromPeerKey marks loads asked for by a peer, see withFromPeer
+const fromPeerKey ctxKey = 1
+
+// withFromPeer tells the load that a peer is asking,
+// so a replica serves the key itself instead of going back to the primary,
+// which the peer has already tried
+func withFromPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fromPeerKey, true)
+}
+
+func fromPeer(ctx context.Context) bool {
+	v, _ := ctx.Value(fromPeerKey).(bool)
+	return v
+}
+
+// replicaOwners returns the owners of the key in order of preference if
+// the group reads from replicas, a nil PeerGetter is the group itself.
+// It returns nil if the group doesn't or its PeerPicker can't tell.
+func (g *Group) replicaOwners(key string) []PeerGetter {
+	if g.replicas < 2 {
+		return nil
+	}
+	picker, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return nil
+	}
+	owners := picker.PickPeers(key, g.replicas)
+	if len(owners) == 0 {
+		return nil
+	}
+	return owners
+}
+
+// loadFromReplicas asks the owners one after another until one succeeds.
+// The next owner is asked as soon as one fails, or, with hedging on,
+// when the last one asked is slower than hedgeDelay. The first value
+// back wins and the rest are cancelled.
+func (g *Group) loadFromReplicas(ctx context.Context, key string, owners []PeerGetter) (ByteView, error) {
+	if fromPeer(ctx) {
+		for _, owner := range owners {
+			if owner == nil {
+				return g.loadFrom(ctx, key, nil)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value ByteView
+		err   error
+	}
+	results := make(chan result, len(owners))
+	next, pending := 0, 0
+	// hedge fires when the last owner asked is too slow, nil if hedging is off
+	var hedge *time.Timer
+	var hedgeC <-chan time.Time
+	defer func() {
+		if hedge != nil {
+			hedge.Stop()
+		}
+	}()
+
+	ask := func() {
+		owner := owners[next]
+		next++
+		pending++
+		if next > 1 {
+			g.logger.Debug("asking replica", "key", key, "replica", next)
+		}
+		go func() {
+			value, err := g.loadFrom(ctx, key, owner)
+			results <- result{value, err}
+		}()
+		if hedge != nil {
+			hedge.Stop()
+		}
+		hedgeC = nil
+		if g.hedgeDelay > 0 && next < len(owners) {
+			hedge = time.NewTimer(g.hedgeDelay)
+			hedgeC = hedge.C
+		}
+	}
+
+	ask()
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.value, nil
+			}
+			err = r.err
+			if next < len(owners) && ctx.Err() == nil {
+				ask()
+			}
+		case <-hedgeC:
+			g.stats.hedgedLoads.Add(1)
+			ask()
+		}
+	}
+	return ByteView{}, err
+}
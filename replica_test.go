@@ -0,0 +1,339 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hawk-Zhou/better-groupcache/consistentHash"
+)
+
+// replicaPeer answers after delay, or fails if down
+type replicaPeer struct {
+	name  string
+	delay time.Duration
+	down  bool
+
+	mu        sync.Mutex
+	asked     int
+	cancelled int
+	hotOnly   []bool // of each Remove
+}
+
+func (rp *replicaPeer) Get(group string, key string) ([]byte, error) {
+	return rp.GetContext(context.Background(), group, key)
+}
+
+func (rp *replicaPeer) GetContext(ctx context.Context, group string, key string) ([]byte, error) {
+	rp.mu.Lock()
+	rp.asked++
+	rp.mu.Unlock()
+	select {
+	case <-time.After(rp.delay):
+	case <-ctx.Done():
+		rp.mu.Lock()
+		rp.cancelled++
+		rp.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if rp.down {
+		return nil, errors.New(rp.name + " is down")
+	}
+	return []byte(rp.name + " " + key), nil
+}
+
+func (rp *replicaPeer) Remove(ctx context.Context, group string, key string, hotOnly bool) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.hotOnly = append(rp.hotOnly, hotOnly)
+	return nil
+}
+
+func (rp *replicaPeer) count() (asked, cancelled int) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.asked, rp.cancelled
+}
+
+// replicaPicker gives the same owners for every key
+type replicaPicker []PeerGetter
+
+func (rp replicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	return rp[0], rp[0] != nil
+}
+
+func (rp replicaPicker) PickPeers(key string, n int) []PeerGetter {
+	if n > len(rp) {
+		n = len(rp)
+	}
+	return rp[:n]
+}
+
+func (rp replicaPicker) AllPeers() []PeerGetter {
+	ret := make([]PeerGetter, 0, len(rp))
+	for _, peer := range rp {
+		if peer != nil {
+			ret = append(ret, peer)
+		}
+	}
+	return ret
+}
+
+func localGetter(count *AtomicInt) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		count.Add(1)
+		return []byte("local " + key), nil
+	})
+}
+
+func TestReplicaFallback(t *testing.T) {
+	primary := &replicaPeer{name: "primary", down: true}
+	secondary := &replicaPeer{name: "secondary"}
+	local := AtomicInt(0)
+	g := newTestGroup(t, "replicaGroup", 1000, localGetter(&local), WithReplicas(2))
+	g.peers = replicaPicker{primary, secondary, nil}
+
+	v, err := g.Get("k")
+	if err != nil || v.String() != "secondary k" {
+		t.Fatalf("should fall back to secondary, got %v, %v", v, err)
+	}
+	if s := g.Stats(); s.PeerErrors != 1 || s.PeerLoads != 1 {
+		t.Errorf("expecting 1 peer error and 1 peer load, got %+v", s)
+	}
+
+	// the group itself is the third owner
+	secondary.down = true
+	g3 := newTestGroup(t, "replicaGroup3", 1000, localGetter(&local), WithReplicas(3))
+	g3.peers = replicaPicker{primary, secondary, nil}
+	v, err = g3.Get("k")
+	if err != nil || v.String() != "local k" || local.Get() != 1 {
+		t.Fatalf("should fall back to the Getter, got %v, %v", v, err)
+	}
+
+	// without WithReplicas only the primary is asked
+	g1 := newTestGroup(t, "replicaGroup1", 1000, localGetter(&local))
+	g1.peers = replicaPicker{primary, secondary, nil}
+	if _, err := g1.Get("k"); err == nil {
+		t.Error("the primary is down, there should be an error")
+	}
+}
+
+// batchReplicaPeer is a replicaPeer that takes BatchQuery
+type batchReplicaPeer struct {
+	*replicaPeer
+}
+
+func (bp batchReplicaPeer) GetMany(ctx context.Context, group string, keys []string) (map[string][]byte, error) {
+	ret := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, err := bp.GetContext(ctx, group, key)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = value
+	}
+	return ret, nil
+}
+
+func TestReplicaBatchFallback(t *testing.T) {
+	primary := batchReplicaPeer{&replicaPeer{name: "primary", down: true}}
+	secondary := batchReplicaPeer{&replicaPeer{name: "secondary"}}
+	local := AtomicInt(0)
+	c := NewCache()
+
+	g := c.MustNewGroup("replicaBatchGroup", 1000, localGetter(&local), WithReplicas(2))
+	g.peers = replicaPicker{primary, secondary, nil}
+	ret, err := g.GetMany(context.Background(), []string{"a", "b"})
+	if err != nil || ret["a"].String() != "secondary a" || ret["b"].String() != "secondary b" {
+		t.Fatalf("batches should fall back to secondary as Get does, got %v, %v", ret, err)
+	}
+
+	// the group itself is the third owner
+	secondary.down = true
+	g3 := c.MustNewGroup("replicaBatchGroup3", 1000, localGetter(&local), WithReplicas(3))
+	g3.peers = replicaPicker{primary, secondary, nil}
+	ret, err = g3.GetMany(context.Background(), []string{"a"})
+	if err != nil || ret["a"].String() != "local a" || local.Get() != 1 {
+		t.Fatalf("batches should fall back to the Getter, got %v, %v", ret, err)
+	}
+
+	// a replica asked by a peer serves its keys itself
+	other := batchReplicaPeer{&replicaPeer{name: "other"}}
+	gp := c.MustNewGroup("replicaBatchPeerGroup", 1000, localGetter(&local), WithReplicas(2))
+	gp.peers = replicaPicker{other, nil}
+	ret, err = gp.GetMany(withFromPeer(context.Background()), []string{"a"})
+	if err != nil || ret["a"].String() != "local a" {
+		t.Fatalf("a replica should serve a peer's batch itself, got %v, %v", ret, err)
+	}
+	if asked, _ := other.count(); asked != 0 {
+		t.Errorf("the primary shouldn't be asked again, got asked %d times", asked)
+	}
+}
+
+func TestReplicaRemove(t *testing.T) {
+	primary := &replicaPeer{name: "primary"}
+	secondary := &replicaPeer{name: "secondary"}
+	other := &replicaPeer{name: "other"}
+	local := AtomicInt(0)
+	g := newTestGroup(t, "replicaRemoveGroup", 1000, localGetter(&local), WithReplicas(3))
+	g.peers = replicaPicker{primary, nil, secondary, other}
+	g.populateCache("k", ByteView{b: []byte("v")})
+
+	if err := g.Remove(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	// replicas drop it from mainCache, the rest only from hotCache
+	if len(primary.hotOnly) != 1 || primary.hotOnly[0] ||
+		len(secondary.hotOnly) != 1 || secondary.hotOnly[0] ||
+		len(other.hotOnly) != 1 || !other.hotOnly[0] {
+		t.Errorf("got %v %v %v", primary.hotOnly, secondary.hotOnly, other.hotOnly)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Error("the group is a replica, it should drop the key from mainCache")
+	}
+}
+
+func TestReplicaFromPeer(t *testing.T) {
+	primary := &replicaPeer{name: "primary"}
+	local := AtomicInt(0)
+	g := newTestGroup(t, "replicaPeerGroup", 1000, localGetter(&local), WithReplicas(2))
+	g.peers = replicaPicker{primary, nil}
+
+	// a peer asking a replica has already tried the primary
+	v, err := g.GetContext(withFromPeer(context.Background()), "k")
+	if err != nil || v.String() != "local k" {
+		t.Fatalf("replica should serve the key itself, got %v, %v", v, err)
+	}
+	if asked, _ := primary.count(); asked != 0 {
+		t.Errorf("primary shouldn't be asked, got %d", asked)
+	}
+}
+
+func TestHedgedRequests(t *testing.T) {
+	slow := &replicaPeer{name: "slow", delay: time.Second}
+	fast := &replicaPeer{name: "fast"}
+	local := AtomicInt(0)
+	g := newTestGroup(t, "hedgedGroup", 1000, localGetter(&local),
+		WithReplicas(2), WithHedgedRequests(10*time.Millisecond))
+	g.peers = replicaPicker{slow, fast}
+
+	start := time.Now()
+	v, err := g.Get("k")
+	if err != nil || v.String() != "fast k" {
+		t.Fatalf("the fast replica should win, got %v, %v", v, err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("hedged get took %v", took)
+	}
+	if g.Stats().HedgedLoads != 1 {
+		t.Errorf("expecting 1 hedged load, got %d", g.Stats().HedgedLoads)
+	}
+	// the slow one is cancelled once fast wins
+	for i := 0; i < 100; i++ {
+		if _, cancelled := slow.count(); cancelled == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("the slow replica should be cancelled")
+}
+
+func TestHTTPPoolPickPeers(t *testing.T) {
+	p := NewHTTPPool(19643)
+	peers := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		peers = append(peers, fmt.Sprintf("http://10.0.0.%d:8000/geecache/", i))
+	}
+	p.AddPeers(peers...)
+	self := "http://" + p.host + p.basePath
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		owners := p.PickPeers(key, 3)
+		names := p.ring.Load().peers.FindN(key, 3)
+		if len(owners) != 3 {
+			t.Fatalf("expecting 3 owners, got %d", len(owners))
+		}
+		for j, owner := range owners {
+			if owner == nil && names[j] != self || owner != nil && peerName(owner) != names[j] {
+				t.Fatalf("owners of %s should be %v", key, names)
+			}
+		}
+	}
+}
+
+func TestZoneAwarePool(t *testing.T) {
+	// 3 zones of 3 peers, the pool is in zone a
+	p := NewHTTPPool(19644, WithLabels(consistentHash.Labels{Zone: "a", Rack: "0"}),
+		WithHealthCheck(time.Hour, 1))
+	defer p.Close()
+	self := "http://" + p.host + p.basePath
+	zones := []string{"a", "b", "c"}
+	labels := make(map[string]consistentHash.Labels)
+	peers := make([]string, 0, 8)
+	for i := 0; i < 8; i++ {
+		peer := fmt.Sprintf("http://10.0.0.%d:8000/geecache/", i)
+		peers = append(peers, peer)
+		labels[peer] = consistentHash.Labels{Zone: zones[(i+1)%3], Rack: fmt.Sprint(i / 3)}
+	}
+	// labels are kept for peers that join later
+	if err := p.SetPeerLabels(labels); err != nil {
+		t.Fatal(err)
+	}
+	p.AddPeers(peers...)
+	ring := p.ring.Load().peers
+
+	zoneOf := func(owner PeerGetter) string {
+		if owner == nil {
+			return "a"
+		}
+		return ring.Labels(peerName(owner)).Zone
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		owners := p.PickPeers(key, 3)
+		if len(owners) != 3 {
+			t.Fatalf("expecting 3 owners, got %d", len(owners))
+		}
+		seen := make(map[string]bool)
+		for _, owner := range owners {
+			seen[zoneOf(owner)] = true
+		}
+		if len(seen) != 3 {
+			t.Fatalf("owners of %s should be in 3 zones, got %v", key, seen)
+		}
+		// reads start in the zone of the pool
+		if zoneOf(owners[0]) != "a" {
+			t.Fatalf("owners of %s should start in zone a", key)
+		}
+	}
+
+	// the owner is down, a peer in zone a takes over
+	tested := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		owner := ring.FindNode(key)
+		if owner == self || ring.Labels(owner).Zone == "a" {
+			continue
+		}
+		tested++
+		p.health.report(owner, errors.New("down"))
+		peer, ok := p.PickPeer(key)
+		p.health.report(owner, nil)
+		if ok && zoneOf(peer) != "a" {
+			t.Fatalf("%s should go to zone a, got %s", key, peerName(peer))
+		}
+	}
+	if tested == 0 {
+		t.Fatal("no key is owned outside zone a")
+	}
+
+	unlabeled := NewHTTPPool(19644)
+	unlabeled.AddPeers(peers...)
+	if p.RingHash() == unlabeled.RingHash() {
+		t.Error("labels should be part of the ring hash")
+	}
+}
@@ -1,25 +1,47 @@
 package singleflight
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"sync"
+
+	"github.com/Hawk-Zhou/better-groupcache/logger"
 )
 
 // only access with pointer
 type call struct {
-	wg  sync.WaitGroup
-	ret interface{}
-	err error
+	// done is closed when the call returns
+	// it's a channel instead of a WaitGroup so that waiters can select on ctx
+	done chan struct{}
+	ret  interface{}
+	err  error
+	// abandoned is set if the ctx of the first caller was done when fn
+	// returned, err is then likely of that ctx rather than of the call
+	abandoned bool
 }
 
 // should only be referred with pointer
 type Group struct {
 	mu sync.Mutex
 	m  map[string]*call
+	// Logger is where Do logs its progress, nil means logger.Nop
+	Logger logger.Logger
 }
 
 func (g *Group) Do(key string, fn func() (interface{}, error)) (ret interface{}, retErr error) {
+	return g.DoContext(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// DoContext is Do with a context.
+// The first caller's ctx is handed to fn. Duplicated callers wait for fn
+// to return, or give up waiting with ctx.Err() once their own ctx is done.
+// Giving up doesn't cancel fn, other callers still get its result.
+// If fn fails once the first caller's ctx is done, duplicated callers
+// whose ctx isn't done call again instead of taking its error.
+func (g *Group) DoContext(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (ret interface{}, retErr error) {
+	log := logger.OrNop(g.Logger)
 	g.mu.Lock()
 
 	if g.m == nil {
@@ -31,36 +53,55 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (ret interface{},
 	// the call is duplicated
 	if ok {
 		g.mu.Unlock() // release lock
-		log.Printf("[singleflight.Do] Blocked dup %s", key)
-		c.wg.Wait()
-		return c.ret, c.err
+		log.Debug("singleflight: blocked duplicated call", "key", key)
+		select {
+		case <-c.done:
+			if c.abandoned && c.err != nil && ctx.Err() == nil {
+				log.Debug("singleflight: retrying call abandoned by its caller", "key", key)
+				return g.DoContext(ctx, key, fn)
+			}
+			return c.ret, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
 
 	// this is a new call
-	log.Printf("[singleflight.Do] Creating new call %s", key)
-	c = new(call)
-	c.wg.Add(1)
+	log.Debug("singleflight: creating new call", "key", key)
+	c = &call{done: make(chan struct{})}
 	g.m[key] = c
 	g.mu.Unlock()
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("[singleflight.Do] recovered from panic:", r)
+			log.Error("singleflight: recovered from panic", "key", key, "panic", r)
 			c.err = fmt.Errorf("call panicked and recovered in single flight: %s", r)
-			c.wg.Done() // give clearance to all other Do()s waiting on this
+			g.forget(key, c)
+			close(c.done) // give clearance to all other Do()s waiting on this
 			retErr = c.err
 		}
-		g.Forget(key)
-		log.Printf("[singleflight.Do] Cleaning up call %s", key)
+		log.Debug("singleflight: cleaning up call", "key", key)
 	}()
 
-	log.Printf("[singleflight.Do] Doing new call %s", key)
-	c.ret, c.err = fn()
-	c.wg.Done()
+	log.Debug("singleflight: doing new call", "key", key)
+	c.ret, c.err = fn(ctx)
+	c.abandoned = ctx.Err() != nil
+	// forgotten first, so that callers retrying it make a new call
+	g.forget(key, c)
+	close(c.done)
 
 	return c.ret, c.err
 }
 
+// forget drops c unless another call of key took its place
+func (g *Group) forget(key string, c *call) {
+	g.mu.Lock()
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
+}
+
 func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
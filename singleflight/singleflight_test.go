@@ -1,6 +1,8 @@
 package singleflight
 
 import (
+	"context"
+	"errors"
 	"log"
 	"sync"
 	"testing"
@@ -75,3 +77,80 @@ func TestPanicDo(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestDoContextCancelWaiter(t *testing.T) {
+	g := &Group{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go g.DoContext(context.Background(), "slow", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := g.DoContext(ctx, "slow", func(ctx context.Context) (interface{}, error) {
+		t.Error("duplicated call shouldn't run fn")
+		return nil, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+	if time.Since(begin) > 400*time.Millisecond {
+		t.Error("waiter didn't give up on its deadline")
+	}
+	close(release)
+}
+
+func TestDoContextPassesCtx(t *testing.T) {
+	g := &Group{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := g.DoContext(ctx, "cancelled", func(ctx context.Context) (interface{}, error) {
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("fn should see the caller's ctx, got %v", err)
+	}
+}
+
+func TestDoContextLeaderCancels(t *testing.T) {
+	g := &Group{}
+	started := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		leaderErr <- err
+	}()
+	<-started
+
+	waiterRet := make(chan interface{})
+	go func() {
+		ret, err := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			return "loaded", nil
+		})
+		if err != nil {
+			t.Errorf("the waiter's ctx is live, got %v", err)
+		}
+		waiterRet <- ret
+	}()
+	// let the waiter block on the first call
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("the first caller should be cancelled, got %v", err)
+	}
+	if ret := <-waiterRet; ret != "loaded" {
+		t.Errorf("the waiter should load again, got %v", ret)
+	}
+}
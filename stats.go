@@ -0,0 +1,102 @@
+package geecache
+
+import (
+	"sync/atomic"
+
+	"github.com/Hawk-Zhou/better-groupcache/lru_k"
+)
+
+// AtomicInt is an int64 to be accessed atomically
+type AtomicInt int64
+
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+// groupStats is updated on the fly by Group
+type groupStats struct {
+	gets           AtomicInt // any Get request, including from peers
+	mainCacheHits  AtomicInt
+	hotCacheHits   AtomicInt
+	peerLoads      AtomicInt // remote loads that succeeded
+	peerErrors     AtomicInt // remote loads that failed
+	localLoads     AtomicInt // loads by Getter that succeeded
+	localLoadErrs  AtomicInt // loads by Getter that failed
+	loadsDeduped   AtomicInt // loads that waited on another in singleflight
+	serverRequests AtomicInt // gets that came over the network from peers
+	hedgedLoads    AtomicInt // replicas asked because the one before was slow
+}
+
+// Stats is a snapshot of the counters of a Group
+type Stats struct {
+	Gets           int64
+	MainCacheHits  int64
+	HotCacheHits   int64
+	PeerLoads      int64
+	PeerErrors     int64
+	LocalLoads     int64
+	LocalLoadErrs  int64
+	LoadsDeduped   int64
+	ServerRequests int64
+	HedgedLoads    int64
+}
+
+// Stats returns a snapshot of the counters.
+// Counters are read one by one, so they may be slightly off from each other.
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:           g.stats.gets.Get(),
+		MainCacheHits:  g.stats.mainCacheHits.Get(),
+		HotCacheHits:   g.stats.hotCacheHits.Get(),
+		PeerLoads:      g.stats.peerLoads.Get(),
+		PeerErrors:     g.stats.peerErrors.Get(),
+		LocalLoads:     g.stats.localLoads.Get(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Get(),
+		LoadsDeduped:   g.stats.loadsDeduped.Get(),
+		ServerRequests: g.stats.serverRequests.Get(),
+		HedgedLoads:    g.stats.hedgedLoads.Get(),
+	}
+}
+
+// CacheType chooses one of the two caches of a Group
+type CacheType int
+
+const (
+	// MainCache holds keys this node is authoritative for
+	MainCache CacheType = iota + 1
+	// HotCache holds popular keys owned by other peers
+	HotCache
+)
+
+func (ct CacheType) String() string {
+	switch ct {
+	case MainCache:
+		return "main"
+	case HotCache:
+		return "hot"
+	}
+	return "unknown"
+}
+
+// CacheStats is the bookkeeping of the underlying lru_k.KCache
+// plus the gets and hits seen by the cache
+type CacheStats struct {
+	lru_k.Stats
+	Gets int64
+	Hits int64
+}
+
+// CacheStats returns a snapshot of one of the caches of the group
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	}
+	return CacheStats{}
+}
@@ -0,0 +1,49 @@
+package geecache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGroupStats(t *testing.T) {
+	g := newTestGroup(t, "statsGroup", 100, GetterFunc(func(key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, errors.New("bad key")
+		}
+		return []byte(key), nil
+	}), WithHotCachePolicy(AlwaysPromote))
+	g.RegisterPeers(NewHTTPPool(19625))
+
+	g.Get("local")
+	g.Get("local")
+	g.Get("bad")
+
+	// route everything to a peer from now on
+	peer := &fakePeer{}
+	g.peers = peer
+	g.Get("remote")
+	g.Get("remote")
+
+	got := g.Stats()
+	want := Stats{
+		Gets:          5,
+		MainCacheHits: 1,
+		HotCacheHits:  1,
+		PeerLoads:     1,
+		LocalLoads:    1,
+		LocalLoadErrs: 1,
+	}
+	if got != want {
+		t.Errorf("expecting %+v, got %+v", want, got)
+	}
+
+	main := g.CacheStats(MainCache)
+	if main.Items != 1 || main.Bytes != int64(len("local")*2) ||
+		main.Gets != 5 || main.Hits != 1 {
+		t.Errorf("unexpected main cache stats %+v", main)
+	}
+	hot := g.CacheStats(HotCache)
+	if hot.Items != 1 || hot.Gets != 4 || hot.Hits != 1 {
+		t.Errorf("unexpected hot cache stats %+v", hot)
+	}
+}